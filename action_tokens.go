@@ -0,0 +1,46 @@
+package platform_exercise
+
+import (
+	"os"
+	"time"
+
+	"github.com/campallison/platform-exercise/utils"
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+// Action tokens are signed like access tokens but carry a Purpose claim, so a
+// link emailed for one action can only be used for that action and never as a
+// bearer token.
+func signActionToken(purpose string, userID string, expiry time.Time, claims jwt.MapClaims) (string, error) {
+	allClaims := jwt.MapClaims{
+		"Id":        userID,
+		"ExpiresAt": expiry,
+		"Purpose":   purpose,
+	}
+	for key, value := range claims {
+		allClaims[key] = value
+	}
+
+	unsignedToken := jwt.NewWithClaims(jwt.GetSigningMethod("HS512"), allClaims)
+	return unsignedToken.SignedString([]byte(os.Getenv("SigningSecret")))
+}
+
+func parseActionToken(tokenString string, purpose string) (jwt.MapClaims, error) {
+	token, err := jwt.Parse(tokenString, signingKey)
+	if err != nil {
+		return nil, utils.ParseTokenError(err)
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid || claims["Purpose"] != purpose {
+		return nil, utils.InvalidTokenError()
+	}
+
+	expiresAt, _ := claims["ExpiresAt"].(string)
+	expiry, err := time.Parse(time.RFC3339Nano, expiresAt)
+	if err != nil || time.Now().After(expiry) {
+		return nil, utils.InvalidTokenError()
+	}
+
+	return claims, nil
+}
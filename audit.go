@@ -0,0 +1,297 @@
+package platform_exercise
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/campallison/platform-exercise/utils"
+	"gorm.io/gorm"
+)
+
+const (
+	auditLogin              = "login"
+	auditLoginFailed        = "login_failed"
+	auditLoginBlocked       = "login_blocked"
+	auditLoginChallenged    = "login_challenged"
+	auditLoginSourceBlocked = "login_source_blocked"
+	auditReauthenticated    = "reauthenticated"
+	auditLogout             = "logout"
+	auditUserCreated        = "user_created"
+	auditDuplicateSignup    = "duplicate_signup"
+	auditUserUpdated        = "user_updated"
+	auditUserDeleted        = "user_deleted"
+	auditUserErased         = "user_erased"
+	auditEmailChanged       = "email_changed"
+)
+
+const (
+	auditChainLockKey     = 4404
+	auditChainVerifyBatch = 1000
+
+	defaultListAuditEventsLimit = 50
+	maxListAuditEventsLimit     = 200
+)
+
+// piiDigest stands in for the IP and user agent in the hash chain. The salt
+// is random for each event and cleared with the PII on erasure, after which
+// the digest can no longer be matched against guessed addresses.
+func piiDigest(salt string, ip string, userAgent string) string {
+	if ip == "" && userAgent == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(salt + "\x00" + ip + "\x00" + userAgent))
+	return hex.EncodeToString(sum[:])
+}
+
+func newPIISalt() (string, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(salt), nil
+}
+
+func auditEventHash(event AuditEvent) string {
+	fields := []string{
+		event.PrevHash,
+		event.CreatedAt.UTC().Format(time.RFC3339Nano),
+		event.ActorID,
+		event.SubjectID,
+		event.Action,
+		event.ChangedFields,
+		event.PIIDigest,
+	}
+	// Events from before details existed were hashed without them.
+	if event.Details != "" {
+		fields = append(fields, event.Details)
+	}
+
+	b, _ := json.Marshal(fields)
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// recordAudit appends an event to the audit log. Appends are serialized with
+// an advisory lock so each event links to the one before it. Like sendMail it
+// is best effort: a failure is logged but never fails the audited request.
+func recordAudit(action string, subjectID string, meta RequestMeta, changedFields ...string) {
+	appendAudit(action, subjectID, meta, changedFields, "")
+}
+
+// recordAuditDetails is recordAudit for events that explain themselves, such
+// as a risk decision, with details stored as JSON.
+func recordAuditDetails(action string, subjectID string, meta RequestMeta, details interface{}) {
+	b, err := json.Marshal(details)
+	if err != nil {
+		log.Printf("\nCould not encode details of audit event %s for %q\n%v\n", action, subjectID, err)
+	}
+	appendAudit(action, subjectID, meta, nil, string(b))
+}
+
+func appendAudit(action string, subjectID string, meta RequestMeta, changedFields []string, details string) {
+	db := Init()
+
+	salt, err := newPIISalt()
+	if err != nil {
+		log.Printf("\nCould not record audit event %s for %q\n%v\n", action, subjectID, err)
+		return
+	}
+
+	sort.Strings(changedFields)
+	event := AuditEvent{
+		// Postgres keeps microseconds, and the hash must survive the round trip.
+		CreatedAt:     time.Now().UTC().Truncate(time.Microsecond),
+		ActorID:       meta.ActorID,
+		SubjectID:     subjectID,
+		Action:        action,
+		ChangedFields: strings.Join(changedFields, ","),
+		Details:       details,
+		IP:            meta.IP,
+		UserAgent:     meta.UserAgent,
+		PIISalt:       salt,
+		PIIDigest:     piiDigest(salt, meta.IP, meta.UserAgent),
+	}
+
+	if err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", auditChainLockKey).Error; err != nil {
+			return err
+		}
+
+		var last AuditEvent
+		if err := tx.Order("id DESC").Limit(1).Find(&last).Error; err != nil {
+			return err
+		}
+
+		event.PrevHash = last.Hash
+		event.Hash = auditEventHash(event)
+		return tx.Create(&event).Error
+	}); err != nil {
+		log.Printf("\nCould not record audit event %s for %q\n%v\n", action, subjectID, err)
+	}
+}
+
+// eraseAuditPII clears the IP, user agent and digest salt of every event a
+// user took part in, leaving only their pseudonymous ID.
+func eraseAuditPII(db *gorm.DB, userID string) error {
+	return db.Model(&AuditEvent{}).
+		Where("(subject_id = ? OR actor_id = ?) AND (ip IS NOT NULL OR user_agent IS NOT NULL OR pii_salt IS NOT NULL)", userID, userID).
+		Updates(map[string]interface{}{"ip": nil, "user_agent": nil, "pii_salt": nil}).Error
+}
+
+// parseListAuditEventsRequest reads the query string of GET /audit-events.
+func parseListAuditEventsRequest(params map[string]string) (ListAuditEventsRequest, error) {
+	req := ListAuditEventsRequest{
+		ActorID:   params["actorId"],
+		SubjectID: params["subjectId"],
+		Action:    params["action"],
+		Cursor:    params["cursor"],
+		Limit:     defaultListAuditEventsLimit,
+	}
+
+	if value := params["limit"]; value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit <= 0 || limit > maxListAuditEventsLimit {
+			return req, utils.InvalidQueryParameterError("limit", value)
+		}
+		req.Limit = limit
+	}
+
+	if value := params["cursor"]; value != "" {
+		if _, err := strconv.ParseInt(value, 10, 64); err != nil {
+			return req, utils.InvalidQueryParameterError("cursor", value)
+		}
+	}
+
+	for key, dest := range map[string]*time.Time{
+		"after":  &req.After,
+		"before": &req.Before,
+	} {
+		if value := params[key]; value != "" {
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return req, utils.InvalidQueryParameterError(key, value)
+			}
+			*dest = t
+		}
+	}
+
+	return req, nil
+}
+
+// ListAuditEvents returns matching events newest first. The cursor is the ID
+// of the last event on the previous page.
+func ListAuditEvents(req ListAuditEventsRequest) (ListAuditEventsResponse, error) {
+	db := Init()
+
+	limit := req.Limit
+	if limit <= 0 || limit > maxListAuditEventsLimit {
+		limit = defaultListAuditEventsLimit
+	}
+
+	query := db.Model(&AuditEvent{})
+
+	if req.ActorID != "" {
+		query = query.Where("actor_id = ?", req.ActorID)
+	}
+
+	if req.SubjectID != "" {
+		query = query.Where("subject_id = ?", req.SubjectID)
+	}
+
+	if req.Action != "" {
+		query = query.Where("action = ?", req.Action)
+	}
+
+	if !req.After.IsZero() {
+		query = query.Where("created_at >= ?", req.After)
+	}
+
+	if !req.Before.IsZero() {
+		query = query.Where("created_at < ?", req.Before)
+	}
+
+	if req.Cursor != "" {
+		cursor, err := strconv.ParseInt(req.Cursor, 10, 64)
+		if err != nil {
+			return ListAuditEventsResponse{}, utils.InvalidQueryParameterError("cursor", req.Cursor)
+		}
+		query = query.Where("id < ?", cursor)
+	}
+
+	var events []AuditEvent
+	if err := query.Order("id DESC").Limit(limit + 1).Find(&events).Error; err != nil {
+		return ListAuditEventsResponse{}, utils.ListAuditEventsError()
+	}
+
+	response := ListAuditEventsResponse{Events: []ListedAuditEvent{}}
+	if len(events) > limit {
+		events = events[:limit]
+		response.NextCursor = strconv.FormatInt(events[len(events)-1].ID, 10)
+	}
+
+	for _, event := range events {
+		response.Events = append(response.Events, listedAuditEvent(event))
+	}
+
+	return response, nil
+}
+
+func listedAuditEvent(event AuditEvent) ListedAuditEvent {
+	listed := ListedAuditEvent{AuditEvent: event}
+	if event.ChangedFields != "" {
+		listed.ChangedFields = strings.Split(event.ChangedFields, ",")
+	}
+	if event.Details != "" {
+		listed.Details = json.RawMessage(event.Details)
+	}
+	return listed
+}
+
+// VerifyAuditChain walks the whole log in order and reports the first event
+// whose hash does not match its contents or does not follow from the one
+// before it.
+func VerifyAuditChain() (VerifyAuditChainResponse, error) {
+	db := Init()
+	response := VerifyAuditChainResponse{Verified: true}
+
+	var lastID int64
+	var prevHash string
+	for {
+		var events []AuditEvent
+		if err := db.Where("id > ?", lastID).Order("id").Limit(auditChainVerifyBatch).Find(&events).Error; err != nil {
+			return VerifyAuditChainResponse{}, utils.ListAuditEventsError()
+		}
+
+		checked, brokenAt := verifyAuditEvents(events, prevHash)
+		response.Checked += int64(checked)
+		if brokenAt != 0 {
+			response.Verified = false
+			response.BrokenAt = brokenAt
+			return response, nil
+		}
+
+		if len(events) < auditChainVerifyBatch {
+			return response, nil
+		}
+
+		lastID = events[len(events)-1].ID
+		prevHash = events[len(events)-1].Hash
+	}
+}
+
+func verifyAuditEvents(events []AuditEvent, prevHash string) (int, int64) {
+	for i, event := range events {
+		if event.PrevHash != prevHash || event.Hash != auditEventHash(event) {
+			return i, event.ID
+		}
+		prevHash = event.Hash
+	}
+	return len(events), 0
+}
@@ -0,0 +1,212 @@
+package platform_exercise
+
+import (
+	"testing"
+	"time"
+
+	"github.com/campallison/platform-exercise/utils"
+	"github.com/google/go-cmp/cmp"
+	"gorm.io/gorm"
+)
+
+func Test_verifyAuditEvents(t *testing.T) {
+	chain := func() []AuditEvent {
+		var events []AuditEvent
+		var prevHash string
+		for i, action := range []string{auditUserCreated, auditLogin, auditUserUpdated} {
+			event := AuditEvent{
+				ID:        int64(i + 1),
+				CreatedAt: time.Date(2021, 1, 1, i, 0, 0, 0, time.UTC),
+				ActorID:   "0d1c2b3a-4e5f-4a6b-8c7d-9e0f1a2b3c4d",
+				SubjectID: "0d1c2b3a-4e5f-4a6b-8c7d-9e0f1a2b3c4d",
+				Action:    action,
+				IP:        "203.0.113.7",
+				PIISalt:   "5a17",
+				PIIDigest: piiDigest("5a17", "203.0.113.7", ""),
+				PrevHash:  prevHash,
+			}
+			if action == auditLogin {
+				event.Details = `{"score":0,"decision":"allow"}`
+			}
+			event.Hash = auditEventHash(event)
+			prevHash = event.Hash
+			events = append(events, event)
+		}
+		return events
+	}
+
+	cases := []struct {
+		name     string
+		tamper   func([]AuditEvent) []AuditEvent
+		checked  int
+		brokenAt int64
+	}{
+		{
+			name:    "untouched chain",
+			tamper:  func(events []AuditEvent) []AuditEvent { return events },
+			checked: 3,
+		},
+		{
+			name: "erased PII",
+			tamper: func(events []AuditEvent) []AuditEvent {
+				events[1].IP, events[1].PIISalt = "", ""
+				return events
+			},
+			checked: 3,
+		},
+		{
+			name: "edited action",
+			tamper: func(events []AuditEvent) []AuditEvent {
+				events[1].Action = auditLoginFailed
+				return events
+			},
+			checked:  1,
+			brokenAt: 2,
+		},
+		{
+			name: "edited details",
+			tamper: func(events []AuditEvent) []AuditEvent {
+				events[1].Details = `{"score":0,"decision":"deny"}`
+				return events
+			},
+			checked:  1,
+			brokenAt: 2,
+		},
+		{
+			name: "removed event",
+			tamper: func(events []AuditEvent) []AuditEvent {
+				return append(events[:1], events[2:]...)
+			},
+			checked:  1,
+			brokenAt: 3,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			checked, brokenAt := verifyAuditEvents(c.tamper(chain()), "")
+
+			if diff := cmp.Diff([]int64{int64(c.checked), c.brokenAt}, []int64{int64(checked), brokenAt}); diff != "" {
+				t.Errorf("\nUnexpected verification (-want, +got)\n%s", diff)
+			}
+		})
+	}
+}
+
+func Test_parseListAuditEventsRequest(t *testing.T) {
+	cases := []struct {
+		name     string
+		params   map[string]string
+		expected ListAuditEventsRequest
+		err      error
+	}{
+		{
+			name:     "defaults",
+			params:   map[string]string{},
+			expected: ListAuditEventsRequest{Limit: defaultListAuditEventsLimit},
+		},
+		{
+			name: "every filter",
+			params: map[string]string{
+				"actorId":   "a",
+				"subjectId": "s",
+				"action":    auditLogin,
+				"after":     "2021-01-01T00:00:00Z",
+				"before":    "2021-02-01T00:00:00Z",
+				"limit":     "10",
+				"cursor":    "42",
+			},
+			expected: ListAuditEventsRequest{
+				ActorID:   "a",
+				SubjectID: "s",
+				Action:    auditLogin,
+				After:     time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC),
+				Before:    time.Date(2021, 2, 1, 0, 0, 0, 0, time.UTC),
+				Limit:     10,
+				Cursor:    "42",
+			},
+		},
+		{
+			name:   "malformed cursor",
+			params: map[string]string{"cursor": "abc"},
+			err:    utils.InvalidQueryParameterError("cursor", "abc"),
+		},
+		{
+			name:   "limit over the maximum",
+			params: map[string]string{"limit": "1000"},
+			err:    utils.InvalidQueryParameterError("limit", "1000"),
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			res, err := parseListAuditEventsRequest(c.params)
+			utils.AssertErrorsEqual(t, c.err, err)
+
+			if c.err == nil {
+				if diff := cmp.Diff(c.expected, res); diff != "" {
+					t.Errorf("\nUnexpected request (-want, +got)\n%s", diff)
+				}
+			}
+		})
+	}
+}
+
+func Test_recordAudit(t *testing.T) {
+	databaseTest(t, func(database *gorm.DB) {
+		clearDatabase(database)
+		id := "0d1c2b3a-4e5f-4a6b-8c7d-9e0f1a2b3c4d"
+		password := "SkunkStripeMapleNeckRosewoodFingerboard"
+		hash, _ := HashPassword(password)
+		database.Save(&User{ID: id, Name: "Leo Fender", Email: "leo@fender.com", Password: hash})
+
+		// The log is append-only, so only look at what this test adds.
+		var start AuditEvent
+		database.Order("id DESC").Limit(1).Find(&start)
+
+		meta := RequestMeta{IP: "203.0.113.7", UserAgent: "curl/7.64.1"}
+		Login(LoginRequest{Credential: Credential{Email: "leo@fender.com", Password: "wrong"}, Meta: meta})
+		Login(LoginRequest{Credential: Credential{Email: "leo@fender.com", Password: password}, Meta: meta})
+		meta.ActorID = id
+		UpdateUser(UpdateUserRequest{ID: id, Name: "Clarence Fender", Meta: meta})
+
+		res, err := ListAuditEvents(ListAuditEventsRequest{SubjectID: id})
+		utils.AssertErrorsEqual(t, nil, err)
+
+		var actions []string
+		for _, event := range res.Events {
+			if event.ID > start.ID {
+				actions = append(actions, event.Action)
+			}
+		}
+
+		if diff := cmp.Diff([]string{auditUserUpdated, auditLogin, auditLoginFailed}, actions); diff != "" {
+			t.Errorf("\nUnexpected events (-want, +got)\n%s", diff)
+		}
+
+		if diff := cmp.Diff([]string{"name"}, res.Events[0].ChangedFields); diff != "" {
+			t.Errorf("\nUnexpected changed fields (-want, +got)\n%s", diff)
+		}
+
+		if err := database.Delete(&AuditEvent{}, "id = ?", res.Events[0].ID).Error; err == nil {
+			t.Error("expected deleting an audit event to fail")
+		}
+
+		if _, err := EraseUser(DeleteUserRequest{ID: id, Mode: deleteModeErase}); err != nil {
+			t.Fatal(err)
+		}
+
+		var withPII int64
+		database.Model(&AuditEvent{}).Where("subject_id = ? AND (ip IS NOT NULL OR pii_salt IS NOT NULL)", id).Count(&withPII)
+		if withPII != 0 {
+			t.Errorf("expected erasure to clear IPs, %d events still have one", withPII)
+		}
+
+		verified, err := VerifyAuditChain()
+		utils.AssertErrorsEqual(t, nil, err)
+
+		if !verified.Verified {
+			t.Errorf("expected the chain to verify after erasure, got %+v", verified)
+		}
+	})
+}
@@ -1,6 +1,8 @@
 package platform_exercise
 
 import (
+	"errors"
+	"net"
 	"os"
 	"strings"
 	"time"
@@ -8,11 +10,22 @@ import (
 	"github.com/campallison/platform-exercise/utils"
 	jwt "github.com/dgrijalva/jwt-go"
 	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
 )
 
+const defaultStepUpMaxAge = 5 * time.Minute
+
+// stepUpMethods are the ways a client can answer a step-up challenge.
+var stepUpMethods = []string{"password"}
+
+// dummyPasswordHash is compared against when a login's email is unknown, so
+// that failing costs the same bcrypt work whether or not the account exists.
+// It must stay at bcryptGenerationCost.
+const dummyPasswordHash = "$2a$14$n6vtfKz.sLW9QgoO2HMRteEzlUCwvpNo5uj.JWQ51ubiQwH.Ulf22"
+
 type Credential struct {
 	Email    string `json:"email" validate:"required,email"`
-	Password string `json:"password", validate:"required"`
+	Password string `json:"password" validate:"required"`
 }
 
 func (c Credential) CheckPassword(hash string) bool {
@@ -20,40 +33,149 @@ func (c Credential) CheckPassword(hash string) bool {
 	return err == nil
 }
 
-func Login(creds Credential) (LoginResponse, error) {
+func Login(req LoginRequest) (LoginResponse, error) {
 	db := Init()
 	var user User
 	var response LoginResponse
 
-	if err := db.Table("users").Where("email = ?", creds.Email).First(&user).Error; err != nil {
+	if err := checkGlobalIPDenyRules(db, req.Meta.IP); err != nil {
+		recordAuditDetails(auditLoginBlocked, "", req.Meta, ipRuleDetails{Reason: "source IP denied by a global IP rule"})
+		return response, err
+	}
+
+	source := newLoginSource(req.Email, req.Password, req.Meta)
+	if err := checkLoginBlock(db, source, "", req.Meta); err != nil {
+		return response, err
+	}
+
+	if err := db.Table("users").Where("canonical_email = ?", canonicalEmail(req.Email)).First(&user).Error; err != nil {
+		req.CheckPassword(dummyPasswordHash)
+		recordAudit(auditLoginFailed, "", req.Meta)
+		recordFailedLogin(db, source, req.Meta)
 		return response, utils.LoginFailedError()
 	}
 
-	if creds.CheckPassword(user.Password) {
-		expiry := time.Now().In(time.UTC).Add(time.Hour * 12)
-		unsignedToken := jwt.NewWithClaims(jwt.GetSigningMethod("HS512"), jwt.MapClaims{
-			"Id":        user.ID,
-			"ExpiresAt": expiry,
-			"Subject":   user.Email,
-		})
+	if !req.CheckPassword(user.Password) {
+		recordAudit(auditLoginFailed, user.ID, req.Meta)
+		recordFailedLogin(db, source, req.Meta)
+		recordLogin(user, newLoginAttempt(user.ID, false, req.Meta), false)
+		return LoginResponse{}, utils.LoginFailedError()
+	}
 
-		signedToken, err := unsignedToken.SignedString([]byte(os.Getenv("SigningSecret")))
+	if err := checkUserIPRules(db, user, req.Meta); err != nil {
+		recordFailedLogin(db, source, req.Meta)
+		recordLogin(user, newLoginAttempt(user.ID, false, req.Meta), false)
+		return LoginResponse{}, utils.LoginFailedError()
+	}
+
+	attempt := newLoginAttempt(user.ID, true, req.Meta)
+	assessment := assessLogin(loginRiskContext{db: db, user: user, attempt: attempt}, riskChecks, loadRiskConfig())
+	attempt.RiskScore = assessment.Score
+	attempt.RiskSignals = assessment.signalNames()
+	req.Meta.ActorID = user.ID
+
+	if assessment.Decision == riskDecisionChallenge && loginCodesExhausted(db, user.ID, attempt.CreatedAt) {
+		assessment.Decision = riskDecisionDeny
+	}
+
+	switch assessment.Decision {
+	case riskDecisionDeny:
+		attempt.Succeeded = false
+		recordAuditDetails(auditLoginBlocked, user.ID, req.Meta, assessment)
+		recordLogin(user, attempt, true)
+		sendLoginRiskMail(user, attempt, assessment)
+		return LoginResponse{}, utils.LoginFailedError()
+	case riskDecisionChallenge:
+		recordAuditDetails(auditLoginChallenged, user.ID, req.Meta, assessment)
+		return LoginResponse{}, startLoginChallenge(db, user, attempt)
+	default:
+		response, err := issueAccessToken(db, user)
 		if err != nil {
-			return response, utils.LoginFailedError()
+			return response, err
 		}
 
-		response.AccessToken = signedToken
-		response.Expiry = expiry
-
+		recordAuditDetails(auditLogin, user.ID, req.Meta, assessment)
+		recordLogin(user, attempt, assessment.Notify)
+		if assessment.Notify {
+			sendLoginRiskMail(user, attempt, assessment)
+		}
 		return response, nil
 	}
+}
+
+// issueAccessToken signs a token for a user who has just proven who they are,
+// recording that moment in AuthTime for step-up checks.
+func issueAccessToken(db *gorm.DB, user User) (LoginResponse, error) {
+	roles, permissions, err := userRolesAndPermissions(db, user.ID)
+	if err != nil {
+		return LoginResponse{}, utils.LoginFailedError()
+	}
 
-	return LoginResponse{}, utils.LoginFailedError()
+	now := time.Now().In(time.UTC)
+	expiry := now.Add(time.Hour * 12)
+	unsignedToken := jwt.NewWithClaims(jwt.GetSigningMethod("HS512"), jwt.MapClaims{
+		"Id":          user.ID,
+		"ExpiresAt":   expiry,
+		"exp":         expiry.Unix(),
+		"AuthTime":    now,
+		"Subject":     user.Email,
+		"Roles":       roles,
+		"Permissions": permissions,
+	})
+
+	signedToken, err := unsignedToken.SignedString([]byte(os.Getenv("SigningSecret")))
+	if err != nil {
+		return LoginResponse{}, utils.LoginFailedError()
+	}
+
+	return LoginResponse{AccessToken: signedToken, Expiry: expiry}, nil
+}
+
+// Reauthenticate trades a valid token and the user's password for a new token
+// with a fresh AuthTime, satisfying a step-up challenge. The old token is
+// logged out.
+func Reauthenticate(req ReauthenticateRequest) (LoginResponse, error) {
+	claims, err := authenticate(req.AuthHeader, req.Meta.IP)
+	if err != nil {
+		return LoginResponse{}, err
+	}
+
+	db := Init()
+	var user User
+	if err := db.Where("id = ?", claims["Id"]).First(&user).Error; err != nil {
+		return LoginResponse{}, utils.UnauthorizedError()
+	}
+	req.Meta.ActorID = user.ID
+
+	source := newLoginSource(user.Email, req.Password, req.Meta)
+	if err := checkLoginBlock(db, source, user.ID, req.Meta); err != nil {
+		return LoginResponse{}, err
+	}
+
+	if !(Credential{Password: req.Password}).CheckPassword(user.Password) {
+		recordAudit(auditLoginFailed, user.ID, req.Meta)
+		recordFailedLogin(db, source, req.Meta)
+		return LoginResponse{}, utils.UnauthorizedError()
+	}
+
+	response, err := issueAccessToken(db, user)
+	if err != nil {
+		return LoginResponse{}, err
+	}
+
+	tokenString, _ := getTokenFromAuthHeader(req.AuthHeader)
+	if _, err := Logout(LogoutRequest{ID: user.ID, AccessToken: tokenString, Meta: req.Meta}); err != nil {
+		return LoginResponse{}, err
+	}
+
+	recordAudit(auditReauthenticated, user.ID, req.Meta)
+	return response, nil
 }
 
 func Logout(req LogoutRequest) (LogoutResponse, error) {
 	token := InvalidToken{
-		Token: req.AccessToken,
+		Token:  req.AccessToken,
+		UserID: req.ID,
 	}
 
 	db := Init()
@@ -63,6 +185,8 @@ func Logout(req LogoutRequest) (LogoutResponse, error) {
 		return LogoutResponse{}, utils.LogoutFailedError(err)
 	}
 
+	recordAudit(auditLogout, req.ID, req.Meta)
+
 	return LogoutResponse{Success: true}, nil
 }
 
@@ -79,40 +203,282 @@ func deleteStaleInvalidTokens() {
 	}
 }
 
-func CheckToken(authHeader string, userID string) error {
+// CheckToken allows a request only when the token belongs to userID. Like
+// RequirePermission it returns the verified claims, the only source of the
+// caller's identity for the audit log.
+func CheckToken(authHeader string, sourceIP string, userID string) (jwt.MapClaims, error) {
+	claims, err := authenticate(authHeader, sourceIP)
+	if err != nil {
+		return nil, err
+	}
+
+	if claims["Id"] == userID {
+		return claims, nil
+	}
+
+	return nil, utils.InvalidTokenError()
+}
+
+// RequirePermission allows a request when the token belongs to userID, or when
+// it carries the permission to act on any user. An empty userID is for
+// requests not about a particular user, where only the permission counts.
+func RequirePermission(authHeader string, sourceIP string, userID string, permission string) (jwt.MapClaims, error) {
+	claims, err := authenticate(authHeader, sourceIP)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := authorize(claims, userID, permission); err != nil {
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+// RequireRecentAuth is RequirePermission for sensitive operations, which
+// also need the caller to have authenticated within stepUpMaxAge.
+func RequireRecentAuth(authHeader string, sourceIP string, userID string, permission string) (jwt.MapClaims, error) {
+	claims, err := RequirePermission(authHeader, sourceIP, userID, permission)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkAuthTime(claims, stepUpMaxAge(), time.Now()); err != nil {
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+func stepUpMaxAge() time.Duration {
+	maxAge, err := time.ParseDuration(os.Getenv("stepUpMaxAge"))
+	if err != nil || maxAge <= 0 {
+		return defaultStepUpMaxAge
+	}
+	return maxAge
+}
+
+// checkAuthTime challenges tokens whose user last authenticated more than
+// maxAge ago, including tokens issued before AuthTime existed.
+func checkAuthTime(claims jwt.MapClaims, maxAge time.Duration, now time.Time) error {
+	authTimeClaim, _ := claims["AuthTime"].(string)
+	authTime, err := time.Parse(time.RFC3339Nano, authTimeClaim)
+	if err != nil || now.Sub(authTime) > maxAge {
+		return utils.StepUpRequiredError(maxAge, stepUpMethods)
+	}
+
+	return nil
+}
+
+func authorize(claims jwt.MapClaims, userID string, permission string) error {
+	if userID != "" && claims["Id"] == userID {
+		return nil
+	}
+
+	for _, granted := range claimStrings(claims, "Permissions") {
+		if granted == permission {
+			return nil
+		}
+	}
+
+	return utils.ForbiddenError(permission)
+}
+
+// authenticate parses the bearer token and checks that its holder may use it
+// from sourceIP. The roles and permissions in the token are replaced with the
+// user's current ones, so a revoked role stops working at once.
+func authenticate(authHeader string, sourceIP string) (jwt.MapClaims, error) {
+	claims, err := parseToken(authHeader)
+	if err != nil {
+		return nil, err
+	}
+
+	userID, _ := claims["Id"].(string)
+	holder, err := loadTokenHolder(Init(), userID)
+	if err != nil {
+		return nil, utils.TokenCheckFailedError()
+	}
+
+	if !holder.Live {
+		return nil, utils.InvalidTokenError()
+	}
+	claims["Roles"], claims["Permissions"] = stringsClaim(holder.Roles), stringsClaim(holder.Permissions)
+
+	if !evaluateIPRules(holder.IPRules, net.ParseIP(sourceIP), userID, holder.Roles) {
+		return nil, utils.IPNotAllowedError(sourceIP)
+	}
+
+	return claims, nil
+}
+
+// tokenHolder is what authenticate needs to know about the user a token was
+// issued to. Live is false once the user is deleted or erased.
+type tokenHolder struct {
+	Live        bool
+	Roles       []string
+	Permissions []string
+	IPRules     []IPRule
+}
+
+// tokenHolderQuery reads a tokenHolder in one round trip: one row for each IP
+// rule that applies to the user, or a single row with no rule, each carrying
+// whether the user is live and their roles and permissions. A deleted user's
+// roles outlive them until they are purged, so they must not be trusted alone.
+const tokenHolderQuery = `
+SELECT holder.live, holder.roles, holder.permissions,
+    COALESCE(ip_rules.id, 0) AS rule_id,
+    COALESCE(ip_rules.action, '') AS rule_action,
+    COALESCE(ip_rules.cidr, '') AS rule_cidr,
+    COALESCE(ip_rules.scope, '') AS rule_scope,
+    COALESCE(ip_rules.subject, '') AS rule_subject
+FROM (
+    SELECT
+        EXISTS (SELECT 1 FROM users WHERE id = @id AND deleted_at IS NULL)
+            AND NOT EXISTS (SELECT 1 FROM user_erasures WHERE user_id = @id) AS live,
+        COALESCE((SELECT string_agg(role, ',' ORDER BY role) FROM user_roles WHERE user_id = @id), '') AS roles,
+        COALESCE((
+            SELECT string_agg(DISTINCT role_permissions.permission, ',' ORDER BY role_permissions.permission)
+            FROM role_permissions JOIN user_roles ON user_roles.role = role_permissions.role
+            WHERE user_roles.user_id = @id
+        ), '') AS permissions
+) holder
+LEFT JOIN ip_rules ON ip_rules.scope = @global
+    OR (ip_rules.scope = @user AND ip_rules.subject = @id)
+    OR (ip_rules.scope = @role AND ip_rules.subject IN (SELECT role FROM user_roles WHERE user_id = @id))
+ORDER BY ip_rules.id`
+
+type tokenHolderRow struct {
+	Live        bool
+	Roles       string
+	Permissions string
+	RuleID      int64
+	RuleAction  string
+	RuleCIDR    string `gorm:"column:rule_cidr"`
+	RuleScope   string
+	RuleSubject string
+}
+
+func loadTokenHolder(db *gorm.DB, userID string) (tokenHolder, error) {
+	var rows []tokenHolderRow
+	if err := db.Raw(tokenHolderQuery, map[string]interface{}{
+		"id":     userID,
+		"global": ipRuleGlobal,
+		"user":   ipRuleUser,
+		"role":   ipRuleRole,
+	}).Scan(&rows).Error; err != nil {
+		return tokenHolder{}, err
+	}
+
+	if len(rows) == 0 {
+		return tokenHolder{}, errors.New("token holder query returned no rows")
+	}
+
+	holder := tokenHolder{
+		Live:        rows[0].Live,
+		Roles:       splitList(rows[0].Roles),
+		Permissions: splitList(rows[0].Permissions),
+	}
+	for _, row := range rows {
+		if row.RuleID != 0 {
+			holder.IPRules = append(holder.IPRules, IPRule{
+				ID:      row.RuleID,
+				Action:  row.RuleAction,
+				CIDR:    row.RuleCIDR,
+				Scope:   row.RuleScope,
+				Subject: row.RuleSubject,
+			})
+		}
+	}
+
+	return holder, nil
+}
+
+func splitList(list string) []string {
+	if list == "" {
+		return []string{}
+	}
+	return strings.Split(list, ",")
+}
+
+// parseToken checks that the bearer token has not been logged out and carries
+// our signature, and returns its claims.
+func parseToken(authHeader string) (jwt.MapClaims, error) {
 	tokenString, err := getTokenFromAuthHeader(authHeader)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	validTokenCheck := isValidToken(tokenString)
 	if validTokenCheck == nil {
-		return utils.TokenCheckFailedError()
+		return nil, utils.TokenCheckFailedError()
 	}
 
 	if *validTokenCheck == false {
-		return utils.InvalidTokenError()
+		return nil, utils.InvalidTokenError()
 	}
 
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, utils.TokenSignatureError()
-		}
+	token, err := jwt.Parse(tokenString, signingKey)
+	if err != nil {
+		return nil, utils.ParseTokenError(err)
+	}
 
-		return []byte(os.Getenv("SigningSecret")), nil
-	})
+	// Action tokens from emailed links are signed with the same key but are
+	// never valid as bearer tokens.
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid || claims["Purpose"] != nil {
+		return nil, utils.InvalidTokenError()
+	}
 
-	if err != nil {
-		return utils.ParseTokenError(err)
+	if err := checkExpiry(claims, time.Now()); err != nil {
+		return nil, err
 	}
 
-	if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-		if claims["Id"] == userID {
-			return nil
+	if id, _ := claims["Id"].(string); id == "" {
+		return nil, utils.InvalidTokenError()
+	}
+
+	return claims, nil
+}
+
+// checkExpiry refuses tokens past their ExpiresAt. jwt.Parse checks exp, but
+// tokens issued before exp was added only carry ExpiresAt.
+func checkExpiry(claims jwt.MapClaims, now time.Time) error {
+	expiresAtClaim, _ := claims["ExpiresAt"].(string)
+	expiresAt, err := time.Parse(time.RFC3339Nano, expiresAtClaim)
+	if err != nil || !now.Before(expiresAt) {
+		return utils.InvalidTokenError()
+	}
+
+	return nil
+}
+
+func signingKey(token *jwt.Token) (interface{}, error) {
+	if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+		return nil, utils.TokenSignatureError()
+	}
+
+	return []byte(os.Getenv("SigningSecret")), nil
+}
+
+func claimStrings(claims jwt.MapClaims, key string) []string {
+	values, _ := claims[key].([]interface{})
+
+	strs := make([]string, 0, len(values))
+	for _, value := range values {
+		if str, ok := value.(string); ok {
+			strs = append(strs, str)
 		}
 	}
+	return strs
+}
 
-	return utils.InvalidTokenError()
+// stringsClaim is the form claimStrings reads, as if decoded from a token.
+func stringsClaim(strs []string) []interface{} {
+	values := make([]interface{}, 0, len(strs))
+	for _, str := range strs {
+		values = append(values, str)
+	}
+	return values
 }
 
 func getTokenFromAuthHeader(authHeader string) (string, error) {
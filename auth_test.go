@@ -1,11 +1,66 @@
 package platform_exercise
 
 import (
+	"sort"
 	"testing"
+	"time"
 
+	"github.com/campallison/platform-exercise/utils"
+	jwt "github.com/dgrijalva/jwt-go"
 	"github.com/google/go-cmp/cmp"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
 )
 
+// timingSamples is how many times assertSimilarTimings runs each path.
+const timingSamples = 7
+
+// assertSimilarTimings runs a and b alternately and fails if their median
+// durations differ by more than a quarter. Both paths compared here are
+// dominated by one bcrypt comparison at cost 14, which takes over a second and
+// stayed within 10% of its median across 30 runs, so skipping it would make a
+// path many times faster rather than a quarter. The median of timingSamples
+// runs ignores up to three slow runs on either side, so a false failure needs
+// most runs of one path, and none of the other's, to be slowed by a quarter.
+// Only sustained contention on the test host does that; if it shows up in CI,
+// raise timingSamples rather than the tolerance. It takes many seconds, so
+// -short skips it.
+func assertSimilarTimings(t *testing.T, samples int, a, b func()) {
+	t.Helper()
+	if testing.Short() {
+		t.Skip("skipping timing comparison in short mode")
+	}
+
+	median := func(durations []time.Duration) time.Duration {
+		sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+		return durations[len(durations)/2]
+	}
+
+	a()
+	b()
+
+	var aDurations, bDurations []time.Duration
+	for i := 0; i < samples; i++ {
+		start := time.Now()
+		a()
+		aDurations = append(aDurations, time.Since(start))
+
+		start = time.Now()
+		b()
+		bDurations = append(bDurations, time.Since(start))
+	}
+
+	aMedian, bMedian := median(aDurations), median(bDurations)
+	slower, difference := aMedian, aMedian-bMedian
+	if difference < 0 {
+		slower, difference = bMedian, -difference
+	}
+
+	if difference > slower/4 {
+		t.Errorf("expected similar timings, got medians of %v and %v", aMedian, bMedian)
+	}
+}
+
 func Test_CheckPassword(t *testing.T) {
 	cases := []struct {
 		name     string
@@ -40,3 +95,157 @@ func Test_CheckPassword(t *testing.T) {
 		})
 	}
 }
+
+func Test_dummyPasswordHash(t *testing.T) {
+	cost, err := bcrypt.Cost([]byte(dummyPasswordHash))
+	utils.AssertErrorsEqual(t, nil, err)
+
+	if cost != bcryptGenerationCost {
+		t.Errorf("expected the dummy hash to cost %d like real ones, got %d", bcryptGenerationCost, cost)
+	}
+}
+
+func Test_Login_unknownEmail(t *testing.T) {
+	databaseTest(t, func(database *gorm.DB) {
+		clearDatabase(database)
+		hash, _ := HashPassword("SkunkStripeMapleNeckRosewoodFingerboard")
+		database.Save(&User{ID: "0d1c2b3a-4e5f-4a6b-8c7d-9e0f1a2b3c4d", Name: "Leo Fender", Email: "leo@fender.com", Password: hash, CreatedAt: time.Now().Add(-48 * time.Hour)})
+
+		login := func(email string) error {
+			_, err := Login(LoginRequest{Credential: Credential{Email: email, Password: "wrong password"}, Meta: RequestMeta{IP: "192.0.2.1"}})
+			return err
+		}
+
+		utils.AssertErrorsEqual(t, utils.LoginFailedError(), login("leo@fender.com"))
+		utils.AssertErrorsEqual(t, utils.LoginFailedError(), login("nobody@fender.com"))
+
+		t.Run("takes as long as a wrong password", func(t *testing.T) {
+			assertSimilarTimings(t, timingSamples,
+				func() { utils.AssertErrorsEqual(t, utils.LoginFailedError(), login("leo@fender.com")) },
+				func() { utils.AssertErrorsEqual(t, utils.LoginFailedError(), login("nobody@fender.com")) },
+			)
+		})
+	})
+}
+
+func Test_authorize(t *testing.T) {
+	userID := "3c6a1c6e-5a0b-4d8e-9c3f-1f2a3b4c5d6e"
+	otherID := "9a8b7c6d-5e4f-4a3b-8c2d-1e0f9a8b7c6d"
+
+	cases := []struct {
+		name       string
+		claims     jwt.MapClaims
+		userID     string
+		permission string
+		err        error
+	}{
+		{
+			name:       "owner needs no permission",
+			claims:     jwt.MapClaims{"Id": userID},
+			userID:     userID,
+			permission: permReadUsers,
+		},
+		{
+			name:       "other users are forbidden without the permission",
+			claims:     jwt.MapClaims{"Id": otherID, "Permissions": []interface{}{permWriteUsers}},
+			userID:     userID,
+			permission: permReadUsers,
+			err:        utils.ForbiddenError(permReadUsers),
+		},
+		{
+			name:       "other users are allowed with the permission",
+			claims:     jwt.MapClaims{"Id": otherID, "Permissions": []interface{}{permReadUsers, permWriteUsers}},
+			userID:     userID,
+			permission: permReadUsers,
+		},
+		{
+			name:       "requests about no user need the permission",
+			claims:     jwt.MapClaims{"Id": userID},
+			userID:     "",
+			permission: permManageRoles,
+			err:        utils.ForbiddenError(permManageRoles),
+		},
+		{
+			name:       "requests about no user are allowed with the permission",
+			claims:     jwt.MapClaims{"Id": userID, "Permissions": []interface{}{permManageRoles}},
+			userID:     "",
+			permission: permManageRoles,
+		},
+		{
+			name:       "malformed permissions claim grants nothing",
+			claims:     jwt.MapClaims{"Id": otherID, "Permissions": permReadUsers},
+			userID:     userID,
+			permission: permReadUsers,
+			err:        utils.ForbiddenError(permReadUsers),
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			utils.AssertErrorsEqual(t, c.err, authorize(c.claims, c.userID, c.permission))
+		})
+	}
+}
+
+func Test_checkAuthTime(t *testing.T) {
+	now := time.Date(2021, 1, 1, 12, 0, 0, 0, time.UTC)
+	maxAge := 5 * time.Minute
+
+	cases := []struct {
+		name   string
+		claims jwt.MapClaims
+		err    error
+	}{
+		{
+			name:   "recent authentication",
+			claims: jwt.MapClaims{"AuthTime": now.Add(-time.Minute).Format(time.RFC3339Nano)},
+		},
+		{
+			name:   "stale authentication",
+			claims: jwt.MapClaims{"AuthTime": now.Add(-time.Hour).Format(time.RFC3339Nano)},
+			err:    utils.StepUpRequiredError(maxAge, stepUpMethods),
+		},
+		{
+			name:   "token from before AuthTime existed",
+			claims: jwt.MapClaims{},
+			err:    utils.StepUpRequiredError(maxAge, stepUpMethods),
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			utils.AssertErrorsEqual(t, c.err, checkAuthTime(c.claims, maxAge, now))
+		})
+	}
+}
+
+func Test_checkExpiry(t *testing.T) {
+	now := time.Date(2021, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	cases := []struct {
+		name   string
+		claims jwt.MapClaims
+		err    error
+	}{
+		{
+			name:   "unexpired token",
+			claims: jwt.MapClaims{"ExpiresAt": now.Add(time.Hour).Format(time.RFC3339Nano)},
+		},
+		{
+			name:   "expired token",
+			claims: jwt.MapClaims{"ExpiresAt": now.Add(-time.Second).Format(time.RFC3339Nano)},
+			err:    utils.InvalidTokenError(),
+		},
+		{
+			name:   "token without an expiry",
+			claims: jwt.MapClaims{},
+			err:    utils.InvalidTokenError(),
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			utils.AssertErrorsEqual(t, c.err, checkExpiry(c.claims, now))
+		})
+	}
+}
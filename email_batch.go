@@ -0,0 +1,111 @@
+package platform_exercise
+
+import (
+	"encoding/json"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/campallison/platform-exercise/utils"
+)
+
+const (
+	defaultEmailBatchLimit   = 1000
+	defaultEmailBatchWorkers = 16
+)
+
+func emailBatchLimit() int {
+	return positiveIntFromEnv("emailBatchLimit", defaultEmailBatchLimit)
+}
+
+func emailBatchWorkers() int {
+	return positiveIntFromEnv("emailBatchWorkers", defaultEmailBatchWorkers)
+}
+
+func positiveIntFromEnv(key string, fallback int) int {
+	value, err := strconv.Atoi(os.Getenv(key))
+	if err != nil || value <= 0 {
+		return fallback
+	}
+	return value
+}
+
+// parseEmailBatch accepts either a JSON array of addresses, a JSON object with
+// an "emails" array, or plain text with one address per line.
+func parseEmailBatch(body string) (ValidateEmailBatchRequest, error) {
+	var req ValidateEmailBatchRequest
+
+	trimmed := strings.TrimSpace(body)
+	switch {
+	case strings.HasPrefix(trimmed, "["):
+		if err := json.Unmarshal([]byte(trimmed), &req.Emails); err != nil {
+			return req, utils.MalformedEmailBatchError(err)
+		}
+	case strings.HasPrefix(trimmed, "{"):
+		if err := json.Unmarshal([]byte(trimmed), &req); err != nil {
+			return req, utils.MalformedEmailBatchError(err)
+		}
+	default:
+		for _, line := range strings.Split(trimmed, "\n") {
+			if line = strings.TrimSpace(line); line != "" {
+				req.Emails = append(req.Emails, line)
+			}
+		}
+	}
+
+	return req, nil
+}
+
+// ValidateEmailBatch runs ValidateEmail over every address with a bounded pool
+// of workers. Results keep the order of the request, and an invalid address
+// never fails the batch as a whole.
+func ValidateEmailBatch(req ValidateEmailBatchRequest) (ValidateEmailBatchResponse, error) {
+	if len(req.Emails) == 0 {
+		return ValidateEmailBatchResponse{}, utils.EmptyEmailBatchError()
+	}
+
+	if limit := emailBatchLimit(); len(req.Emails) > limit {
+		return ValidateEmailBatchResponse{}, utils.EmailBatchTooLargeError(len(req.Emails), limit)
+	}
+
+	results := make([]ValidateEmailResponse, len(req.Emails))
+	indexes := make(chan int)
+
+	workers := emailBatchWorkers()
+	if workers > len(req.Emails) {
+		workers = len(req.Emails)
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				result, err := ValidateEmail(ValidateEmailRequest{Email: req.Emails[i]})
+				if err != nil {
+					result.Error = err.Error()
+				}
+				results[i] = result
+			}
+		}()
+	}
+
+	for i := range req.Emails {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	response := ValidateEmailBatchResponse{Results: results}
+	for _, result := range results {
+		if result.IsValid {
+			response.Valid++
+		} else {
+			response.Invalid++
+		}
+	}
+
+	return response, nil
+}
@@ -0,0 +1,118 @@
+package platform_exercise
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/campallison/platform-exercise/utils"
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_parseEmailBatch(t *testing.T) {
+	cases := []struct {
+		name     string
+		body     string
+		expected ValidateEmailBatchRequest
+		err      error
+	}{
+		{
+			name:     "JSON array",
+			body:     `["leo@fender.com", "nuge@trashmail.com"]`,
+			expected: ValidateEmailBatchRequest{Emails: []string{"leo@fender.com", "nuge@trashmail.com"}},
+		},
+		{
+			name:     "JSON object",
+			body:     `{"emails": ["leo@fender.com"]}`,
+			expected: ValidateEmailBatchRequest{Emails: []string{"leo@fender.com"}},
+		},
+		{
+			name:     "newline-delimited with blank lines and CRLF",
+			body:     "leo@fender.com\r\n\r\n  nuge@trashmail.com\n",
+			expected: ValidateEmailBatchRequest{Emails: []string{"leo@fender.com", "nuge@trashmail.com"}},
+		},
+		{
+			name: "malformed JSON array",
+			body: `["leo@fender.com",`,
+			err:  utils.MalformedEmailBatchError(errors.New("unexpected end of JSON input")),
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			res, err := parseEmailBatch(c.body)
+			utils.AssertErrorsEqual(t, c.err, err)
+
+			if c.err == nil {
+				if diff := cmp.Diff(c.expected, res); diff != "" {
+					t.Errorf("\nUnexpected batch (-want, +got)\n%s", diff)
+				}
+			}
+		})
+	}
+}
+
+func Test_ValidateEmailBatch(t *testing.T) {
+	os.Setenv("emailBatchLimit", "3")
+	os.Setenv("emailBatchWorkers", "2")
+	defer os.Unsetenv("emailBatchLimit")
+	defer os.Unsetenv("emailBatchWorkers")
+
+	cases := []struct {
+		name     string
+		req      ValidateEmailBatchRequest
+		expected ValidateEmailBatchResponse
+		err      error
+	}{
+		{
+			name: "results keep request order",
+			req:  ValidateEmailBatchRequest{Emails: []string{"leo@fender.com", "nuge@trashmail.com", "leo@gmial.com"}},
+			expected: ValidateEmailBatchResponse{
+				Results: []ValidateEmailResponse{
+					{Email: "leo@fender.com", IsValid: true},
+					{
+						Email: "nuge@trashmail.com",
+						Error: "prohibited email nuge@trashmail.com, domain is disallowed",
+						Code:  "prohibited_domain",
+					},
+					{Email: "leo@gmial.com", IsValid: true, Suggestion: "leo@gmail.com"},
+				},
+				Valid:   2,
+				Invalid: 1,
+			},
+		},
+		{
+			name: "empty batch",
+			req:  ValidateEmailBatchRequest{},
+			err:  utils.EmptyEmailBatchError(),
+		},
+		{
+			name: "batch over the limit",
+			req:  ValidateEmailBatchRequest{Emails: []string{"a@fender.com", "b@fender.com", "c@fender.com", "d@fender.com"}},
+			err:  utils.EmailBatchTooLargeError(4, 3),
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			res, err := ValidateEmailBatch(c.req)
+			utils.AssertErrorsEqual(t, c.err, err)
+
+			if diff := cmp.Diff(c.expected, res); diff != "" {
+				t.Errorf("\nUnexpected batch validation (-want, +got)\n%s", diff)
+			}
+		})
+	}
+}
+
+func Test_ValidateEmailBatchHandler_requiresToken(t *testing.T) {
+	response, _ := ValidateEmailBatchHandler(events.APIGatewayProxyRequest{
+		HTTPMethod: "POST",
+		Body:       `["leo@fender.com"]`,
+	})
+
+	if diff := cmp.Diff(401, response.StatusCode); diff != "" {
+		t.Errorf("\nUnexpected response (-want, +got)\n%s", diff)
+	}
+}
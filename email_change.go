@@ -0,0 +1,187 @@
+package platform_exercise
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/campallison/platform-exercise/utils"
+	jwt "github.com/dgrijalva/jwt-go"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultEmailChangeExpiry = 24 * time.Hour
+
+	confirmEmailTokenPurpose = "confirm-email"
+	cancelEmailTokenPurpose  = "cancel-email-change"
+)
+
+func emailChangeExpiry() time.Duration {
+	expiry, err := time.ParseDuration(os.Getenv("emailChangeExpiry"))
+	if err != nil || expiry <= 0 {
+		return defaultEmailChangeExpiry
+	}
+	return expiry
+}
+
+// isEmailRegistered reports whether a live user other than userID already
+// has the email, in any of its canonical spellings.
+func isEmailRegistered(db *gorm.DB, email string, userID string) bool {
+	var count int64
+	db.Model(&User{}).Where("canonical_email = ? AND id <> ?", canonicalEmail(email), userID).Count(&count)
+	return count > 0
+}
+
+// requestEmailChange records newEmail as pending within tx and returns the
+// mails to send once tx commits: a confirmation link to the new address and a
+// cancel link to the current one. Any earlier pending change is replaced,
+// which also invalidates its links.
+func requestEmailChange(tx *gorm.DB, user User, newEmail string) ([]utils.Mail, error) {
+	change := EmailChange{
+		UserID:    user.ID,
+		NewEmail:  newEmail,
+		ExpiresAt: time.Now().Add(emailChangeExpiry()),
+	}
+
+	if err := tx.Where("user_id = ?", user.ID).Delete(&EmailChange{}).Error; err != nil {
+		return nil, err
+	}
+
+	if err := tx.Create(&change).Error; err != nil {
+		return nil, err
+	}
+
+	confirmLink, err := emailChangeLink("confirmEmailURL", confirmEmailTokenPurpose, change)
+	if err != nil {
+		return nil, err
+	}
+
+	cancelLink, err := emailChangeLink("cancelEmailChangeURL", cancelEmailTokenPurpose, change)
+	if err != nil {
+		return nil, err
+	}
+
+	expires := change.ExpiresAt.UTC().Format("January 2, 2006 15:04 MST")
+
+	return []utils.Mail{{
+		To:      newEmail,
+		Subject: "Confirm your new email address",
+		Body: fmt.Sprintf(
+			"Confirm that you want to use this address for your account by opening this link before %s:\n\n%s",
+			expires, confirmLink,
+		),
+	}, {
+		To:      user.Email,
+		Subject: "Your email address is being changed",
+		Body: fmt.Sprintf(
+			"Someone asked to change the email address on your account to %s. If this wasn't you, cancel the change and change your password:\n\n%s",
+			newEmail, cancelLink,
+		),
+	}}, nil
+}
+
+func emailChangeLink(baseURLKey string, purpose string, change EmailChange) (string, error) {
+	token, err := signActionToken(purpose, change.UserID, change.ExpiresAt, jwt.MapClaims{"ChangeID": change.ID})
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf(
+		"%s?id=%s&token=%s",
+		os.Getenv(baseURLKey), url.QueryEscape(change.UserID), url.QueryEscape(token),
+	), nil
+}
+
+// pendingEmailChange finds the change an emailed token was issued for. A
+// token for a change that was replaced, cancelled or confirmed finds nothing.
+func pendingEmailChange(db *gorm.DB, req EmailChangeRequest, purpose string) (EmailChange, error) {
+	claims, err := parseActionToken(req.Token, purpose)
+	if err != nil {
+		return EmailChange{}, err
+	}
+
+	if claims["Id"] != req.ID {
+		return EmailChange{}, utils.InvalidTokenError()
+	}
+
+	var change EmailChange
+	if err := db.Where("id = ? AND user_id = ?", claims["ChangeID"], req.ID).First(&change).Error; err != nil {
+		return EmailChange{}, utils.EmailChangeNotFoundError(req.ID)
+	}
+
+	if time.Now().After(change.ExpiresAt) {
+		return EmailChange{}, utils.EmailChangeNotFoundError(req.ID)
+	}
+
+	return change, nil
+}
+
+// PendingEmail returns the address a user is changing to, if any.
+func PendingEmail(userID string) string {
+	db := Init()
+
+	var change EmailChange
+	if err := db.Where("user_id = ? AND expires_at > ?", userID, time.Now()).First(&change).Error; err != nil {
+		return ""
+	}
+	return change.NewEmail
+}
+
+func ConfirmEmailChange(req EmailChangeRequest) (User, error) {
+	db := Init()
+
+	change, err := pendingEmailChange(db, req, confirmEmailTokenPurpose)
+	if err != nil {
+		return User{}, err
+	}
+
+	if isEmailRegistered(db, change.NewEmail, change.UserID) {
+		return User{}, utils.EmailAlreadyRegisteredError(change.NewEmail)
+	}
+
+	if err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&User{}).Where("id = ?", change.UserID).Updates(map[string]interface{}{
+			"email":           change.NewEmail,
+			"canonical_email": canonicalEmail(change.NewEmail),
+		}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&change).Error
+	}); err != nil {
+		if isUniqueViolation(err) {
+			return User{}, utils.EmailAlreadyRegisteredError(change.NewEmail)
+		}
+		return User{}, utils.SaveUserToDBError(change.NewEmail)
+	}
+
+	recordAudit(auditEmailChanged, change.UserID, req.Meta, "email")
+
+	var user User
+	if err := db.Where("id = ?", change.UserID).First(&user).Error; err != nil {
+		return User{}, utils.UserNotFoundError(change.UserID)
+	}
+
+	return user, nil
+}
+
+func CancelEmailChange(req EmailChangeRequest) (User, error) {
+	db := Init()
+
+	change, err := pendingEmailChange(db, req, cancelEmailTokenPurpose)
+	if err != nil {
+		return User{}, err
+	}
+
+	if err := db.Delete(&change).Error; err != nil {
+		return User{}, utils.SaveUserToDBError(change.NewEmail)
+	}
+
+	var user User
+	if err := db.Where("id = ?", change.UserID).First(&user).Error; err != nil {
+		return User{}, utils.UserNotFoundError(change.UserID)
+	}
+
+	return user, nil
+}
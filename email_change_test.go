@@ -0,0 +1,116 @@
+package platform_exercise
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/campallison/platform-exercise/utils"
+	"github.com/google/go-cmp/cmp"
+	"gorm.io/gorm"
+)
+
+// tokenFromMail pulls the action token out of the link in a mail body.
+func tokenFromMail(t *testing.T, mail utils.Mail) string {
+	fields := strings.Fields(mail.Body)
+	link, err := url.Parse(fields[len(fields)-1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return link.Query().Get("token")
+}
+
+func Test_EmailChange(t *testing.T) {
+	databaseTest(t, func(database *gorm.DB) {
+		id := "13a185dd-1c2e-4092-81cc-ec306d18b2bd"
+		password := "WalkinOnSunshine1999!"
+		hash, _ := HashPassword(password)
+
+		mailbox := withTestMailer(t)
+
+		requestChange := func(t *testing.T) (confirmToken string, cancelToken string) {
+			clearDatabase(database)
+			mailbox.Reset()
+			database.Save(&User{ID: id, Name: "Philip Fry", Email: "deliveryboy@panuccis.net", Password: hash})
+
+			if _, err := UpdateUser(UpdateUserRequest{ID: id, Email: "daffodil@shiny.com", OldPassword: password}); err != nil {
+				t.Fatal(err)
+			}
+
+			if len(mailbox.Sent) != 2 {
+				t.Fatalf("expected mail to both addresses, got %+v", mailbox.Sent)
+			}
+
+			if diff := cmp.Diff(
+				[]string{"daffodil@shiny.com", "deliveryboy@panuccis.net"},
+				[]string{mailbox.Sent[0].To, mailbox.Sent[1].To},
+			); diff != "" {
+				t.Fatalf("\nUnexpected recipients (-want, +got)\n%s", diff)
+			}
+
+			return tokenFromMail(t, mailbox.Sent[0]), tokenFromMail(t, mailbox.Sent[1])
+		}
+
+		t.Run("confirming from the new address changes the email", func(t *testing.T) {
+			confirmToken, _ := requestChange(t)
+
+			if diff := cmp.Diff("daffodil@shiny.com", PendingEmail(id)); diff != "" {
+				t.Errorf("\nUnexpected pending email (-want, +got)\n%s", diff)
+			}
+
+			user, err := ConfirmEmailChange(EmailChangeRequest{ID: id, Token: confirmToken})
+			utils.AssertErrorsEqual(t, nil, err)
+
+			if diff := cmp.Diff("daffodil@shiny.com", user.Email); diff != "" {
+				t.Errorf("\nUnexpected email (-want, +got)\n%s", diff)
+			}
+
+			_, err = ConfirmEmailChange(EmailChangeRequest{ID: id, Token: confirmToken})
+			utils.AssertErrorsEqual(t, utils.EmailChangeNotFoundError(id), err)
+		})
+
+		t.Run("cancelling from the old address keeps the email", func(t *testing.T) {
+			confirmToken, cancelToken := requestChange(t)
+
+			user, err := CancelEmailChange(EmailChangeRequest{ID: id, Token: cancelToken})
+			utils.AssertErrorsEqual(t, nil, err)
+
+			if diff := cmp.Diff("deliveryboy@panuccis.net", user.Email); diff != "" {
+				t.Errorf("\nUnexpected email (-want, +got)\n%s", diff)
+			}
+
+			_, err = ConfirmEmailChange(EmailChangeRequest{ID: id, Token: confirmToken})
+			utils.AssertErrorsEqual(t, utils.EmailChangeNotFoundError(id), err)
+		})
+
+		t.Run("a cancel link cannot confirm", func(t *testing.T) {
+			_, cancelToken := requestChange(t)
+
+			_, err := ConfirmEmailChange(EmailChangeRequest{ID: id, Token: cancelToken})
+			utils.AssertErrorsEqual(t, utils.InvalidTokenError(), err)
+		})
+
+		t.Run("the address was taken in the meantime", func(t *testing.T) {
+			confirmToken, _ := requestChange(t)
+			database.Save(&User{Name: "Amy Wong", Email: "daffodil@shiny.com"})
+
+			_, err := ConfirmEmailChange(EmailChangeRequest{ID: id, Token: confirmToken})
+			utils.AssertErrorsEqual(t, utils.EmailAlreadyRegisteredError("daffodil@shiny.com"), err)
+		})
+
+		t.Run("the pending address is exported", func(t *testing.T) {
+			requestChange(t)
+
+			res, err := RequestUserExport(UserExportRequest{ID: id})
+			utils.AssertErrorsEqual(t, nil, err)
+
+			if res.Export == nil || res.Export.PendingEmailChange == nil {
+				t.Fatalf("expected the pending change in the export, got %+v", res)
+			}
+
+			if diff := cmp.Diff("daffodil@shiny.com", res.Export.PendingEmailChange.NewEmail); diff != "" {
+				t.Errorf("\nUnexpected pending email (-want, +got)\n%s", diff)
+			}
+		})
+	})
+}
@@ -0,0 +1,165 @@
+package platform_exercise
+
+import (
+	"crypto/subtle"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/campallison/platform-exercise/utils"
+)
+
+const (
+	blockedDomainList                 = "blocked"
+	allowedDomainList                 = "allowed"
+	defaultEmailDomainsReloadInterval = 5 * time.Minute
+)
+
+var emailDomains struct {
+	sync.Mutex
+	loadedAt time.Time
+}
+
+func emailDomainsReloadInterval() time.Duration {
+	interval, err := time.ParseDuration(os.Getenv("emailDomainsReloadInterval"))
+	if err != nil || interval <= 0 {
+		return defaultEmailDomainsReloadInterval
+	}
+	return interval
+}
+
+// refreshEmailDomains rebuilds utils.DisposableDomains at most once per reload
+// interval, so edits to the list files or the email_domain_rules table reach
+// warm Lambdas without a redeploy. A failed reload keeps the previous lists.
+func refreshEmailDomains() {
+	emailDomains.Lock()
+	defer emailDomains.Unlock()
+
+	if !emailDomains.loadedAt.IsZero() && time.Since(emailDomains.loadedAt) < emailDomainsReloadInterval() {
+		return
+	}
+	emailDomains.loadedAt = time.Now()
+
+	blocked, allowed, err := loadEmailDomains()
+	if err != nil {
+		log.Printf("\nCould not reload email domain lists, keeping previous lists\n%v\n", err)
+		return
+	}
+
+	utils.DisposableDomains.Replace(blocked, allowed)
+}
+
+func expireEmailDomains() {
+	emailDomains.Lock()
+	emailDomains.loadedAt = time.Time{}
+	emailDomains.Unlock()
+}
+
+func loadEmailDomains() ([]string, []string, error) {
+	blocked := utils.DefaultProhibitedEmailDomains()
+	var allowed []string
+
+	if path := os.Getenv("blockedEmailDomainsFile"); path != "" {
+		domains, err := utils.LoadDomainListFile(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		blocked = append(blocked, domains...)
+	}
+
+	if path := os.Getenv("allowedEmailDomainsFile"); path != "" {
+		domains, err := utils.LoadDomainListFile(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		allowed = append(allowed, domains...)
+	}
+
+	if os.Getenv("emailDomainRulesFromDB") == "true" {
+		var rules []EmailDomainRule
+		if err := Init().Find(&rules).Error; err != nil {
+			return nil, nil, err
+		}
+
+		for _, rule := range rules {
+			switch rule.List {
+			case blockedDomainList:
+				blocked = append(blocked, rule.Domain)
+			case allowedDomainList:
+				allowed = append(allowed, rule.Domain)
+			}
+		}
+	}
+
+	return blocked, allowed, nil
+}
+
+func isValidDomainRule(rule EmailDomainRule) bool {
+	if rule.List != blockedDomainList && rule.List != allowedDomainList {
+		return false
+	}
+
+	domain := strings.TrimPrefix(rule.Domain, "*.")
+	return domain != "" &&
+		strings.Contains(domain, ".") &&
+		!strings.ContainsAny(domain, " \t@*/") &&
+		!strings.HasPrefix(domain, ".")
+}
+
+func AddEmailDomainRule(req EmailDomainRuleRequest) (EmailDomainRuleResponse, error) {
+	rule := EmailDomainRule{
+		Domain: utils.NormalizeDomainEntry(req.Domain),
+		List:   req.List,
+	}
+
+	if !isValidDomainRule(rule) {
+		return EmailDomainRuleResponse{}, utils.InvalidDomainRuleError(req.Domain, req.List)
+	}
+
+	db := Init()
+	if err := db.Save(&rule).Error; err != nil {
+		return EmailDomainRuleResponse{}, utils.SaveDomainRuleError(rule.Domain)
+	}
+
+	expireEmailDomains()
+
+	return EmailDomainRuleResponse{Domain: rule.Domain, List: rule.List}, nil
+}
+
+func RemoveEmailDomainRule(req EmailDomainRuleRequest) (EmailDomainRuleResponse, error) {
+	rule := EmailDomainRule{
+		Domain: utils.NormalizeDomainEntry(req.Domain),
+		List:   req.List,
+	}
+
+	if !isValidDomainRule(rule) {
+		return EmailDomainRuleResponse{}, utils.InvalidDomainRuleError(req.Domain, req.List)
+	}
+
+	db := Init()
+	result := db.Where("domain = ? AND list = ?", rule.Domain, rule.List).Delete(&EmailDomainRule{})
+	if result.Error != nil {
+		return EmailDomainRuleResponse{}, utils.SaveDomainRuleError(rule.Domain)
+	}
+
+	if result.RowsAffected == 0 {
+		return EmailDomainRuleResponse{}, utils.DomainRuleNotFoundError(rule.Domain, rule.List)
+	}
+
+	expireEmailDomains()
+
+	return EmailDomainRuleResponse{Domain: rule.Domain, List: rule.List}, nil
+}
+
+// checkAdminKey accepts the X-Admin-Key header the email-domain endpoints used
+// before roles existed, so scripts relying on it keep working.
+func checkAdminKey(headers map[string]string) error {
+	secret := os.Getenv("AdminSecret")
+	key := headers["X-Admin-Key"]
+	if secret == "" || subtle.ConstantTimeCompare([]byte(secret), []byte(key)) != 1 {
+		return utils.UnauthorizedError()
+	}
+	return nil
+}
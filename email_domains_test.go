@@ -0,0 +1,159 @@
+package platform_exercise
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/campallison/platform-exercise/utils"
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_refreshEmailDomains(t *testing.T) {
+	dir := t.TempDir()
+	blockedPath := filepath.Join(dir, "blocked.txt")
+	allowedPath := filepath.Join(dir, "allowed.txt")
+	ioutil.WriteFile(blockedPath, []byte("# extra disposable domains\nburner.example\n*.throwaway.example\n"), 0644)
+	ioutil.WriteFile(allowedPath, []byte("partners.mailinator.com\n"), 0644)
+
+	os.Setenv("blockedEmailDomainsFile", blockedPath)
+	os.Setenv("allowedEmailDomainsFile", allowedPath)
+	defer func() {
+		os.Unsetenv("blockedEmailDomainsFile")
+		os.Unsetenv("allowedEmailDomainsFile")
+		expireEmailDomains()
+		refreshEmailDomains()
+	}()
+
+	expireEmailDomains()
+	refreshEmailDomains()
+
+	cases := []struct {
+		name     string
+		domain   string
+		expected bool
+	}{
+		{
+			name:     "keeps the built-in domains",
+			domain:   "yopmail.com",
+			expected: true,
+		},
+		{
+			name:     "blocks domains from the blocklist file",
+			domain:   "burner.example",
+			expected: true,
+		},
+		{
+			name:     "blocks wildcard domains from the blocklist file",
+			domain:   "mx.throwaway.example",
+			expected: true,
+		},
+		{
+			name:     "allowlist file overrides the built-in domains",
+			domain:   "partners.mailinator.com",
+			expected: false,
+		},
+		{
+			name:     "built-in domains still block other subdomains",
+			domain:   "x.mailinator.com",
+			expected: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			res := utils.IsKnownSpamEmail(utils.Email{LocalPart: "leo", Domain: c.domain})
+
+			if diff := cmp.Diff(c.expected, res); diff != "" {
+				t.Errorf("\nUnexpected result (-want, +got)\n%s", diff)
+			}
+		})
+	}
+}
+
+func Test_isValidDomainRule(t *testing.T) {
+	cases := []struct {
+		name     string
+		input    EmailDomainRule
+		expected bool
+	}{
+		{
+			name:     "accepts a blocked domain",
+			input:    EmailDomainRule{Domain: "mailinator.com", List: blockedDomainList},
+			expected: true,
+		},
+		{
+			name:     "accepts an allowed wildcard domain",
+			input:    EmailDomainRule{Domain: "*.fender.com", List: allowedDomainList},
+			expected: true,
+		},
+		{
+			name:     "rejects an unknown list",
+			input:    EmailDomainRule{Domain: "mailinator.com", List: "maybe"},
+			expected: false,
+		},
+		{
+			name:     "rejects a bare TLD",
+			input:    EmailDomainRule{Domain: "com", List: blockedDomainList},
+			expected: false,
+		},
+		{
+			name:     "rejects an email address",
+			input:    EmailDomainRule{Domain: "leo@fender.com", List: blockedDomainList},
+			expected: false,
+		},
+		{
+			name:     "rejects a wildcard in the middle",
+			input:    EmailDomainRule{Domain: "mail.*.com", List: blockedDomainList},
+			expected: false,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			res := isValidDomainRule(c.input)
+
+			if diff := cmp.Diff(c.expected, res); diff != "" {
+				t.Errorf("\nUnexpected result (-want, +got)\n%s", diff)
+			}
+		})
+	}
+}
+
+func Test_checkAdminKey(t *testing.T) {
+	cases := []struct {
+		name    string
+		secret  string
+		headers map[string]string
+		err     error
+	}{
+		{
+			name:    "accepts the configured key",
+			secret:  "super-secret",
+			headers: map[string]string{"X-Admin-Key": "super-secret"},
+			err:     nil,
+		},
+		{
+			name:    "rejects the wrong key",
+			secret:  "super-secret",
+			headers: map[string]string{"X-Admin-Key": "guess"},
+			err:     utils.UnauthorizedError(),
+		},
+		{
+			name:    "rejects everything when no secret is configured",
+			secret:  "",
+			headers: map[string]string{"X-Admin-Key": ""},
+			err:     utils.UnauthorizedError(),
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			os.Setenv("AdminSecret", c.secret)
+			defer os.Unsetenv("AdminSecret")
+
+			utils.AssertErrorsEqual(t, c.err, checkAdminKey(c.headers))
+		})
+	}
+}
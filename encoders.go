@@ -1,17 +1,27 @@
 package platform_exercise
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
 
 type CreateUserRequest struct {
-	Name     string `json:"name" validate:"required"`
-	Email    string `json:"email" validate:"required,email"`
-	Password string `json:"password" validate:"required,gt=0"`
+	Name        string      `json:"name" validate:"required_without_all=GivenName FamilyName"`
+	GivenName   string      `json:"givenName"`
+	FamilyName  string      `json:"familyName"`
+	DisplayName string      `json:"displayName"`
+	Email       string      `json:"email" validate:"required,email"`
+	Password    string      `json:"password" validate:"required,gt=0"`
+	Meta        RequestMeta `json:"-"`
 }
 
 type CreateUserResponse struct {
-	ID    string `json:"id"`
-	Name  string `json:"name"`
-	Email string `json:"email"`
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	GivenName   string `json:"givenName,omitempty"`
+	FamilyName  string `json:"familyName,omitempty"`
+	DisplayName string `json:"displayName,omitempty"`
+	Email       string `json:"email"`
 }
 
 type GetUserRequest struct {
@@ -19,31 +29,45 @@ type GetUserRequest struct {
 }
 
 type GetUserResponse struct {
-	ID    string `json:"id"`
-	Name  string `json:"name"`
-	Email string `json:"email"`
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	GivenName   string `json:"givenName,omitempty"`
+	FamilyName  string `json:"familyName,omitempty"`
+	DisplayName string `json:"displayName,omitempty"`
+	Email       string `json:"email"`
 }
 
 type UpdateUserRequest struct {
-	ID          string `json:"id"`
-	Name        string `json:"name"`
-	Email       string `json:"email" validate:"email"`
-	OldPassword string `json:"oldPassword" validate:"required_with=NewPassword"`
-	NewPassword string `json:"newPassword" validate:"required_with=OldPassword"`
+	ID          string      `json:"id"`
+	Name        string      `json:"name"`
+	GivenName   string      `json:"givenName"`
+	FamilyName  string      `json:"familyName"`
+	DisplayName string      `json:"displayName"`
+	Email       string      `json:"email" validate:"email"`
+	OldPassword string      `json:"oldPassword" validate:"required_with=NewPassword Email"`
+	NewPassword string      `json:"newPassword"`
+	Meta        RequestMeta `json:"-"`
 }
 
 type UpdateUserResponse struct {
-	ID    string `json:"id"`
-	Name  string `json:"name"`
-	Email string `json:"email"`
+	ID           string `json:"id"`
+	Name         string `json:"name"`
+	GivenName    string `json:"givenName,omitempty"`
+	FamilyName   string `json:"familyName,omitempty"`
+	DisplayName  string `json:"displayName,omitempty"`
+	Email        string `json:"email"`
+	PendingEmail string `json:"pendingEmail,omitempty"`
 }
 
 type DeleteUserRequest struct {
-	ID string `json:"id" validate:"required"`
+	ID   string      `json:"id" validate:"required"`
+	Mode string      `json:"mode" validate:"omitempty,oneof=soft erase"`
+	Meta RequestMeta `json:"-"`
 }
 
 type DeleteUserResponse struct {
-	ID string `json:"id"`
+	ID       string     `json:"id"`
+	ErasedAt *time.Time `json:"erasedAt,omitempty"`
 }
 
 type ValidateEmailRequest struct {
@@ -51,9 +75,24 @@ type ValidateEmailRequest struct {
 }
 
 type ValidateEmailResponse struct {
-	Email   string `json:"email"`
-	IsValid bool   `json:"isValid"`
-	Error   string `json:"error"`
+	Email         string `json:"email"`
+	IsValid       bool   `json:"isValid"`
+	Error         string `json:"error"`
+	Code          string `json:"code,omitempty"`
+	Reason        string `json:"reason,omitempty"`
+	AliasDecision string `json:"aliasDecision,omitempty"`
+	AliasReason   string `json:"aliasReason,omitempty"`
+	Suggestion    string `json:"suggestion,omitempty"`
+}
+
+type ValidateEmailBatchRequest struct {
+	Emails []string `json:"emails"`
+}
+
+type ValidateEmailBatchResponse struct {
+	Results []ValidateEmailResponse `json:"results"`
+	Valid   int                     `json:"valid"`
+	Invalid int                     `json:"invalid"`
 }
 
 type PasswordStrengthRequest struct {
@@ -66,6 +105,13 @@ type PasswordStrengthResponse struct {
 
 type LoginRequest struct {
 	Credential
+	Meta RequestMeta `json:"-"`
+}
+
+type VerifyLoginRequest struct {
+	ChallengeID string      `json:"challengeId" validate:"required"`
+	Code        string      `json:"code" validate:"required"`
+	Meta        RequestMeta `json:"-"`
 }
 
 type LoginResponse struct {
@@ -73,11 +119,238 @@ type LoginResponse struct {
 	Expiry      time.Time `json:"expiry"`
 }
 
+type ReauthenticateRequest struct {
+	AuthHeader string      `json:"-"`
+	Password   string      `json:"password" validate:"required"`
+	Meta       RequestMeta `json:"-"`
+}
+
 type LogoutRequest struct {
-	ID          string `json:"id"`
-	AccessToken string `json:"access_token"`
+	ID          string      `json:"id"`
+	AccessToken string      `json:"access_token"`
+	Meta        RequestMeta `json:"-"`
 }
 
 type LogoutResponse struct {
 	Success bool `json:"success"`
 }
+
+type EmailDomainRuleRequest struct {
+	Domain string `json:"domain" validate:"required"`
+	List   string `json:"list" validate:"required,oneof=blocked allowed"`
+}
+
+type EmailDomainRuleResponse struct {
+	Domain string `json:"domain"`
+	List   string `json:"list"`
+}
+
+type UserRoleRequest struct {
+	UserID string `json:"userId" validate:"required"`
+	Role   string `json:"role" validate:"required"`
+}
+
+type GetUserRolesRequest struct {
+	ID string `json:"id" validate:"required"`
+}
+
+type UserRolesResponse struct {
+	UserID      string   `json:"userId"`
+	Roles       []string `json:"roles"`
+	Permissions []string `json:"permissions"`
+}
+
+type ListUsersRequest struct {
+	EmailPrefix    string    `json:"emailPrefix"`
+	Name           string    `json:"name"`
+	CreatedAfter   time.Time `json:"createdAfter"`
+	CreatedBefore  time.Time `json:"createdBefore"`
+	Status         string    `json:"status" validate:"omitempty,oneof=active deleted"`
+	IncludeDeleted bool      `json:"includeDeleted"`
+	Sort           string    `json:"sort" validate:"omitempty,oneof=createdAt email name"`
+	Order          string    `json:"order" validate:"omitempty,oneof=asc desc"`
+	Limit          int       `json:"limit"`
+	Cursor         string    `json:"cursor"`
+}
+
+type ListedUser struct {
+	ID          string     `json:"id"`
+	Name        string     `json:"name"`
+	GivenName   string     `json:"givenName,omitempty"`
+	FamilyName  string     `json:"familyName,omitempty"`
+	DisplayName string     `json:"displayName,omitempty"`
+	Email       string     `json:"email"`
+	Status      string     `json:"status"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	DeletedAt   *time.Time `json:"deletedAt,omitempty"`
+}
+
+type ListUsersResponse struct {
+	Users      []ListedUser `json:"users"`
+	NextCursor string       `json:"nextCursor,omitempty"`
+}
+
+type RestoreUserRequest struct {
+	ID    string `json:"id" validate:"required"`
+	Token string `json:"token"`
+}
+
+type RestoreUserResponse struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	Email string `json:"email"`
+}
+
+type PurgeDeletedUsersResponse struct {
+	Purged int64 `json:"purged"`
+}
+
+type UserExportRequest struct {
+	ID    string `json:"id" validate:"required"`
+	Async bool   `json:"async"`
+}
+
+type UserExportResponse struct {
+	Status      string      `json:"status"`
+	ExportID    string      `json:"exportId,omitempty"`
+	DownloadURL string      `json:"downloadUrl,omitempty"`
+	Export      *UserExport `json:"export,omitempty"`
+}
+
+type GetUserExportRequest struct {
+	ID       string `json:"id" validate:"required"`
+	ExportID string `json:"exportId" validate:"required"`
+}
+
+type ProcessUserExportsResponse struct {
+	Processed int   `json:"processed"`
+	Expired   int64 `json:"expired"`
+}
+
+// UserExport is everything stored about a user, as returned to them by
+// GET /user/{id}/export.
+type UserExport struct {
+	GeneratedAt        time.Time             `json:"generatedAt"`
+	Profile            ExportedProfile       `json:"profile"`
+	Roles              []string              `json:"roles"`
+	Permissions        []string              `json:"permissions"`
+	PasswordChanges    []time.Time           `json:"passwordChanges"`
+	RevokedTokens      []ExportedTokenRecord `json:"revokedTokens"`
+	LoginHistory       []LoginHistoryEntry   `json:"loginHistory"`
+	AuditEvents        []ListedAuditEvent    `json:"auditEvents"`
+	PendingEmailChange *ExportedEmailChange  `json:"pendingEmailChange,omitempty"`
+}
+
+type ExportedProfile struct {
+	ID             string     `json:"id"`
+	Name           string     `json:"name"`
+	GivenName      string     `json:"givenName"`
+	FamilyName     string     `json:"familyName"`
+	DisplayName    string     `json:"displayName"`
+	Email          string     `json:"email"`
+	CanonicalEmail string     `json:"canonicalEmail"`
+	CreatedAt      time.Time  `json:"createdAt"`
+	UpdatedAt      time.Time  `json:"updatedAt"`
+	DeletedAt      *time.Time `json:"deletedAt,omitempty"`
+}
+
+type ExportedEmailChange struct {
+	NewEmail    string    `json:"newEmail"`
+	RequestedAt time.Time `json:"requestedAt"`
+	ExpiresAt   time.Time `json:"expiresAt"`
+}
+
+// ExportedTokenRecord describes a logged-out token without the token itself.
+type ExportedTokenRecord struct {
+	RevokedAt time.Time `json:"revokedAt"`
+	ExpiresAt string    `json:"expiresAt"`
+}
+
+type EmailChangeRequest struct {
+	ID    string      `json:"id" validate:"required"`
+	Token string      `json:"token" validate:"required"`
+	Meta  RequestMeta `json:"-"`
+}
+
+type EmailChangeResponse struct {
+	ID    string `json:"id"`
+	Email string `json:"email"`
+}
+
+// RequestMeta describes who made a request and from where, for the audit log.
+type RequestMeta struct {
+	ActorID   string
+	IP        string
+	UserAgent string
+}
+
+type ListAuditEventsRequest struct {
+	ActorID   string    `json:"actorId"`
+	SubjectID string    `json:"subjectId"`
+	Action    string    `json:"action"`
+	After     time.Time `json:"after"`
+	Before    time.Time `json:"before"`
+	Limit     int       `json:"limit"`
+	Cursor    string    `json:"cursor"`
+}
+
+type ListedAuditEvent struct {
+	AuditEvent
+	ChangedFields []string        `json:"changedFields,omitempty"`
+	Details       json.RawMessage `json:"details,omitempty"`
+}
+
+type ListAuditEventsResponse struct {
+	Events     []ListedAuditEvent `json:"events"`
+	NextCursor string             `json:"nextCursor,omitempty"`
+}
+
+type VerifyAuditChainResponse struct {
+	Checked  int64 `json:"checked"`
+	Verified bool  `json:"verified"`
+	BrokenAt int64 `json:"brokenAt,omitempty"`
+}
+
+type LoginHistoryRequest struct {
+	ID     string `json:"id" validate:"required"`
+	Limit  int    `json:"limit"`
+	Cursor string `json:"cursor"`
+}
+
+type LoginHistoryEntry struct {
+	LoginAttempt
+	Device      string   `json:"device"`
+	RiskSignals []string `json:"riskSignals,omitempty"`
+}
+
+type LoginHistoryResponse struct {
+	Logins     []LoginHistoryEntry `json:"logins"`
+	NextCursor string              `json:"nextCursor,omitempty"`
+}
+
+type ListLoginBlocksResponse struct {
+	Blocks []LoginBlock `json:"blocks"`
+}
+
+type IPRuleRequest struct {
+	Action      string      `json:"action" validate:"required"`
+	CIDR        string      `json:"cidr" validate:"required"`
+	Scope       string      `json:"scope" validate:"required"`
+	Subject     string      `json:"subject"`
+	Description string      `json:"description"`
+	Meta        RequestMeta `json:"-"`
+}
+
+type ListIPRulesRequest struct {
+	Scope   string `json:"scope"`
+	Subject string `json:"subject"`
+}
+
+type ListIPRulesResponse struct {
+	Rules []IPRule `json:"rules"`
+}
+
+type DeleteIPRuleRequest struct {
+	ID   string      `json:"id" validate:"required"`
+	Meta RequestMeta `json:"-"`
+}
@@ -0,0 +1,67 @@
+package platform_exercise
+
+import (
+	"time"
+
+	"github.com/campallison/platform-exercise/utils"
+	"gorm.io/gorm"
+)
+
+const (
+	deleteModeSoft  = "soft"
+	deleteModeErase = "erase"
+)
+
+// EraseUser irreversibly removes a user's personal data. The tombstone is
+// written first and completed last, so a failed erasure can be retried and
+// picks up where it stopped, and erasing an erased user returns the same
+// tombstone.
+func EraseUser(req DeleteUserRequest) (UserErasure, error) {
+	db := Init()
+
+	var erasure UserErasure
+	if err := db.Where("user_id = ?", req.ID).First(&erasure).Error; err == nil && erasure.CompletedAt != nil {
+		return erasure, nil
+	}
+
+	if erasure.UserID == "" {
+		if err := db.Unscoped().Where("id = ?", req.ID).First(&User{}).Error; err != nil {
+			return UserErasure{}, utils.UserNotFoundError(req.ID)
+		}
+
+		erasure = UserErasure{UserID: req.ID}
+		if err := db.Create(&erasure).Error; err != nil {
+			return UserErasure{}, utils.EraseUserError(req.ID)
+		}
+	}
+
+	if err := eraseUserTokens(db, req.ID); err != nil {
+		return UserErasure{}, utils.EraseUserError(req.ID)
+	}
+
+	if err := eraseAuditPII(db, req.ID); err != nil {
+		return UserErasure{}, utils.EraseUserError(req.ID)
+	}
+
+	// Password history, roles and exports go with the user row.
+	if err := db.Unscoped().Where("id = ?", req.ID).Delete(&User{}).Error; err != nil {
+		return UserErasure{}, utils.EraseUserError(req.ID)
+	}
+
+	completedAt := time.Now().UTC()
+	if err := db.Model(&erasure).Update("completed_at", completedAt).Error; err != nil {
+		return UserErasure{}, utils.EraseUserError(req.ID)
+	}
+	erasure.CompletedAt = &completedAt
+
+	recordAudit(auditUserErased, req.ID, RequestMeta{ActorID: req.Meta.ActorID})
+
+	return erasure, nil
+}
+
+// eraseUserTokens removes the user's logged-out tokens, whose claims carry
+// their email. They stay unusable because authenticate rejects every token of
+// an erased user.
+func eraseUserTokens(db *gorm.DB, userID string) error {
+	return db.Where("user_id = ?", userID).Delete(&InvalidToken{}).Error
+}
@@ -0,0 +1,82 @@
+package platform_exercise
+
+import (
+	"testing"
+
+	"github.com/campallison/platform-exercise/utils"
+	"github.com/google/go-cmp/cmp"
+	"gorm.io/gorm"
+)
+
+func Test_EraseUser(t *testing.T) {
+	databaseTest(t, func(database *gorm.DB) {
+		clearDatabase(database)
+		id := "0d1c2b3a-4e5f-4a6b-8c7d-9e0f1a2b3c4d"
+		otherID := "00000000-0000-4000-8000-000000000001"
+
+		database.Save(&User{ID: id, Name: "Leo Fender", Email: "leo@fender.com", Password: "hash"})
+		database.Save(&User{ID: otherID, Name: "George Fullerton", Email: "george@fender.com"})
+		database.Save(&UserRole{UserID: id, Role: "support"})
+		database.Save(&PasswordHistory{UserID: id, Password: "old hash"})
+
+		token := utils.CreateTestToken(id, "leo@fender.com")
+		otherToken := utils.CreateTestToken(otherID, "george@fender.com")
+		loggedOutToken := utils.CreateTestTokenWithPermissions(id, "leo@fender.com", []string{permReadUsers})
+		database.Save(&InvalidToken{Token: loggedOutToken, UserID: id})
+		database.Save(&InvalidToken{Token: otherToken, UserID: otherID})
+
+		erasure, err := EraseUser(DeleteUserRequest{ID: id, Mode: deleteModeErase})
+		utils.AssertErrorsEqual(t, nil, err)
+
+		if erasure.UserID != id || erasure.CompletedAt == nil {
+			t.Fatalf("expected a completed tombstone, got %+v", erasure)
+		}
+
+		counts := map[string]int64{}
+		for table, query := range map[string]*gorm.DB{
+			"users":            database.Unscoped().Model(&User{}).Where("id = ?", id),
+			"user_roles":       database.Model(&UserRole{}).Where("user_id = ?", id),
+			"password_history": database.Model(&PasswordHistory{}).Where("user_id = ?", id),
+			"invalid_tokens":   database.Model(&InvalidToken{}),
+		} {
+			var count int64
+			query.Count(&count)
+			counts[table] = count
+		}
+
+		expected := map[string]int64{"users": 0, "user_roles": 0, "password_history": 0, "invalid_tokens": 1}
+		if diff := cmp.Diff(expected, counts); diff != "" {
+			t.Errorf("\nUnexpected rows left (-want, +got)\n%s", diff)
+		}
+
+		again, err := EraseUser(DeleteUserRequest{ID: id, Mode: deleteModeErase})
+		utils.AssertErrorsEqual(t, nil, err)
+
+		if diff := cmp.Diff(erasure.CompletedAt.Unix(), again.CompletedAt.Unix()); diff != "" {
+			t.Errorf("\nErasing twice changed the tombstone (-want, +got)\n%s", diff)
+		}
+
+		_, err = RequirePermission("Bearer "+token, "", id, permReadUsers)
+		utils.AssertErrorsEqual(t, utils.InvalidTokenError(), err)
+
+		_, err = EraseUser(DeleteUserRequest{ID: "8b8b2419-0633-47fb-8f0f-7a515f2ccaa1", Mode: deleteModeErase})
+		utils.AssertErrorsEqual(t, utils.UserNotFoundError("8b8b2419-0633-47fb-8f0f-7a515f2ccaa1"), err)
+	})
+}
+
+func Test_EraseUser_resumes(t *testing.T) {
+	databaseTest(t, func(database *gorm.DB) {
+		clearDatabase(database)
+		id := "0d1c2b3a-4e5f-4a6b-8c7d-9e0f1a2b3c4d"
+
+		// An erasure interrupted after the user row was removed.
+		database.Create(&UserErasure{UserID: id})
+
+		erasure, err := EraseUser(DeleteUserRequest{ID: id, Mode: deleteModeErase})
+		utils.AssertErrorsEqual(t, nil, err)
+
+		if erasure.CompletedAt == nil {
+			t.Errorf("expected the erasure to complete, got %+v", erasure)
+		}
+	})
+}
@@ -10,6 +10,7 @@ require (
 	github.com/google/go-cmp v0.5.4
 	github.com/trustelem/zxcvbn v1.0.1
 	golang.org/x/crypto v0.0.0-20200622213623-75b288015ac9
+	golang.org/x/text v0.3.3
 	gorm.io/driver/postgres v1.0.6
 	gorm.io/gorm v1.20.9
 )
@@ -2,11 +2,15 @@ package platform_exercise
 
 import (
 	"encoding/json"
+	"fmt"
 	"log"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/campallison/platform-exercise/utils"
+	jwt "github.com/dgrijalva/jwt-go"
 )
 
 func badRequestResponse(err error) (events.APIGatewayProxyResponse, error) {
@@ -22,12 +26,85 @@ func unauthorizedResponse() (events.APIGatewayProxyResponse, error) {
 	}, nil
 }
 
+// retryAfterResponse answers a request from a source blocked for failed
+// logins with a 429.
+func retryAfterResponse(apiError utils.APIError) (events.APIGatewayProxyResponse, bool) {
+	retryAfter, ok := apiError.Errors.(*utils.RetryAfter)
+	if !ok {
+		return events.APIGatewayProxyResponse{}, false
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: http.StatusTooManyRequests,
+		Headers: map[string]string{
+			"Content-Type": "text/plain",
+			"Retry-After":  strconv.Itoa(retryAfter.Seconds),
+		},
+		Body: apiError.Message,
+	}, true
+}
+
+// authErrorResponse answers 403 when the caller is known but lacks a
+// permission, a 401 challenge when they must authenticate again, a 429 when
+// their source is blocked, and a bare 401 for any problem with the token
+// itself.
+func authErrorResponse(err error) (events.APIGatewayProxyResponse, error) {
+	apiError, _ := err.(utils.APIError)
+
+	if response, ok := retryAfterResponse(apiError); ok {
+		return response, nil
+	}
+
+	if apiError.Code == http.StatusForbidden {
+		return events.APIGatewayProxyResponse{
+			StatusCode: http.StatusForbidden,
+			Headers:    map[string]string{"Content-Type": "text/plain"},
+			Body:       apiError.Message,
+		}, nil
+	}
+
+	if challenge, ok := apiError.Errors.(*utils.StepUpChallenge); ok {
+		body, _ := json.Marshal(challenge)
+
+		return events.APIGatewayProxyResponse{
+			StatusCode: http.StatusUnauthorized,
+			Headers: map[string]string{
+				"Content-Type": "application/json",
+				"WWW-Authenticate": fmt.Sprintf(
+					`Bearer error="%s", max_age=%d`, challenge.Error, challenge.MaxAge,
+				),
+			},
+			Body: string(body),
+		}, nil
+	}
+
+	return unauthorizedResponse()
+}
+
+// requestMeta records who made a request and from where for the audit log.
+// The actor comes only from claims that authentication has verified; requests
+// that need no token have none.
+func requestMeta(request events.APIGatewayProxyRequest, claims jwt.MapClaims) RequestMeta {
+	meta := RequestMeta{
+		IP:        request.RequestContext.Identity.SourceIP,
+		UserAgent: request.Headers["User-Agent"],
+	}
+	if meta.UserAgent == "" {
+		meta.UserAgent = request.Headers["user-agent"]
+	}
+
+	meta.ActorID, _ = claims["Id"].(string)
+
+	return meta
+}
+
 func CreateUserHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
 	log.Printf("In CreateUserHandler, request body:\n%v\n", request.Body)
 	var createUserReq CreateUserRequest
 	if err := json.Unmarshal([]byte(request.Body), &createUserReq); err != nil {
 		return badRequestResponse(err)
 	}
+	createUserReq.Meta = requestMeta(request, nil)
 
 	createdUser, err := CreateUser(createUserReq)
 	if err != nil {
@@ -41,9 +118,12 @@ func CreateUserHandler(request events.APIGatewayProxyRequest) (events.APIGateway
 	}
 
 	body, err := json.Marshal(CreateUserResponse{
-		ID:    createdUser.ID,
-		Name:  createdUser.Name,
-		Email: createdUser.Email,
+		ID:          createdUser.ID,
+		Name:        createdUser.Name,
+		GivenName:   createdUser.GivenName,
+		FamilyName:  createdUser.FamilyName,
+		DisplayName: createdUser.DisplayName,
+		Email:       createdUser.Email,
 	})
 
 	return events.APIGatewayProxyResponse{
@@ -57,8 +137,8 @@ func GetUserHandler(request events.APIGatewayProxyRequest) (events.APIGatewayPro
 	var getUserReq GetUserRequest
 	getUserReq.ID = request.PathParameters["id"]
 
-	if err := CheckToken(request.Headers["Authorization"], getUserReq.ID); err != nil {
-		return unauthorizedResponse()
+	if _, err := RequirePermission(request.Headers["Authorization"], request.RequestContext.Identity.SourceIP, getUserReq.ID, permReadUsers); err != nil {
+		return authErrorResponse(err)
 	}
 
 	retrievedUser, err := GetUser(getUserReq)
@@ -73,9 +153,12 @@ func GetUserHandler(request events.APIGatewayProxyRequest) (events.APIGatewayPro
 	}
 
 	body, err := json.Marshal(GetUserResponse{
-		ID:    retrievedUser.ID,
-		Name:  retrievedUser.Name,
-		Email: retrievedUser.Email,
+		ID:          retrievedUser.ID,
+		Name:        retrievedUser.Name,
+		GivenName:   retrievedUser.GivenName,
+		FamilyName:  retrievedUser.FamilyName,
+		DisplayName: retrievedUser.DisplayName,
+		Email:       retrievedUser.Email,
 	})
 
 	return events.APIGatewayProxyResponse{
@@ -92,10 +175,17 @@ func UpdateUserHandler(request events.APIGatewayProxyRequest) (events.APIGateway
 	}
 	updateUserReq.ID = request.PathParameters["id"]
 
-	if err := CheckToken(request.Headers["Authorization"], updateUserReq.ID); err != nil {
-		return unauthorizedResponse()
+	requireAuth := RequirePermission
+	if updateUserReq.Email != "" || updateUserReq.NewPassword != "" {
+		requireAuth = RequireRecentAuth
 	}
 
+	claims, err := requireAuth(request.Headers["Authorization"], request.RequestContext.Identity.SourceIP, updateUserReq.ID, permWriteUsers)
+	if err != nil {
+		return authErrorResponse(err)
+	}
+	updateUserReq.Meta = requestMeta(request, claims)
+
 	updatedUser, err := UpdateUser(updateUserReq)
 	if err != nil {
 		apiError := err.(utils.APIError)
@@ -108,9 +198,13 @@ func UpdateUserHandler(request events.APIGatewayProxyRequest) (events.APIGateway
 	}
 
 	body, err := json.Marshal(UpdateUserResponse{
-		ID:    updatedUser.ID,
-		Name:  updatedUser.Name,
-		Email: updatedUser.Email,
+		ID:           updatedUser.ID,
+		Name:         updatedUser.Name,
+		GivenName:    updatedUser.GivenName,
+		FamilyName:   updatedUser.FamilyName,
+		DisplayName:  updatedUser.DisplayName,
+		Email:        updatedUser.Email,
+		PendingEmail: PendingEmail(updatedUser.ID),
 	})
 
 	return events.APIGatewayProxyResponse{
@@ -121,11 +215,28 @@ func UpdateUserHandler(request events.APIGatewayProxyRequest) (events.APIGateway
 }
 
 func DeleteUserHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
-	deleteUserReq := DeleteUserRequest{ID: request.PathParameters["id"]}
+	deleteUserReq := DeleteUserRequest{
+		ID:   request.PathParameters["id"],
+		Mode: request.QueryStringParameters["mode"],
+	}
+
+	claims, err := RequireRecentAuth(request.Headers["Authorization"], request.RequestContext.Identity.SourceIP, deleteUserReq.ID, permDeleteUsers)
+	if err != nil {
+		return authErrorResponse(err)
+	}
+	deleteUserReq.Meta = requestMeta(request, claims)
+
+	switch deleteUserReq.Mode {
+	case "", deleteModeSoft:
+	case deleteModeErase:
+		return eraseUserResponse(deleteUserReq)
+	default:
+		apiError := utils.InvalidQueryParameterError("mode", deleteUserReq.Mode).(utils.APIError)
 
-	if err := CheckToken(request.Headers["Authorization"], deleteUserReq.ID); err != nil {
 		return events.APIGatewayProxyResponse{
-			StatusCode: http.StatusUnauthorized,
+			StatusCode: apiError.Code,
+			Headers:    map[string]string{"Content-Type": "text/plain"},
+			Body:       apiError.Message,
 		}, nil
 	}
 
@@ -149,6 +260,27 @@ func DeleteUserHandler(request events.APIGatewayProxyRequest) (events.APIGateway
 	}, nil
 }
 
+func eraseUserResponse(deleteUserReq DeleteUserRequest) (events.APIGatewayProxyResponse, error) {
+	erasure, err := EraseUser(deleteUserReq)
+	if err != nil {
+		apiError := err.(utils.APIError)
+
+		return events.APIGatewayProxyResponse{
+			StatusCode: apiError.Code,
+			Headers:    map[string]string{"Content-Type": "text/plain"},
+			Body:       apiError.Message,
+		}, nil
+	}
+
+	body, _ := json.Marshal(DeleteUserResponse{ID: erasure.UserID, ErasedAt: erasure.CompletedAt})
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: 200,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(body),
+	}, nil
+}
+
 func ValidateEmailHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
 	var validateEmailReq ValidateEmailRequest
 	err := json.Unmarshal([]byte(request.Body), &validateEmailReq)
@@ -156,18 +288,43 @@ func ValidateEmailHandler(request events.APIGatewayProxyRequest) (events.APIGate
 		return badRequestResponse(err)
 	}
 
-	isEmailValid, err := ValidateEmail(validateEmailReq)
-	errToRespond := ""
+	validateEmailResp, err := ValidateEmail(validateEmailReq)
 	if err != nil {
-		errToRespond = err.Error()
+		validateEmailResp.Error = err.Error()
 	}
-	body, err := json.Marshal(ValidateEmailResponse{
-		Email:   validateEmailReq.Email,
-		IsValid: isEmailValid,
-		Error:   errToRespond,
-	})
+	body, err := json.Marshal(validateEmailResp)
+
+	return events.APIGatewayProxyResponse{
+		Body:       string(body),
+		StatusCode: 200,
+	}, nil
+}
+
+func ValidateEmailBatchHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	if _, err := RequirePermission(request.Headers["Authorization"], request.RequestContext.Identity.SourceIP, "", permValidateEmails); err != nil {
+		return authErrorResponse(err)
+	}
+
+	validateEmailBatchReq, err := parseEmailBatch(request.Body)
+	if err != nil {
+		return badRequestResponse(err)
+	}
+
+	validateEmailBatchResp, err := ValidateEmailBatch(validateEmailBatchReq)
+	if err != nil {
+		apiError := err.(utils.APIError)
+
+		return events.APIGatewayProxyResponse{
+			StatusCode: apiError.Code,
+			Headers:    map[string]string{"Content-Type": "text/plain"},
+			Body:       apiError.Message,
+		}, nil
+	}
+
+	body, _ := json.Marshal(validateEmailBatchResp)
 
 	return events.APIGatewayProxyResponse{
+		Headers:    map[string]string{"Content-Type": "application/json"},
 		Body:       string(body),
 		StatusCode: 200,
 	}, nil
@@ -186,15 +343,100 @@ func PasswordStrengthHandler(request events.APIGatewayProxyRequest) (events.APIG
 }
 
 func LoginHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
-	var creds Credential
-	if err := json.Unmarshal([]byte(request.Body), &creds); err != nil {
+	var loginReq LoginRequest
+	if err := json.Unmarshal([]byte(request.Body), &loginReq); err != nil {
+		return badRequestResponse(err)
+	}
+	loginReq.Meta = requestMeta(request, nil)
+
+	loginResult, err := Login(loginReq)
+	if err != nil {
+		return loginErrorResponse(err)
+	}
+
+	body, _ := json.Marshal(loginResult)
+
+	return events.APIGatewayProxyResponse{
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(body),
+		StatusCode: 200,
+	}, nil
+}
+
+// loginErrorResponse answers a login held back by the risk policy with a 401
+// challenge, one from a blocked source with a 429, and one from a globally
+// denied address with a 403.
+func loginErrorResponse(err error) (events.APIGatewayProxyResponse, error) {
+	apiError, _ := err.(utils.APIError)
+
+	if challenge, ok := apiError.Errors.(*utils.LoginChallenge); ok {
+		body, _ := json.Marshal(challenge)
+
+		return events.APIGatewayProxyResponse{
+			StatusCode: http.StatusUnauthorized,
+			Headers:    map[string]string{"Content-Type": "application/json"},
+			Body:       string(body),
+		}, nil
+	}
+
+	if response, ok := retryAfterResponse(apiError); ok {
+		return response, nil
+	}
+
+	if apiError.Code == http.StatusForbidden {
+		return events.APIGatewayProxyResponse{
+			StatusCode: http.StatusForbidden,
+			Headers:    map[string]string{"Content-Type": "text/plain"},
+			Body:       apiError.Message,
+		}, nil
+	}
+
+	return badRequestResponse(err)
+}
+
+func VerifyLoginHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	var verifyLoginReq VerifyLoginRequest
+	if err := json.Unmarshal([]byte(request.Body), &verifyLoginReq); err != nil {
 		return badRequestResponse(err)
 	}
+	verifyLoginReq.Meta = requestMeta(request, nil)
 
-	loginResult, err := Login(creds)
+	loginResult, err := VerifyLogin(verifyLoginReq)
 	if err != nil {
+		apiError := err.(utils.APIError)
+
+		if response, ok := retryAfterResponse(apiError); ok {
+			return response, nil
+		}
+
+		return events.APIGatewayProxyResponse{
+			StatusCode: apiError.Code,
+			Headers:    map[string]string{"Content-Type": "text/plain"},
+			Body:       apiError.Message,
+		}, nil
+	}
+
+	body, _ := json.Marshal(loginResult)
+
+	return events.APIGatewayProxyResponse{
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(body),
+		StatusCode: 200,
+	}, nil
+}
+
+func ReauthenticateHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	var reauthenticateReq ReauthenticateRequest
+	if err := json.Unmarshal([]byte(request.Body), &reauthenticateReq); err != nil {
 		return badRequestResponse(err)
 	}
+	reauthenticateReq.AuthHeader = request.Headers["Authorization"]
+	reauthenticateReq.Meta = requestMeta(request, nil)
+
+	loginResult, err := Reauthenticate(reauthenticateReq)
+	if err != nil {
+		return authErrorResponse(err)
+	}
 
 	body, _ := json.Marshal(loginResult)
 
@@ -209,12 +451,14 @@ func LogoutHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProx
 	var logoutRequest LogoutRequest
 	id := request.PathParameters["id"]
 	authHeader := request.Headers["Authorization"]
-	if err := CheckToken(authHeader, id); err != nil {
-		return unauthorizedResponse()
+	claims, err := CheckToken(authHeader, request.RequestContext.Identity.SourceIP, id)
+	if err != nil {
+		return authErrorResponse(err)
 	}
 
 	logoutRequest.AccessToken, _ = getTokenFromAuthHeader(authHeader)
 	logoutRequest.ID = id
+	logoutRequest.Meta = requestMeta(request, claims)
 
 	logoutResult, err := Logout(logoutRequest)
 	if err != nil {
@@ -229,3 +473,596 @@ func LogoutHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProx
 		StatusCode: 200,
 	}, nil
 }
+
+func AddEmailDomainRuleHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	if err := checkAdminKey(request.Headers); err != nil {
+		if _, err := RequirePermission(request.Headers["Authorization"], request.RequestContext.Identity.SourceIP, "", permManageEmailDomains); err != nil {
+			return authErrorResponse(err)
+		}
+	}
+
+	addRuleReq := EmailDomainRuleRequest{
+		Domain: request.PathParameters["domain"],
+		List:   request.PathParameters["list"],
+	}
+
+	addedRule, err := AddEmailDomainRule(addRuleReq)
+	if err != nil {
+		apiError := err.(utils.APIError)
+
+		return events.APIGatewayProxyResponse{
+			StatusCode: apiError.Code,
+			Headers:    map[string]string{"Content-Type": "text/plain"},
+			Body:       apiError.Message,
+		}, nil
+	}
+
+	body, _ := json.Marshal(addedRule)
+
+	return events.APIGatewayProxyResponse{
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(body),
+		StatusCode: 200,
+	}, nil
+}
+
+func RemoveEmailDomainRuleHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	if err := checkAdminKey(request.Headers); err != nil {
+		if _, err := RequirePermission(request.Headers["Authorization"], request.RequestContext.Identity.SourceIP, "", permManageEmailDomains); err != nil {
+			return authErrorResponse(err)
+		}
+	}
+
+	removeRuleReq := EmailDomainRuleRequest{
+		Domain: request.PathParameters["domain"],
+		List:   request.PathParameters["list"],
+	}
+
+	removedRule, err := RemoveEmailDomainRule(removeRuleReq)
+	if err != nil {
+		apiError := err.(utils.APIError)
+
+		return events.APIGatewayProxyResponse{
+			StatusCode: apiError.Code,
+			Headers:    map[string]string{"Content-Type": "text/plain"},
+			Body:       apiError.Message,
+		}, nil
+	}
+
+	body, _ := json.Marshal(removedRule)
+
+	return events.APIGatewayProxyResponse{
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(body),
+		StatusCode: 200,
+	}, nil
+}
+
+func GetUserRolesHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	getUserRolesReq := GetUserRolesRequest{ID: request.PathParameters["id"]}
+
+	if _, err := RequirePermission(request.Headers["Authorization"], request.RequestContext.Identity.SourceIP, getUserRolesReq.ID, permReadUsers); err != nil {
+		return authErrorResponse(err)
+	}
+
+	userRoles, err := GetUserRoles(getUserRolesReq)
+	if err != nil {
+		apiError := err.(utils.APIError)
+
+		return events.APIGatewayProxyResponse{
+			StatusCode: apiError.Code,
+			Headers:    map[string]string{"Content-Type": "text/plain"},
+			Body:       apiError.Message,
+		}, nil
+	}
+
+	body, _ := json.Marshal(userRoles)
+
+	return events.APIGatewayProxyResponse{
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(body),
+		StatusCode: 200,
+	}, nil
+}
+
+func AssignRoleHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	if _, err := RequirePermission(request.Headers["Authorization"], request.RequestContext.Identity.SourceIP, "", permManageRoles); err != nil {
+		return authErrorResponse(err)
+	}
+
+	userRoleReq := UserRoleRequest{
+		UserID: request.PathParameters["id"],
+		Role:   request.PathParameters["role"],
+	}
+
+	userRoles, err := AssignRole(userRoleReq)
+	if err != nil {
+		apiError := err.(utils.APIError)
+
+		return events.APIGatewayProxyResponse{
+			StatusCode: apiError.Code,
+			Headers:    map[string]string{"Content-Type": "text/plain"},
+			Body:       apiError.Message,
+		}, nil
+	}
+
+	body, _ := json.Marshal(userRoles)
+
+	return events.APIGatewayProxyResponse{
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(body),
+		StatusCode: 200,
+	}, nil
+}
+
+func RevokeRoleHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	if _, err := RequirePermission(request.Headers["Authorization"], request.RequestContext.Identity.SourceIP, "", permManageRoles); err != nil {
+		return authErrorResponse(err)
+	}
+
+	userRoleReq := UserRoleRequest{
+		UserID: request.PathParameters["id"],
+		Role:   request.PathParameters["role"],
+	}
+
+	userRoles, err := RevokeRole(userRoleReq)
+	if err != nil {
+		apiError := err.(utils.APIError)
+
+		return events.APIGatewayProxyResponse{
+			StatusCode: apiError.Code,
+			Headers:    map[string]string{"Content-Type": "text/plain"},
+			Body:       apiError.Message,
+		}, nil
+	}
+
+	body, _ := json.Marshal(userRoles)
+
+	return events.APIGatewayProxyResponse{
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(body),
+		StatusCode: 200,
+	}, nil
+}
+
+func ListUsersHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	if _, err := RequirePermission(request.Headers["Authorization"], request.RequestContext.Identity.SourceIP, "", permListUsers); err != nil {
+		return authErrorResponse(err)
+	}
+
+	listUsersReq, err := parseListUsersRequest(request.QueryStringParameters)
+	if err != nil {
+		apiError := err.(utils.APIError)
+
+		return events.APIGatewayProxyResponse{
+			StatusCode: apiError.Code,
+			Headers:    map[string]string{"Content-Type": "text/plain"},
+			Body:       apiError.Message,
+		}, nil
+	}
+
+	listUsersResp, err := ListUsers(listUsersReq)
+	if err != nil {
+		apiError := err.(utils.APIError)
+
+		return events.APIGatewayProxyResponse{
+			StatusCode: apiError.Code,
+			Headers:    map[string]string{"Content-Type": "text/plain"},
+			Body:       apiError.Message,
+		}, nil
+	}
+
+	body, _ := json.Marshal(listUsersResp)
+
+	return events.APIGatewayProxyResponse{
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(body),
+		StatusCode: 200,
+	}, nil
+}
+
+func RestoreUserHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	var restoreUserReq RestoreUserRequest
+	if request.Body != "" {
+		if err := json.Unmarshal([]byte(request.Body), &restoreUserReq); err != nil {
+			return badRequestResponse(err)
+		}
+	}
+	restoreUserReq.ID = request.PathParameters["id"]
+
+	// Without a token from the restore email, only staff may restore accounts.
+	if restoreUserReq.Token == "" {
+		if _, err := RequirePermission(request.Headers["Authorization"], request.RequestContext.Identity.SourceIP, "", permDeleteUsers); err != nil {
+			return authErrorResponse(err)
+		}
+	}
+
+	restoredUser, err := RestoreUser(restoreUserReq)
+	if err != nil {
+		apiError := err.(utils.APIError)
+
+		return events.APIGatewayProxyResponse{
+			StatusCode: apiError.Code,
+			Headers:    map[string]string{"Content-Type": "text/plain"},
+			Body:       apiError.Message,
+		}, nil
+	}
+
+	body, _ := json.Marshal(RestoreUserResponse{
+		ID:    restoredUser.ID,
+		Name:  restoredUser.Name,
+		Email: restoredUser.Email,
+	})
+
+	return events.APIGatewayProxyResponse{
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(body),
+		StatusCode: 200,
+	}, nil
+}
+
+// PurgeDeletedUsersHandler runs on a schedule rather than behind the API.
+func PurgeDeletedUsersHandler(event events.CloudWatchEvent) (PurgeDeletedUsersResponse, error) {
+	purged, err := PurgeDeletedUsers(time.Now().Add(-accountRestoreWindow()))
+	if err != nil {
+		return PurgeDeletedUsersResponse{}, err
+	}
+
+	log.Printf("\nPurged %d users deleted more than %v ago\n", purged, accountRestoreWindow())
+
+	return PurgeDeletedUsersResponse{Purged: purged}, nil
+}
+
+func UserExportHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	userExportReq := UserExportRequest{
+		ID:    request.PathParameters["id"],
+		Async: request.QueryStringParameters["async"] == "true",
+	}
+
+	if _, err := RequirePermission(request.Headers["Authorization"], request.RequestContext.Identity.SourceIP, userExportReq.ID, permReadUsers); err != nil {
+		return authErrorResponse(err)
+	}
+
+	userExportResp, err := RequestUserExport(userExportReq)
+	if err != nil {
+		apiError := err.(utils.APIError)
+
+		return events.APIGatewayProxyResponse{
+			StatusCode: apiError.Code,
+			Headers:    map[string]string{"Content-Type": "text/plain"},
+			Body:       apiError.Message,
+		}, nil
+	}
+
+	if userExportResp.Export != nil {
+		body, _ := json.Marshal(userExportResp.Export)
+		return userExportArchiveResponse(userExportReq.ID, string(body))
+	}
+
+	body, _ := json.Marshal(userExportResp)
+
+	return events.APIGatewayProxyResponse{
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(body),
+		StatusCode: http.StatusAccepted,
+	}, nil
+}
+
+func GetUserExportHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	getUserExportReq := GetUserExportRequest{
+		ID:       request.PathParameters["id"],
+		ExportID: request.PathParameters["exportId"],
+	}
+
+	if _, err := RequirePermission(request.Headers["Authorization"], request.RequestContext.Identity.SourceIP, getUserExportReq.ID, permReadUsers); err != nil {
+		return authErrorResponse(err)
+	}
+
+	job, err := GetUserExport(getUserExportReq)
+	if err != nil {
+		apiError := err.(utils.APIError)
+
+		return events.APIGatewayProxyResponse{
+			StatusCode: apiError.Code,
+			Headers:    map[string]string{"Content-Type": "text/plain"},
+			Body:       apiError.Message,
+		}, nil
+	}
+
+	if job.Status == userExportReady {
+		return userExportArchiveResponse(getUserExportReq.ID, job.Archive)
+	}
+
+	body, _ := json.Marshal(UserExportResponse{
+		Status:      job.Status,
+		ExportID:    job.ID,
+		DownloadURL: userExportURL(job.UserID, job.ID),
+	})
+
+	return events.APIGatewayProxyResponse{
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(body),
+		StatusCode: http.StatusAccepted,
+	}, nil
+}
+
+func userExportArchiveResponse(userID string, archive string) (events.APIGatewayProxyResponse, error) {
+	return events.APIGatewayProxyResponse{
+		Headers: map[string]string{
+			"Content-Type":        "application/json",
+			"Content-Disposition": fmt.Sprintf(`attachment; filename="user-%s-export.json"`, userID),
+		},
+		Body:       archive,
+		StatusCode: 200,
+	}, nil
+}
+
+// ProcessUserExportsHandler runs on a schedule rather than behind the API.
+func ProcessUserExportsHandler(event events.CloudWatchEvent) (ProcessUserExportsResponse, error) {
+	processUserExportsResp, err := ProcessUserExports(time.Now())
+	if err != nil {
+		return processUserExportsResp, err
+	}
+
+	log.Printf("\nBuilt %d user exports, removed %d expired\n", processUserExportsResp.Processed, processUserExportsResp.Expired)
+
+	return processUserExportsResp, nil
+}
+
+func ConfirmEmailChangeHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	return emailChangeResponse(request, ConfirmEmailChange)
+}
+
+func CancelEmailChangeHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	return emailChangeResponse(request, CancelEmailChange)
+}
+
+// emailChangeResponse serves the links mailed by UpdateUser. The token in the
+// body authorizes the request, so no bearer token is needed.
+func emailChangeResponse(
+	request events.APIGatewayProxyRequest,
+	action func(EmailChangeRequest) (User, error),
+) (events.APIGatewayProxyResponse, error) {
+	var emailChangeReq EmailChangeRequest
+	if err := json.Unmarshal([]byte(request.Body), &emailChangeReq); err != nil {
+		return badRequestResponse(err)
+	}
+	emailChangeReq.ID = request.PathParameters["id"]
+	emailChangeReq.Meta = requestMeta(request, nil)
+
+	user, err := action(emailChangeReq)
+	if err != nil {
+		apiError := err.(utils.APIError)
+
+		return events.APIGatewayProxyResponse{
+			StatusCode: apiError.Code,
+			Headers:    map[string]string{"Content-Type": "text/plain"},
+			Body:       apiError.Message,
+		}, nil
+	}
+
+	body, _ := json.Marshal(EmailChangeResponse{ID: user.ID, Email: user.Email})
+
+	return events.APIGatewayProxyResponse{
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(body),
+		StatusCode: 200,
+	}, nil
+}
+
+func ListAuditEventsHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	if _, err := RequirePermission(request.Headers["Authorization"], request.RequestContext.Identity.SourceIP, "", permReadAudit); err != nil {
+		return authErrorResponse(err)
+	}
+
+	listAuditEventsReq, err := parseListAuditEventsRequest(request.QueryStringParameters)
+	if err != nil {
+		apiError := err.(utils.APIError)
+
+		return events.APIGatewayProxyResponse{
+			StatusCode: apiError.Code,
+			Headers:    map[string]string{"Content-Type": "text/plain"},
+			Body:       apiError.Message,
+		}, nil
+	}
+
+	listAuditEventsResp, err := ListAuditEvents(listAuditEventsReq)
+	if err != nil {
+		apiError := err.(utils.APIError)
+
+		return events.APIGatewayProxyResponse{
+			StatusCode: apiError.Code,
+			Headers:    map[string]string{"Content-Type": "text/plain"},
+			Body:       apiError.Message,
+		}, nil
+	}
+
+	body, _ := json.Marshal(listAuditEventsResp)
+
+	return events.APIGatewayProxyResponse{
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(body),
+		StatusCode: 200,
+	}, nil
+}
+
+func VerifyAuditChainHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	if _, err := RequirePermission(request.Headers["Authorization"], request.RequestContext.Identity.SourceIP, "", permReadAudit); err != nil {
+		return authErrorResponse(err)
+	}
+
+	verifyAuditChainResp, err := VerifyAuditChain()
+	if err != nil {
+		apiError := err.(utils.APIError)
+
+		return events.APIGatewayProxyResponse{
+			StatusCode: apiError.Code,
+			Headers:    map[string]string{"Content-Type": "text/plain"},
+			Body:       apiError.Message,
+		}, nil
+	}
+
+	body, _ := json.Marshal(verifyAuditChainResp)
+
+	return events.APIGatewayProxyResponse{
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(body),
+		StatusCode: 200,
+	}, nil
+}
+
+func LoginHistoryHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	id := request.PathParameters["id"]
+
+	if _, err := RequirePermission(request.Headers["Authorization"], request.RequestContext.Identity.SourceIP, id, permReadUsers); err != nil {
+		return authErrorResponse(err)
+	}
+
+	loginHistoryReq, err := parseLoginHistoryRequest(id, request.QueryStringParameters)
+	if err != nil {
+		apiError := err.(utils.APIError)
+
+		return events.APIGatewayProxyResponse{
+			StatusCode: apiError.Code,
+			Headers:    map[string]string{"Content-Type": "text/plain"},
+			Body:       apiError.Message,
+		}, nil
+	}
+
+	loginHistoryResp, err := GetLoginHistory(loginHistoryReq)
+	if err != nil {
+		apiError := err.(utils.APIError)
+
+		return events.APIGatewayProxyResponse{
+			StatusCode: apiError.Code,
+			Headers:    map[string]string{"Content-Type": "text/plain"},
+			Body:       apiError.Message,
+		}, nil
+	}
+
+	body, _ := json.Marshal(loginHistoryResp)
+
+	return events.APIGatewayProxyResponse{
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(body),
+		StatusCode: 200,
+	}, nil
+}
+
+func ListLoginBlocksHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	if _, err := RequirePermission(request.Headers["Authorization"], request.RequestContext.Identity.SourceIP, "", permReadLoginBlocks); err != nil {
+		return authErrorResponse(err)
+	}
+
+	listLoginBlocksResp, err := ListLoginBlocks()
+	if err != nil {
+		apiError := err.(utils.APIError)
+
+		return events.APIGatewayProxyResponse{
+			StatusCode: apiError.Code,
+			Headers:    map[string]string{"Content-Type": "text/plain"},
+			Body:       apiError.Message,
+		}, nil
+	}
+
+	body, _ := json.Marshal(listLoginBlocksResp)
+
+	return events.APIGatewayProxyResponse{
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(body),
+		StatusCode: 200,
+	}, nil
+}
+
+func ListIPRulesHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	if _, err := RequirePermission(request.Headers["Authorization"], request.RequestContext.Identity.SourceIP, "", permManageIPRules); err != nil {
+		return authErrorResponse(err)
+	}
+
+	listIPRulesReq := ListIPRulesRequest{
+		Scope:   request.QueryStringParameters["scope"],
+		Subject: request.QueryStringParameters["subject"],
+	}
+
+	listIPRulesResp, err := ListIPRules(listIPRulesReq)
+	if err != nil {
+		apiError := err.(utils.APIError)
+
+		return events.APIGatewayProxyResponse{
+			StatusCode: apiError.Code,
+			Headers:    map[string]string{"Content-Type": "text/plain"},
+			Body:       apiError.Message,
+		}, nil
+	}
+
+	body, _ := json.Marshal(listIPRulesResp)
+
+	return events.APIGatewayProxyResponse{
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(body),
+		StatusCode: 200,
+	}, nil
+}
+
+func CreateIPRuleHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	claims, err := RequirePermission(request.Headers["Authorization"], request.RequestContext.Identity.SourceIP, "", permManageIPRules)
+	if err != nil {
+		return authErrorResponse(err)
+	}
+
+	var ipRuleReq IPRuleRequest
+	if err := json.Unmarshal([]byte(request.Body), &ipRuleReq); err != nil {
+		return badRequestResponse(err)
+	}
+	ipRuleReq.Meta = requestMeta(request, claims)
+
+	createdRule, err := CreateIPRule(ipRuleReq)
+	if err != nil {
+		apiError := err.(utils.APIError)
+
+		return events.APIGatewayProxyResponse{
+			StatusCode: apiError.Code,
+			Headers:    map[string]string{"Content-Type": "text/plain"},
+			Body:       apiError.Message,
+		}, nil
+	}
+
+	body, _ := json.Marshal(createdRule)
+
+	return events.APIGatewayProxyResponse{
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(body),
+		StatusCode: 200,
+	}, nil
+}
+
+func DeleteIPRuleHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	claims, err := RequirePermission(request.Headers["Authorization"], request.RequestContext.Identity.SourceIP, "", permManageIPRules)
+	if err != nil {
+		return authErrorResponse(err)
+	}
+
+	deleteIPRuleReq := DeleteIPRuleRequest{
+		ID:   request.PathParameters["id"],
+		Meta: requestMeta(request, claims),
+	}
+
+	deletedRule, err := DeleteIPRule(deleteIPRuleReq)
+	if err != nil {
+		apiError := err.(utils.APIError)
+
+		return events.APIGatewayProxyResponse{
+			StatusCode: apiError.Code,
+			Headers:    map[string]string{"Content-Type": "text/plain"},
+			Body:       apiError.Message,
+		}, nil
+	}
+
+	body, _ := json.Marshal(deletedRule)
+
+	return events.APIGatewayProxyResponse{
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(body),
+		StatusCode: 200,
+	}, nil
+}
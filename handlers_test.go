@@ -1,10 +1,13 @@
 package platform_exercise
 
 import (
+	"os"
 	"testing"
+	"time"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/campallison/platform-exercise/utils"
+	jwt "github.com/dgrijalva/jwt-go"
 	"github.com/google/go-cmp/cmp"
 	"gorm.io/gorm"
 )
@@ -88,6 +91,13 @@ func Test_GetUserHandler(t *testing.T) {
 
 		aj := "application/json"
 		validTokenHeader := utils.CreateTestAuthHeader(token, aj)
+		supportUser := User{ID: "8b8b2419-0633-47fb-8f0f-7a515f2ccaa1", Name: "Freddie Tavares", Email: "support@fender.com"}
+		database.Save(&supportUser)
+		database.Save(&UserRole{UserID: supportUser.ID, Role: "support"})
+		supportToken := utils.CreateTestTokenWithPermissions(
+			supportUser.ID, supportUser.Email, []string{permReadUsers},
+		)
+		supportTokenHeader := utils.CreateTestAuthHeader(supportToken, aj)
 
 		cases := []struct {
 			name    string
@@ -115,6 +125,16 @@ func Test_GetUserHandler(t *testing.T) {
 				headers: validTokenHeader,
 				status:  401,
 			},
+			{
+				name: "successful call with another user's token holding users:read",
+				request: events.APIGatewayProxyRequest{
+					HTTPMethod:     "GET",
+					Headers:        supportTokenHeader,
+					PathParameters: map[string]string{"id": user.ID},
+				},
+				headers: supportTokenHeader,
+				status:  200,
+			},
 			{
 				name: "error for user ID not found",
 				request: events.APIGatewayProxyRequest{
@@ -123,7 +143,7 @@ func Test_GetUserHandler(t *testing.T) {
 					PathParameters: map[string]string{"id": "8b8b2419-0633-47fb-8f0f-7a515f2ccaa1"},
 				},
 				headers: validTokenHeader,
-				status:  401,
+				status:  403,
 			},
 		}
 
@@ -194,7 +214,7 @@ func Test_UpdateUserHandler(t *testing.T) {
 					Body:           `{"name": "Clarence L. Fender"}`,
 				},
 				headers: validTokenHeader,
-				status:  401,
+				status:  403,
 			},
 		}
 
@@ -271,7 +291,29 @@ func Test_DeleteUserHandler(t *testing.T) {
 					Body:           `{"name": "Clarence L. Fender"}`,
 				},
 				headers: validTokenHeader,
-				status:  401,
+				status:  403,
+			},
+			{
+				name: "error for an unknown delete mode",
+				request: events.APIGatewayProxyRequest{
+					HTTPMethod:            "DELETE",
+					Headers:               user2TokenHeader,
+					PathParameters:        map[string]string{"id": user2.ID},
+					QueryStringParameters: map[string]string{"mode": "shred"},
+				},
+				headers: user2TokenHeader,
+				status:  400,
+			},
+			{
+				name: "erases with a valid token",
+				request: events.APIGatewayProxyRequest{
+					HTTPMethod:            "DELETE",
+					Headers:               user2TokenHeader,
+					PathParameters:        map[string]string{"id": user2.ID},
+					QueryStringParameters: map[string]string{"mode": "erase"},
+				},
+				headers: user2TokenHeader,
+				status:  200,
 			},
 		}
 
@@ -357,3 +399,123 @@ func Test_PasswordStrengthHandler(t *testing.T) {
 		})
 	}
 }
+
+func Test_DeleteUserHandler_stepUp(t *testing.T) {
+	databaseTest(t, func(database *gorm.DB) {
+		clearDatabase(database)
+
+		user := User{Name: "Leo Fender", Email: "leo@fender.com", Password: "SkunkStripeMapleNeckRosewoodFingerboard"}
+		database.Save(&user)
+
+		staleToken, _ := jwt.NewWithClaims(jwt.GetSigningMethod("HS512"), jwt.MapClaims{
+			"Id":        user.ID,
+			"ExpiresAt": time.Now().In(time.UTC).Add(time.Hour),
+			"AuthTime":  time.Now().In(time.UTC).Add(-11 * time.Hour),
+			"Subject":   user.Email,
+		}).SignedString([]byte(os.Getenv("SigningSecret")))
+
+		response, _ := DeleteUserHandler(events.APIGatewayProxyRequest{
+			HTTPMethod:     "DELETE",
+			Headers:        utils.CreateTestAuthHeader(staleToken, "application/json"),
+			PathParameters: map[string]string{"id": user.ID},
+		})
+
+		if diff := cmp.Diff(401, response.StatusCode); diff != "" {
+			t.Errorf("\nunexpected response (-want, +got)\n%s", diff)
+		}
+
+		if diff := cmp.Diff(
+			`{"error":"insufficient_user_authentication","maxAge":300,"methods":["password"]}`,
+			response.Body,
+		); diff != "" {
+			t.Errorf("\nunexpected challenge (-want, +got)\n%s", diff)
+		}
+
+		if response.Headers["WWW-Authenticate"] == "" {
+			t.Error("expected a WWW-Authenticate header")
+		}
+	})
+}
+
+func Test_ListUsersHandler(t *testing.T) {
+	databaseTest(t, func(database *gorm.DB) {
+		clearDatabase(database)
+
+		support := User{Name: "Freddie Tavares", Email: "freddie@fender.com"}
+		admin := User{Name: "Leo Fender", Email: "leo@fender.com"}
+		database.Save(&support)
+		database.Save(&admin)
+		database.Save(&UserRole{UserID: support.ID, Role: "support"})
+		database.Save(&UserRole{UserID: admin.ID, Role: "admin"})
+
+		cases := []struct {
+			name   string
+			user   User
+			status int
+		}{
+			{
+				name:   "support staff cannot list users",
+				user:   support,
+				status: 403,
+			},
+			{
+				name:   "admins can list users",
+				user:   admin,
+				status: 200,
+			},
+		}
+
+		for _, c := range cases {
+			t.Run(c.name, func(t *testing.T) {
+				response, _ := ListUsersHandler(events.APIGatewayProxyRequest{
+					HTTPMethod: "GET",
+					Headers:    utils.CreateTestAuthHeader(utils.CreateTestToken(c.user.ID, c.user.Email), "application/json"),
+				})
+
+				if diff := cmp.Diff(c.status, response.StatusCode); diff != "" {
+					t.Errorf("\nunexpected response (-want, +got)\n%s", diff)
+				}
+			})
+		}
+	})
+}
+
+func Test_requestMeta(t *testing.T) {
+	forged, _ := jwt.NewWithClaims(jwt.SigningMethodNone, jwt.MapClaims{
+		"Id": "0d1c2b3a-4e5f-4a6b-8c7d-9e0f1a2b3c4d",
+	}).SignedString(jwt.UnsafeAllowNoneSignatureType)
+
+	request := events.APIGatewayProxyRequest{
+		Headers: map[string]string{
+			"Authorization": "Bearer " + forged,
+			"User-Agent":    "curl/7.64.1",
+		},
+		RequestContext: events.APIGatewayProxyRequestContext{
+			Identity: events.APIGatewayRequestIdentity{SourceIP: "192.0.2.1"},
+		},
+	}
+
+	cases := []struct {
+		name     string
+		claims   jwt.MapClaims
+		expected RequestMeta
+	}{
+		{
+			name:     "ignores the Authorization header without verified claims",
+			expected: RequestMeta{IP: "192.0.2.1", UserAgent: "curl/7.64.1"},
+		},
+		{
+			name:     "takes the actor from verified claims",
+			claims:   jwt.MapClaims{"Id": "9a8b7c6d-5e4f-4a3b-8c2d-1e0f9a8b7c6d"},
+			expected: RequestMeta{ActorID: "9a8b7c6d-5e4f-4a3b-8c2d-1e0f9a8b7c6d", IP: "192.0.2.1", UserAgent: "curl/7.64.1"},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if diff := cmp.Diff(c.expected, requestMeta(request, c.claims)); diff != "" {
+				t.Errorf("\nunexpected meta (-want, +got)\n%s", diff)
+			}
+		})
+	}
+}
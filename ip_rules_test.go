@@ -0,0 +1,234 @@
+package platform_exercise
+
+import (
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/campallison/platform-exercise/utils"
+	"github.com/google/go-cmp/cmp"
+	"gorm.io/gorm"
+)
+
+func Test_evaluateIPRules(t *testing.T) {
+	id := "2b3c4d5e-6f7a-4b8c-9d0e-1f2a3b4c5d6e"
+	rule := func(action, cidr, scope, subject string) IPRule {
+		return IPRule{Action: action, CIDR: cidr, Scope: scope, Subject: subject}
+	}
+
+	cases := []struct {
+		name     string
+		rules    []IPRule
+		ip       string
+		roles    []string
+		expected bool
+	}{
+		{name: "no rules", ip: "203.0.113.7", expected: true},
+		{
+			name:  "global deny",
+			rules: []IPRule{rule(ipRuleDeny, "203.0.113.0/24", ipRuleGlobal, "")},
+			ip:    "203.0.113.7",
+		},
+		{
+			name:     "global allow from inside",
+			rules:    []IPRule{rule(ipRuleAllow, "10.0.0.0/8", ipRuleGlobal, "")},
+			ip:       "10.1.2.3",
+			expected: true,
+		},
+		{
+			name:  "global allow from outside",
+			rules: []IPRule{rule(ipRuleAllow, "10.0.0.0/8", ipRuleGlobal, "")},
+			ip:    "203.0.113.7",
+		},
+		{
+			name: "deny beats a matching allow",
+			rules: []IPRule{
+				rule(ipRuleAllow, "10.0.0.0/8", ipRuleGlobal, ""),
+				rule(ipRuleDeny, "10.0.0.5/32", ipRuleUser, id),
+			},
+			ip: "10.0.0.5",
+		},
+		{
+			name: "role allow narrows a global allow",
+			rules: []IPRule{
+				rule(ipRuleAllow, "0.0.0.0/0", ipRuleGlobal, ""),
+				rule(ipRuleAllow, "10.0.0.0/8", ipRuleRole, "admin"),
+			},
+			ip:    "203.0.113.7",
+			roles: []string{"admin"},
+		},
+		{
+			name: "user allow overrides their role",
+			rules: []IPRule{
+				rule(ipRuleAllow, "10.0.0.0/8", ipRuleRole, "admin"),
+				rule(ipRuleAllow, "203.0.113.7/32", ipRuleUser, id),
+			},
+			ip:       "203.0.113.7",
+			roles:    []string{"admin"},
+			expected: true,
+		},
+		{
+			name: "every restricted role must allow",
+			rules: []IPRule{
+				rule(ipRuleAllow, "10.0.0.0/8", ipRuleRole, "admin"),
+				rule(ipRuleAllow, "192.168.0.0/16", ipRuleRole, "support"),
+			},
+			ip:    "10.1.2.3",
+			roles: []string{"admin", "support"},
+		},
+		{
+			name:     "rules for roles not held are ignored",
+			rules:    []IPRule{rule(ipRuleAllow, "10.0.0.0/8", ipRuleRole, "admin")},
+			ip:       "203.0.113.7",
+			roles:    []string{"support"},
+			expected: true,
+		},
+		{
+			name:     "rules for other users are ignored",
+			rules:    []IPRule{rule(ipRuleDeny, "203.0.113.0/24", ipRuleUser, "someone else")},
+			ip:       "203.0.113.7",
+			expected: true,
+		},
+		{
+			name:     "IPv6 allow",
+			rules:    []IPRule{rule(ipRuleAllow, "2001:db8::/32", ipRuleGlobal, "")},
+			ip:       "2001:db8::1",
+			expected: true,
+		},
+		{
+			name:  "unknown source IP under an allow rule",
+			rules: []IPRule{rule(ipRuleAllow, "0.0.0.0/0", ipRuleGlobal, "")},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if res := evaluateIPRules(c.rules, net.ParseIP(c.ip), id, c.roles); res != c.expected {
+				t.Errorf("expected %v, got %v", c.expected, res)
+			}
+		})
+	}
+}
+
+func Test_normalizeCIDR(t *testing.T) {
+	cases := map[string]string{
+		"203.0.113.7":     "203.0.113.7/32",
+		" 10.1.2.3/8 ":    "10.0.0.0/8",
+		"2001:db8::1":     "2001:db8::1/128",
+		"2001:db8::1/32":  "2001:db8::/32",
+		"203.0.113.7/33":  "",
+		"not an address":  "",
+		"203.0.113.0/24x": "",
+	}
+
+	for cidr, expected := range cases {
+		res, ok := normalizeCIDR(cidr)
+		if res != expected || ok != (expected != "") {
+			t.Errorf("normalizeCIDR(%q) = %q, %v; expected %q", cidr, res, ok, expected)
+		}
+	}
+}
+
+func Test_IPRules(t *testing.T) {
+	databaseTest(t, func(database *gorm.DB) {
+		clearDatabase(database)
+		id := "2b3c4d5e-6f7a-4b8c-9d0e-1f2a3b4c5d6e"
+		password := "SkunkStripeMapleNeckRosewoodFingerboard"
+		hash, _ := HashPassword(password)
+		database.Save(&User{ID: id, Name: "Leo Fender", Email: "leo@fender.com", Password: hash, CreatedAt: time.Now().Add(-48 * time.Hour)})
+		database.Save(&UserRole{UserID: id, Role: "admin"})
+
+		office := RequestMeta{IP: "10.1.2.3"}
+		home := RequestMeta{IP: "203.0.113.7"}
+		login := func(meta RequestMeta) (LoginResponse, error) {
+			return Login(LoginRequest{Credential: Credential{Email: "leo@fender.com", Password: password}, Meta: meta})
+		}
+
+		token, err := login(home)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		t.Run("rejects invalid rules", func(t *testing.T) {
+			cases := []struct {
+				req IPRuleRequest
+				err error
+			}{
+				{IPRuleRequest{Action: "maybe", CIDR: "10.0.0.0/8", Scope: ipRuleGlobal}, utils.InvalidIPRuleError("action must be allow or deny")},
+				{IPRuleRequest{Action: ipRuleAllow, CIDR: "10.0.0.0/33", Scope: ipRuleGlobal}, utils.InvalidIPRuleError("cidr must be an IP address or CIDR network")},
+				{IPRuleRequest{Action: ipRuleAllow, CIDR: "10.0.0.0/8", Scope: "team"}, utils.InvalidIPRuleError("scope must be global, role or user")},
+				{IPRuleRequest{Action: ipRuleAllow, CIDR: "10.0.0.0/8", Scope: ipRuleGlobal, Subject: id}, utils.InvalidIPRuleError("global rules have no subject")},
+				{IPRuleRequest{Action: ipRuleAllow, CIDR: "10.0.0.0/8", Scope: ipRuleRole, Subject: "wizard"}, utils.RoleNotFoundError("wizard")},
+			}
+
+			for _, c := range cases {
+				_, err := CreateIPRule(c.req)
+				utils.AssertErrorsEqual(t, c.err, err)
+			}
+		})
+
+		adminRule, err := CreateIPRule(IPRuleRequest{Action: ipRuleAllow, CIDR: "10.0.0.0/8", Scope: ipRuleRole, Subject: "admin"})
+		utils.AssertErrorsEqual(t, nil, err)
+
+		t.Run("refuses existing tokens from outside the allowed network", func(t *testing.T) {
+			_, err := RequirePermission("Bearer "+token.AccessToken, home.IP, "", permManageIPRules)
+			utils.AssertErrorsEqual(t, utils.IPNotAllowedError(home.IP), err)
+
+			_, err = RequirePermission("Bearer "+token.AccessToken, office.IP, "", permManageIPRules)
+			utils.AssertErrorsEqual(t, nil, err)
+		})
+
+		t.Run("refuses logins from outside the allowed network", func(t *testing.T) {
+			_, err := login(home)
+			utils.AssertErrorsEqual(t, utils.LoginFailedError(), err)
+
+			_, err = login(office)
+			utils.AssertErrorsEqual(t, nil, err)
+		})
+
+		t.Run("refuses a right and a wrong password alike from outside the allowed network", func(t *testing.T) {
+			_, right := login(home)
+			_, wrong := Login(LoginRequest{Credential: Credential{Email: "leo@fender.com", Password: "guess"}, Meta: home})
+
+			utils.AssertErrorsEqual(t, utils.LoginFailedError(), right)
+			utils.AssertErrorsEqual(t, utils.LoginFailedError(), wrong)
+
+			if diff := cmp.Diff(wrong, right); diff != "" {
+				t.Errorf("\nExpected the same error for both passwords (-wrong, +right)\n%s", diff)
+			}
+		})
+
+		t.Run("refuses globally denied addresses before checking the password", func(t *testing.T) {
+			denyRule, err := CreateIPRule(IPRuleRequest{Action: ipRuleDeny, CIDR: "10.1.2.3", Scope: ipRuleGlobal})
+			utils.AssertErrorsEqual(t, nil, err)
+			defer DeleteIPRule(DeleteIPRuleRequest{ID: strconv.FormatInt(denyRule.ID, 10)})
+
+			_, err = Login(LoginRequest{Credential: Credential{Email: "nobody@fender.com", Password: "guess"}, Meta: office})
+			utils.AssertErrorsEqual(t, utils.IPNotAllowedError(office.IP), err)
+		})
+
+		t.Run("lists and deletes rules", func(t *testing.T) {
+			res, err := ListIPRules(ListIPRulesRequest{Scope: ipRuleRole})
+			utils.AssertErrorsEqual(t, nil, err)
+
+			var cidrs []string
+			for _, rule := range res.Rules {
+				cidrs = append(cidrs, rule.CIDR)
+			}
+
+			if diff := cmp.Diff([]string{adminRule.CIDR}, cidrs); diff != "" {
+				t.Errorf("\nUnexpected rules (-want, +got)\n%s", diff)
+			}
+
+			_, err = DeleteIPRule(DeleteIPRuleRequest{ID: strconv.FormatInt(adminRule.ID, 10)})
+			utils.AssertErrorsEqual(t, nil, err)
+
+			_, err = DeleteIPRule(DeleteIPRuleRequest{ID: strconv.FormatInt(adminRule.ID, 10)})
+			utils.AssertErrorsEqual(t, utils.IPRuleNotFoundError(strconv.FormatInt(adminRule.ID, 10)), err)
+
+			_, err = login(home)
+			utils.AssertErrorsEqual(t, nil, err)
+		})
+	})
+}
@@ -0,0 +1,257 @@
+package platform_exercise
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/campallison/platform-exercise/utils"
+	"gorm.io/gorm"
+)
+
+const (
+	loginBlockIP       = "ip"
+	loginBlockSubnet   = "subnet"
+	loginBlockPassword = "password"
+)
+
+const (
+	defaultStuffingWindow           = 10 * time.Minute
+	defaultStuffingBlockDuration    = time.Hour
+	defaultStuffingIPAccounts       = 10
+	defaultStuffingSubnetAccounts   = 25
+	defaultStuffingPasswordAccounts = 5
+
+	failedLoginRetention = 24 * time.Hour
+)
+
+// stuffingLimits are how many different accounts may fail to log in from one
+// source within the window before the source is blocked.
+type stuffingLimits struct {
+	Window           time.Duration
+	BlockDuration    time.Duration
+	IPAccounts       int
+	SubnetAccounts   int
+	PasswordAccounts int
+}
+
+func durationFromEnv(key string, fallback time.Duration) time.Duration {
+	value, err := time.ParseDuration(os.Getenv(key))
+	if err != nil || value <= 0 {
+		return fallback
+	}
+	return value
+}
+
+func loadStuffingLimits() stuffingLimits {
+	return stuffingLimits{
+		Window:           durationFromEnv("stuffingWindow", defaultStuffingWindow),
+		BlockDuration:    durationFromEnv("stuffingBlockDuration", defaultStuffingBlockDuration),
+		IPAccounts:       positiveIntFromEnv("stuffingIPAccounts", defaultStuffingIPAccounts),
+		SubnetAccounts:   positiveIntFromEnv("stuffingSubnetAccounts", defaultStuffingSubnetAccounts),
+		PasswordAccounts: positiveIntFromEnv("stuffingPasswordAccounts", defaultStuffingPasswordAccounts),
+	}
+}
+
+// loginSource is where a login came from and what it tried. The password and
+// account are kept only as digests keyed with the signing secret, so a leaked
+// row cannot be checked against a password list.
+type loginSource struct {
+	IP                  string
+	Subnet              string
+	PasswordFingerprint string
+	AccountDigest       string
+}
+
+func keyedDigest(label string, value string) string {
+	mac := hmac.New(sha256.New, []byte(os.Getenv("SigningSecret")))
+	mac.Write([]byte(label + "\x00" + value))
+	return hex.EncodeToString(mac.Sum(nil))[:32]
+}
+
+func newLoginSource(email string, password string, meta RequestMeta) loginSource {
+	source := loginSource{
+		IP:                  meta.IP,
+		PasswordFingerprint: keyedDigest("password", password),
+		AccountDigest:       keyedDigest("account", canonicalEmail(email)),
+	}
+	if ip := net.ParseIP(meta.IP); ip != nil {
+		source.IP = ip.String()
+		source.Subnet = utils.Subnet(ip)
+	}
+	return source
+}
+
+// blockKeys are the values a block of each kind would match for this source.
+func (s loginSource) blockKeys() map[string]string {
+	keys := map[string]string{loginBlockPassword: s.PasswordFingerprint}
+	if s.IP != "" {
+		keys[loginBlockIP] = s.IP
+	}
+	if s.Subnet != "" {
+		keys[loginBlockSubnet] = s.Subnet
+	}
+	return keys
+}
+
+// activeLoginBlock finds the block that stops a login from this source. It is
+// consulted before the password is checked, so a blocked attacker learns
+// nothing from further guesses. Like the risk checks it fails open.
+func activeLoginBlock(db *gorm.DB, source loginSource, now time.Time) (LoginBlock, bool) {
+	var matches []string
+	args := []interface{}{now}
+	for kind, value := range source.blockKeys() {
+		matches = append(matches, "(kind = ? AND value = ?)")
+		args = append(args, kind, value)
+	}
+
+	var blocks []LoginBlock
+	if err := db.Where("expires_at > ? AND ("+strings.Join(matches, " OR ")+")", args...).
+		Order("expires_at DESC").Limit(1).Find(&blocks).Error; err != nil {
+		log.Printf("\nCould not check login blocks, allowing the login\n%v\n", err)
+		return LoginBlock{}, false
+	}
+
+	if len(blocks) == 0 {
+		return LoginBlock{}, false
+	}
+	return blocks[0], true
+}
+
+// checkLoginBlock refuses a source under a block. Login, Reauthenticate and
+// VerifyLogin all call it before checking a password or code, and record a
+// wrong one with recordFailedLogin.
+func checkLoginBlock(db *gorm.DB, source loginSource, subjectID string, meta RequestMeta) error {
+	block, blocked := activeLoginBlock(db, source, time.Now())
+	if !blocked {
+		return nil
+	}
+
+	recordAuditDetails(auditLoginBlocked, subjectID, meta, loginBlockDetails{
+		BlockID: block.ID,
+		Kind:    block.Kind,
+		Reason:  block.Reason,
+	})
+	return utils.LoginSourceBlockedError(time.Until(block.ExpiresAt))
+}
+
+// loginBlocksFor decides which of a source's keys have failed for too many
+// accounts within the window.
+func loginBlocksFor(source loginSource, accounts map[string]int, limits stuffingLimits, now time.Time) []LoginBlock {
+	checks := []struct {
+		kind   string
+		limit  int
+		reason string
+	}{
+		{loginBlockIP, limits.IPAccounts, "from this address"},
+		{loginBlockSubnet, limits.SubnetAccounts, "from this subnet"},
+		{loginBlockPassword, limits.PasswordAccounts, "with this password"},
+	}
+
+	keys := source.blockKeys()
+
+	var blocks []LoginBlock
+	for _, check := range checks {
+		value, ok := keys[check.kind]
+		if !ok || accounts[check.kind] < check.limit {
+			continue
+		}
+
+		blocks = append(blocks, LoginBlock{
+			CreatedAt: now,
+			Kind:      check.kind,
+			Value:     value,
+			Accounts:  accounts[check.kind],
+			Reason:    fmt.Sprintf("%d accounts failed to log in %s within %s", accounts[check.kind], check.reason, limits.Window),
+			ExpiresAt: now.Add(limits.BlockDuration),
+		})
+	}
+
+	return blocks
+}
+
+// loginBlockDetails is what the audit log keeps about a block: no IP, so it
+// stays in the hash chain without holding personal data.
+type loginBlockDetails struct {
+	BlockID int64  `json:"blockId"`
+	Kind    string `json:"kind"`
+	Reason  string `json:"reason"`
+}
+
+// recordFailedLogin counts a failed login against its source and blocks any
+// key of the source that has now failed for too many different accounts. It
+// is best effort.
+func recordFailedLogin(db *gorm.DB, source loginSource, meta RequestMeta) {
+	now := time.Now().UTC()
+	limits := loadStuffingLimits()
+
+	if err := db.Create(&FailedLogin{
+		CreatedAt:           now,
+		IP:                  source.IP,
+		Subnet:              source.Subnet,
+		PasswordFingerprint: source.PasswordFingerprint,
+		AccountDigest:       source.AccountDigest,
+	}).Error; err != nil {
+		log.Printf("\nCould not record failed login\n%v\n", err)
+		return
+	}
+
+	db.Where("created_at < ?", now.Add(-failedLoginRetention)).Delete(&FailedLogin{})
+
+	columns := map[string]string{
+		loginBlockIP:       "ip",
+		loginBlockSubnet:   "subnet",
+		loginBlockPassword: "password_fingerprint",
+	}
+
+	accounts := map[string]int{}
+	for kind, value := range source.blockKeys() {
+		var count int64
+		if err := db.Model(&FailedLogin{}).
+			Where(columns[kind]+" = ? AND created_at > ?", value, now.Add(-limits.Window)).
+			Distinct("account_digest").Count(&count).Error; err != nil {
+			log.Printf("\nCould not count failed logins by %s\n%v\n", kind, err)
+			continue
+		}
+		accounts[kind] = int(count)
+	}
+
+	for _, block := range loginBlocksFor(source, accounts, limits, now) {
+		var active int64
+		db.Model(&LoginBlock{}).
+			Where("kind = ? AND value = ? AND expires_at > ?", block.Kind, block.Value, now).
+			Count(&active)
+		if active > 0 {
+			continue
+		}
+
+		if err := db.Create(&block).Error; err != nil {
+			log.Printf("\nCould not block %s after failed logins\n%v\n", block.Kind, err)
+			continue
+		}
+
+		recordAuditDetails(auditLoginSourceBlocked, "", meta, loginBlockDetails{
+			BlockID: block.ID,
+			Kind:    block.Kind,
+			Reason:  block.Reason,
+		})
+	}
+}
+
+// ListLoginBlocks returns the blocks still in force, newest first.
+func ListLoginBlocks() (ListLoginBlocksResponse, error) {
+	db := Init()
+
+	blocks := []LoginBlock{}
+	if err := db.Where("expires_at > ?", time.Now()).Order("id DESC").Find(&blocks).Error; err != nil {
+		return ListLoginBlocksResponse{}, utils.ListLoginBlocksError()
+	}
+
+	return ListLoginBlocksResponse{Blocks: blocks}, nil
+}
@@ -0,0 +1,170 @@
+package platform_exercise
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/campallison/platform-exercise/utils"
+	"github.com/google/go-cmp/cmp"
+	"gorm.io/gorm"
+)
+
+func Test_loginBlocksFor(t *testing.T) {
+	now := time.Date(2021, 1, 1, 12, 0, 0, 0, time.UTC)
+	limits := stuffingLimits{
+		Window:           defaultStuffingWindow,
+		BlockDuration:    defaultStuffingBlockDuration,
+		IPAccounts:       defaultStuffingIPAccounts,
+		SubnetAccounts:   defaultStuffingSubnetAccounts,
+		PasswordAccounts: defaultStuffingPasswordAccounts,
+	}
+	source := loginSource{IP: "198.51.100.4", Subnet: "198.51.100.0/24", PasswordFingerprint: "fingerprint"}
+
+	cases := []struct {
+		name     string
+		source   loginSource
+		accounts map[string]int
+		expected []string
+	}{
+		{
+			name:     "under every limit",
+			source:   source,
+			accounts: map[string]int{loginBlockIP: 9, loginBlockSubnet: 9, loginBlockPassword: 4},
+		},
+		{
+			name:     "stuffing from one address",
+			source:   source,
+			accounts: map[string]int{loginBlockIP: 10, loginBlockSubnet: 10, loginBlockPassword: 1},
+			expected: []string{loginBlockIP},
+		},
+		{
+			name:     "stuffing spread across a subnet",
+			source:   source,
+			accounts: map[string]int{loginBlockIP: 3, loginBlockSubnet: 25, loginBlockPassword: 1},
+			expected: []string{loginBlockSubnet},
+		},
+		{
+			name:     "spraying one password",
+			source:   source,
+			accounts: map[string]int{loginBlockIP: 5, loginBlockSubnet: 5, loginBlockPassword: 5},
+			expected: []string{loginBlockPassword},
+		},
+		{
+			name:     "no source address",
+			source:   loginSource{PasswordFingerprint: "fingerprint"},
+			accounts: map[string]int{loginBlockIP: 50, loginBlockSubnet: 50},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var kinds []string
+			for _, block := range loginBlocksFor(c.source, c.accounts, limits, now) {
+				kinds = append(kinds, block.Kind)
+
+				if !block.ExpiresAt.Equal(now.Add(time.Hour)) {
+					t.Errorf("expected %s block to expire after an hour, got %v", block.Kind, block.ExpiresAt)
+				}
+			}
+
+			if diff := cmp.Diff(c.expected, kinds); diff != "" {
+				t.Errorf("\nUnexpected blocks (-want, +got)\n%s", diff)
+			}
+		})
+	}
+}
+
+func Test_Login_credentialStuffing(t *testing.T) {
+	databaseTest(t, func(database *gorm.DB) {
+		clearDatabase(database)
+		password := "SkunkStripeMapleNeckRosewoodFingerboard"
+		hash, _ := HashPassword(password)
+		database.Save(&User{ID: "0d1c2b3a-4e5f-4a6b-8c7d-9e0f1a2b3c4d", Name: "Leo Fender", Email: "leo@fender.com", Password: hash, CreatedAt: time.Now().Add(-48 * time.Hour)})
+
+		os.Setenv("stuffingIPAccounts", "3")
+		os.Setenv("stuffingPasswordAccounts", "3")
+		defer os.Unsetenv("stuffingIPAccounts")
+		defer os.Unsetenv("stuffingPasswordAccounts")
+
+		login := func(email string, password string, ip string) error {
+			_, err := Login(LoginRequest{Credential: Credential{Email: email, Password: password}, Meta: RequestMeta{IP: ip}})
+			return err
+		}
+
+		t.Run("blocks an address failing for many accounts", func(t *testing.T) {
+			for i := 0; i < 3; i++ {
+				login(fmt.Sprintf("victim%d@fender.com", i), fmt.Sprintf("guess %d", i), "203.0.113.7")
+			}
+
+			err := login("leo@fender.com", password, "203.0.113.7")
+			utils.AssertErrorsEqual(t, utils.LoginSourceBlockedError(time.Hour), err)
+
+			utils.AssertErrorsEqual(t, nil, login("leo@fender.com", password, "192.0.2.1"))
+		})
+
+		t.Run("blocks a password sprayed across accounts", func(t *testing.T) {
+			for i, ip := range []string{"198.51.100.1", "198.51.101.1", "198.51.102.1"} {
+				login(fmt.Sprintf("spray%d@fender.com", i), "Summer2021!", ip)
+			}
+
+			err := login("leo@fender.com", "Summer2021!", "192.0.2.1")
+			utils.AssertErrorsEqual(t, utils.LoginSourceBlockedError(time.Hour), err)
+
+			utils.AssertErrorsEqual(t, nil, login("leo@fender.com", password, "192.0.2.1"))
+		})
+
+		t.Run("lists the active blocks", func(t *testing.T) {
+			res, err := ListLoginBlocks()
+			utils.AssertErrorsEqual(t, nil, err)
+
+			var kinds []string
+			for _, block := range res.Blocks {
+				kinds = append(kinds, block.Kind)
+			}
+
+			if diff := cmp.Diff([]string{loginBlockPassword, loginBlockIP}, kinds); diff != "" {
+				t.Errorf("\nUnexpected blocks (-want, +got)\n%s", diff)
+			}
+		})
+	})
+}
+
+func Test_Reauthenticate_loginBlocks(t *testing.T) {
+	databaseTest(t, func(database *gorm.DB) {
+		clearDatabase(database)
+		password := "SkunkStripeMapleNeckRosewoodFingerboard"
+		hash, _ := HashPassword(password)
+		user := User{ID: "0d1c2b3a-4e5f-4a6b-8c7d-9e0f1a2b3c4d", Name: "Leo Fender", Email: "leo@fender.com", Password: hash}
+		database.Save(&user)
+
+		token, err := issueAccessToken(database, user)
+		utils.AssertErrorsEqual(t, nil, err)
+
+		reauthenticate := func(password string, ip string) error {
+			_, err := Reauthenticate(ReauthenticateRequest{
+				AuthHeader: "Bearer " + token.AccessToken,
+				Password:   password,
+				Meta:       RequestMeta{IP: ip},
+			})
+			return err
+		}
+
+		t.Run("counts a wrong password against its source", func(t *testing.T) {
+			utils.AssertErrorsEqual(t, utils.UnauthorizedError(), reauthenticate("guess", "192.0.2.1"))
+
+			var failed int64
+			database.Model(&FailedLogin{}).Where("ip = ?", "192.0.2.1").Count(&failed)
+			if failed != 1 {
+				t.Errorf("expected the wrong password to be recorded, got %d failed logins", failed)
+			}
+		})
+
+		t.Run("refuses a blocked source before checking the password", func(t *testing.T) {
+			database.Create(&LoginBlock{Kind: loginBlockIP, Value: "203.0.113.7", Reason: "test", ExpiresAt: time.Now().Add(time.Hour)})
+
+			utils.AssertErrorsEqual(t, utils.LoginSourceBlockedError(time.Hour), reauthenticate(password, "203.0.113.7"))
+		})
+	})
+}
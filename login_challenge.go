@@ -0,0 +1,196 @@
+package platform_exercise
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"time"
+
+	"github.com/campallison/platform-exercise/utils"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultLoginChallengeExpiry = 10 * time.Minute
+	maxLoginChallengeAttempts   = 5
+	loginChallengeCodeDigits    = 6
+
+	// maxFailedLoginCodes wrong codes within failedLoginCodeWindow, across
+	// all of a user's challenges, stop further challenges for the user.
+	maxFailedLoginCodes   = 10
+	failedLoginCodeWindow = 24 * time.Hour
+)
+
+// Login methods recorded in the login history.
+const (
+	loginMethodPassword  = "password"
+	loginMethodEmailCode = "email_code"
+)
+
+// loginChallengeMethods are the ways a client can answer a login challenge.
+var loginChallengeMethods = []string{"email_code"}
+
+func loginChallengeExpiry() time.Duration {
+	expiry, err := time.ParseDuration(os.Getenv("loginChallengeExpiry"))
+	if err != nil || expiry <= 0 {
+		return defaultLoginChallengeExpiry
+	}
+	return expiry
+}
+
+// loginChallengeCodeHash keys the hash with the signing secret, so the few
+// possible codes cannot be tried against a leaked row.
+func loginChallengeCodeHash(code string) string {
+	mac := hmac.New(sha256.New, []byte(os.Getenv("SigningSecret")))
+	mac.Write([]byte(code))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func newLoginChallengeCode() (string, error) {
+	max := big.NewInt(1)
+	for i := 0; i < loginChallengeCodeDigits; i++ {
+		max.Mul(max, big.NewInt(10))
+	}
+
+	n, err := rand.Int(rand.Reader, max)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%0*d", loginChallengeCodeDigits, n), nil
+}
+
+// loginCodesExhausted reports whether the user has entered too many wrong
+// codes lately to be challenged again. It fails closed.
+func loginCodesExhausted(db *gorm.DB, userID string, now time.Time) bool {
+	var failed int64
+	if err := db.Model(&LoginAttempt{}).
+		Where("user_id = ? AND NOT succeeded AND method = ? AND created_at > ?", userID, loginMethodEmailCode, now.Add(-failedLoginCodeWindow)).
+		Count(&failed).Error; err != nil {
+		return true
+	}
+
+	return failed >= maxFailedLoginCodes
+}
+
+// startLoginChallenge holds back a risky login and emails the user a code to
+// complete it with. While the user has a live challenge with guesses left,
+// it is answered again instead, so logging in again never buys fresh guesses
+// or a new code; otherwise any earlier challenge is replaced.
+func startLoginChallenge(db *gorm.DB, user User, attempt LoginAttempt) error {
+	var live LoginChallenge
+	err := db.Where("user_id = ? AND expires_at > ? AND attempts < ?", user.ID, attempt.CreatedAt, maxLoginChallengeAttempts).
+		First(&live).Error
+	if err == nil {
+		return utils.LoginChallengeRequiredError(live.ID, loginChallengeMethods, live.ExpiresAt)
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return utils.LoginFailedError()
+	}
+
+	code, err := newLoginChallengeCode()
+	if err != nil {
+		return utils.LoginFailedError()
+	}
+
+	challenge := LoginChallenge{
+		CreatedAt:   attempt.CreatedAt,
+		UserID:      user.ID,
+		CodeHash:    loginChallengeCodeHash(code),
+		Country:     attempt.Country,
+		RiskScore:   attempt.RiskScore,
+		RiskSignals: attempt.RiskSignals,
+		ExpiresAt:   attempt.CreatedAt.Add(loginChallengeExpiry()),
+	}
+
+	if err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", user.ID).Delete(&LoginChallenge{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(&challenge).Error
+	}); err != nil {
+		return utils.LoginFailedError()
+	}
+
+	sendMail(utils.Mail{
+		To:      user.Email,
+		Subject: "Your sign-in code",
+		Body: fmt.Sprintf(
+			"We need to check it's you signing in from %s.\n\nDevice: %s\nIP address: %s\n\nYour code is %s. It expires at %s.\n\nIf this wasn't you, don't share the code and change your password now.",
+			describeCountry(attempt.Country), utils.DescribeDevice(attempt.UserAgent), attempt.IP,
+			code, challenge.ExpiresAt.Format(time.RFC1123),
+		),
+	})
+
+	return utils.LoginChallengeRequiredError(challenge.ID, loginChallengeMethods, challenge.ExpiresAt)
+}
+
+// VerifyLogin completes a login held back by startLoginChallenge. Each guess
+// uses up one of the challenge's attempts before the code is checked. A wrong
+// code counts against its source like a wrong password, and is recorded in
+// the user's login history towards maxFailedLoginCodes.
+func VerifyLogin(req VerifyLoginRequest) (LoginResponse, error) {
+	db := Init()
+
+	var challenge LoginChallenge
+	if err := db.Where("id = ? AND expires_at > ?", req.ChallengeID, time.Now()).First(&challenge).Error; err != nil {
+		return LoginResponse{}, utils.LoginChallengeFailedError()
+	}
+
+	var user User
+	if err := db.Where("id = ?", challenge.UserID).First(&user).Error; err != nil {
+		return LoginResponse{}, utils.LoginChallengeFailedError()
+	}
+
+	source := newLoginSource(user.Email, req.Code, req.Meta)
+	if err := checkLoginBlock(db, source, user.ID, req.Meta); err != nil {
+		return LoginResponse{}, err
+	}
+
+	if loginCodesExhausted(db, user.ID, time.Now()) {
+		return LoginResponse{}, utils.LoginChallengeFailedError()
+	}
+
+	result := db.Model(&LoginChallenge{}).
+		Where("id = ? AND attempts < ?", challenge.ID, maxLoginChallengeAttempts).
+		UpdateColumn("attempts", gorm.Expr("attempts + 1"))
+	if result.Error != nil || result.RowsAffected == 0 {
+		return LoginResponse{}, utils.LoginChallengeFailedError()
+	}
+
+	if !hmac.Equal([]byte(loginChallengeCodeHash(req.Code)), []byte(challenge.CodeHash)) {
+		recordAudit(auditLoginFailed, challenge.UserID, req.Meta)
+		recordFailedLogin(db, source, req.Meta)
+		failed := newLoginAttempt(user.ID, false, req.Meta)
+		failed.Method = loginMethodEmailCode
+		recordLogin(user, failed, false)
+		return LoginResponse{}, utils.LoginChallengeFailedError()
+	}
+
+	if err := db.Delete(&challenge).Error; err != nil {
+		return LoginResponse{}, utils.LoginFailedError()
+	}
+
+	if err := checkUserIPRules(db, user, req.Meta); err != nil {
+		return LoginResponse{}, utils.LoginChallengeFailedError()
+	}
+
+	response, err := issueAccessToken(db, user)
+	if err != nil {
+		return LoginResponse{}, err
+	}
+
+	attempt := newLoginAttempt(user.ID, true, req.Meta)
+	attempt.Method = loginMethodEmailCode
+	attempt.RiskScore = challenge.RiskScore
+	attempt.RiskSignals = challenge.RiskSignals
+	req.Meta.ActorID = user.ID
+	recordAudit(auditLogin, user.ID, req.Meta)
+	recordLogin(user, attempt, true)
+
+	return response, nil
+}
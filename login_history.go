@@ -0,0 +1,150 @@
+package platform_exercise
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/campallison/platform-exercise/utils"
+)
+
+const (
+	defaultLoginHistoryLimit = 50
+	maxLoginHistoryLimit     = 200
+)
+
+// newLoginAttempt describes a login from meta, placing the caller's IP when a
+// geo-IP database is configured.
+func newLoginAttempt(userID string, succeeded bool, meta RequestMeta) LoginAttempt {
+	attempt := LoginAttempt{
+		CreatedAt:         time.Now().UTC(),
+		UserID:            userID,
+		Succeeded:         succeeded,
+		Method:            loginMethodPassword,
+		IP:                meta.IP,
+		UserAgent:         meta.UserAgent,
+		DeviceFingerprint: utils.DeviceFingerprint(meta.UserAgent),
+	}
+
+	if location, ok := locateIP(meta.IP); ok {
+		attempt.Country = location.Country
+		if location.HasCoordinates {
+			attempt.Latitude = &location.Latitude
+			attempt.Longitude = &location.Longitude
+		}
+	}
+
+	return attempt
+}
+
+// recordLogin adds a login attempt to the user's history and, when a
+// successful login comes from a device the user has not signed in from
+// before, emails them about it. A user's very first login is not news, and
+// neither is one the user was already notified about by the risk policy. Like
+// recordAudit it is best effort.
+func recordLogin(user User, attempt LoginAttempt, notified bool) {
+	db := Init()
+
+	var known, previous int64
+	if attempt.Succeeded && !notified {
+		db.Model(&LoginAttempt{}).Where("user_id = ? AND succeeded", user.ID).Count(&previous)
+		db.Model(&LoginAttempt{}).
+			Where("user_id = ? AND succeeded AND device_fingerprint = ?", user.ID, attempt.DeviceFingerprint).
+			Count(&known)
+	}
+
+	if err := db.Create(&attempt).Error; err != nil {
+		log.Printf("\nCould not record login for user %s\n%v\n", user.ID, err)
+		return
+	}
+
+	if previous > 0 && known == 0 {
+		sendNewDeviceMail(user, attempt)
+	}
+}
+
+func sendNewDeviceMail(user User, attempt LoginAttempt) {
+	sendMail(utils.Mail{
+		To:      user.Email,
+		Subject: "New sign-in to your account",
+		Body: fmt.Sprintf(
+			"Your account was signed in to from a device you haven't used before.\n\nDevice: %s\nIP address: %s\nTime: %s\n\nIf this was you, there's nothing to do. If not, change your password now.",
+			utils.DescribeDevice(attempt.UserAgent), attempt.IP, attempt.CreatedAt.Format(time.RFC1123),
+		),
+	})
+}
+
+// parseLoginHistoryRequest reads the query string of GET /user/{id}/login-history.
+func parseLoginHistoryRequest(id string, params map[string]string) (LoginHistoryRequest, error) {
+	req := LoginHistoryRequest{ID: id, Cursor: params["cursor"], Limit: defaultLoginHistoryLimit}
+
+	if value := params["limit"]; value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit <= 0 || limit > maxLoginHistoryLimit {
+			return req, utils.InvalidQueryParameterError("limit", value)
+		}
+		req.Limit = limit
+	}
+
+	if value := params["cursor"]; value != "" {
+		if _, err := strconv.ParseInt(value, 10, 64); err != nil {
+			return req, utils.InvalidQueryParameterError("cursor", value)
+		}
+	}
+
+	return req, nil
+}
+
+// GetLoginHistory returns a user's login attempts newest first. The cursor is
+// the ID of the last attempt on the previous page.
+func GetLoginHistory(req LoginHistoryRequest) (LoginHistoryResponse, error) {
+	db := Init()
+
+	if err := db.Where("id = ?", req.ID).First(&User{}).Error; err != nil {
+		return LoginHistoryResponse{}, utils.UserNotFoundError(req.ID)
+	}
+
+	limit := req.Limit
+	if limit <= 0 || limit > maxLoginHistoryLimit {
+		limit = defaultLoginHistoryLimit
+	}
+
+	query := db.Where("user_id = ?", req.ID)
+	if req.Cursor != "" {
+		cursor, err := strconv.ParseInt(req.Cursor, 10, 64)
+		if err != nil {
+			return LoginHistoryResponse{}, utils.InvalidQueryParameterError("cursor", req.Cursor)
+		}
+		query = query.Where("id < ?", cursor)
+	}
+
+	var attempts []LoginAttempt
+	if err := query.Order("id DESC").Limit(limit + 1).Find(&attempts).Error; err != nil {
+		return LoginHistoryResponse{}, utils.LoginHistoryError(req.ID)
+	}
+
+	response := LoginHistoryResponse{Logins: []LoginHistoryEntry{}}
+	if len(attempts) > limit {
+		attempts = attempts[:limit]
+		response.NextCursor = strconv.FormatInt(attempts[len(attempts)-1].ID, 10)
+	}
+
+	for _, attempt := range attempts {
+		response.Logins = append(response.Logins, loginHistoryEntry(attempt))
+	}
+
+	return response, nil
+}
+
+func loginHistoryEntry(attempt LoginAttempt) LoginHistoryEntry {
+	entry := LoginHistoryEntry{
+		LoginAttempt: attempt,
+		Device:       utils.DescribeDevice(attempt.UserAgent),
+	}
+	if attempt.RiskSignals != "" {
+		entry.RiskSignals = strings.Split(attempt.RiskSignals, ",")
+	}
+	return entry
+}
@@ -0,0 +1,128 @@
+package platform_exercise
+
+import (
+	"testing"
+	"time"
+
+	"github.com/campallison/platform-exercise/utils"
+	"github.com/google/go-cmp/cmp"
+	"gorm.io/gorm"
+)
+
+func Test_parseLoginHistoryRequest(t *testing.T) {
+	id := "0d1c2b3a-4e5f-4a6b-8c7d-9e0f1a2b3c4d"
+
+	cases := []struct {
+		name     string
+		params   map[string]string
+		expected LoginHistoryRequest
+		err      error
+	}{
+		{
+			name:     "defaults",
+			params:   map[string]string{},
+			expected: LoginHistoryRequest{ID: id, Limit: defaultLoginHistoryLimit},
+		},
+		{
+			name:     "limit and cursor",
+			params:   map[string]string{"limit": "10", "cursor": "42"},
+			expected: LoginHistoryRequest{ID: id, Limit: 10, Cursor: "42"},
+		},
+		{
+			name:   "malformed cursor",
+			params: map[string]string{"cursor": "abc"},
+			err:    utils.InvalidQueryParameterError("cursor", "abc"),
+		},
+		{
+			name:   "limit over the maximum",
+			params: map[string]string{"limit": "1000"},
+			err:    utils.InvalidQueryParameterError("limit", "1000"),
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			res, err := parseLoginHistoryRequest(id, c.params)
+			utils.AssertErrorsEqual(t, c.err, err)
+
+			if c.err == nil {
+				if diff := cmp.Diff(c.expected, res); diff != "" {
+					t.Errorf("\nUnexpected request (-want, +got)\n%s", diff)
+				}
+			}
+		})
+	}
+}
+
+func Test_GetLoginHistory(t *testing.T) {
+	databaseTest(t, func(database *gorm.DB) {
+		clearDatabase(database)
+		id := "0d1c2b3a-4e5f-4a6b-8c7d-9e0f1a2b3c4d"
+		password := "SkunkStripeMapleNeckRosewoodFingerboard"
+		hash, _ := HashPassword(password)
+		database.Save(&User{ID: id, Name: "Leo Fender", Email: "leo@fender.com", Password: hash, CreatedAt: time.Now().Add(-48 * time.Hour)})
+
+		mailbox := withTestMailer(t)
+
+		laptop := RequestMeta{IP: "203.0.113.7", UserAgent: "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/87.0.4280.88 Safari/537.36"}
+		phone := RequestMeta{IP: "198.51.100.4", UserAgent: "Mozilla/5.0 (iPhone; CPU iPhone OS 14_3 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/14.0.2 Mobile/15E148 Safari/604.1"}
+		login := func(password string, meta RequestMeta) {
+			Login(LoginRequest{Credential: Credential{Email: "leo@fender.com", Password: password}, Meta: meta})
+		}
+
+		t.Run("does not mail about the first login", func(t *testing.T) {
+			login(password, laptop)
+			login(password, laptop)
+
+			if len(mailbox.Sent) != 0 {
+				t.Errorf("expected no mail, got %+v", mailbox.Sent)
+			}
+		})
+
+		t.Run("does not mail about failed logins", func(t *testing.T) {
+			login("wrong", phone)
+
+			if len(mailbox.Sent) != 0 {
+				t.Errorf("expected no mail, got %+v", mailbox.Sent)
+			}
+		})
+
+		t.Run("mails about a login from a new device", func(t *testing.T) {
+			login(password, phone)
+			login(password, phone)
+
+			if len(mailbox.Sent) != 1 || mailbox.Sent[0].To != "leo@fender.com" {
+				t.Errorf("expected one mail to the user, got %+v", mailbox.Sent)
+			}
+		})
+
+		t.Run("lists logins newest first", func(t *testing.T) {
+			res, err := GetLoginHistory(LoginHistoryRequest{ID: id, Limit: 4})
+			utils.AssertErrorsEqual(t, nil, err)
+
+			var got []string
+			for _, entry := range res.Logins {
+				if entry.Succeeded {
+					got = append(got, entry.Device)
+				} else {
+					got = append(got, "failed on "+entry.Device)
+				}
+			}
+
+			expected := []string{"Safari on iOS", "Safari on iOS", "failed on Safari on iOS", "Chrome on macOS"}
+			if diff := cmp.Diff(expected, got); diff != "" {
+				t.Errorf("\nUnexpected logins (-want, +got)\n%s", diff)
+			}
+
+			if res.NextCursor == "" {
+				t.Error("expected a cursor to the last login")
+			}
+		})
+
+		t.Run("returns an error if the user does not exist", func(t *testing.T) {
+			missing := "00000000-0000-4000-8000-000000000001"
+			_, err := GetLoginHistory(LoginHistoryRequest{ID: missing})
+			utils.AssertErrorsEqual(t, utils.UserNotFoundError(missing), err)
+		})
+	})
+}
@@ -0,0 +1,478 @@
+package platform_exercise
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/campallison/platform-exercise/utils"
+	"gorm.io/gorm"
+)
+
+const (
+	riskNewCountry       = "new_country"
+	riskImpossibleTravel = "impossible_travel"
+	riskNewDevice        = "new_device"
+	riskFailedAttempts   = "failed_attempts"
+	riskIPReputation     = "ip_reputation"
+	riskTorExit          = "tor_exit"
+	riskNewAccount       = "new_account"
+)
+
+const (
+	riskDecisionAllow     = "allow"
+	riskDecisionChallenge = "challenge"
+	riskDecisionDeny      = "deny"
+)
+
+const (
+	defaultMaxTravelSpeedKmh = 1000
+
+	// Geo-IP places addresses only roughly, so short hops are never
+	// impossible however quickly they happen.
+	minImpossibleTravelKm = 500
+
+	riskFailedAttemptWindow = 15 * time.Minute
+	riskFailedAttemptLimit  = 5
+	riskNewAccountAge       = 24 * time.Hour
+
+	defaultRiskNotifyThreshold    = 20
+	defaultRiskChallengeThreshold = 50
+	defaultRiskDenyThreshold      = 80
+
+	defaultRiskListsReloadInterval = 15 * time.Minute
+)
+
+// defaultRiskWeights are chosen so that a new country alone is worth telling
+// the user about, impossible travel alone needs a second factor, and either
+// from a Tor exit node or a listed IP is denied.
+func defaultRiskWeights() map[string]int {
+	return map[string]int{
+		riskNewCountry:       25,
+		riskImpossibleTravel: 50,
+		riskNewDevice:        10,
+		riskFailedAttempts:   30,
+		riskIPReputation:     40,
+		riskTorExit:          40,
+		riskNewAccount:       10,
+	}
+}
+
+// riskSignal is one reason a login looks risky. The reason is written for
+// the email to the user and never holds the IP. It names countries and
+// devices, so it is left out of the audit log, whose details erasure cannot
+// clear.
+type riskSignal struct {
+	Name   string `json:"name"`
+	Weight int    `json:"weight"`
+	Reason string `json:"-"`
+}
+
+type riskAssessment struct {
+	Score    int          `json:"score"`
+	Decision string       `json:"decision"`
+	Notify   bool         `json:"notify"`
+	Signals  []riskSignal `json:"signals"`
+}
+
+func (a riskAssessment) signalNames() string {
+	names := make([]string, 0, len(a.Signals))
+	for _, signal := range a.Signals {
+		names = append(names, signal.Name)
+	}
+	return strings.Join(names, ",")
+}
+
+type riskConfig struct {
+	Weights            map[string]int
+	NotifyThreshold    int
+	ChallengeThreshold int
+	DenyThreshold      int
+}
+
+// loginRiskContext is what a risk check can look at: the user, the login
+// being assessed, and the database for their history.
+type loginRiskContext struct {
+	db      *gorm.DB
+	user    User
+	attempt LoginAttempt
+}
+
+// A riskCheck looks at one aspect of a login and reports the signals it
+// raises, leaving their weight to the config. New checks are added to
+// riskChecks.
+type riskCheck func(login loginRiskContext) ([]riskSignal, error)
+
+var riskChecks = []riskCheck{
+	checkLocation,
+	checkNewDevice,
+	checkFailedAttempts,
+	checkIPLists,
+	checkAccountAge,
+}
+
+// assessLogin runs every check and scores the signals. A check that fails is
+// logged and skipped, so an outage of one source never locks users out.
+func assessLogin(login loginRiskContext, checks []riskCheck, config riskConfig) riskAssessment {
+	var signals []riskSignal
+	for _, check := range checks {
+		raised, err := check(login)
+		if err != nil {
+			log.Printf("\nRisk check failed for user %s, skipping it\n%v\n", login.user.ID, err)
+			continue
+		}
+		signals = append(signals, raised...)
+	}
+
+	return scoreRisk(signals, config)
+}
+
+// scoreRisk adds up the weights of the signals and maps the score onto a
+// decision. Signals weighted zero are dropped.
+func scoreRisk(signals []riskSignal, config riskConfig) riskAssessment {
+	assessment := riskAssessment{Decision: riskDecisionAllow, Signals: []riskSignal{}}
+
+	for _, signal := range signals {
+		signal.Weight = config.Weights[signal.Name]
+		if signal.Weight <= 0 {
+			continue
+		}
+		assessment.Score += signal.Weight
+		assessment.Signals = append(assessment.Signals, signal)
+	}
+
+	switch {
+	case assessment.Score >= config.DenyThreshold:
+		assessment.Decision = riskDecisionDeny
+	case assessment.Score >= config.ChallengeThreshold:
+		assessment.Decision = riskDecisionChallenge
+	}
+	assessment.Notify = assessment.Score >= config.NotifyThreshold
+
+	return assessment
+}
+
+// parseRiskWeights reads per-signal overrides written as
+// "tor_exit=80,new_device=0" on top of the defaults.
+func parseRiskWeights(config string) (map[string]int, error) {
+	weights := defaultRiskWeights()
+
+	for _, entry := range strings.Split(config, ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("risk weight %q is not of the form signal=weight", entry)
+		}
+
+		signal := strings.TrimSpace(parts[0])
+		if _, ok := weights[signal]; !ok {
+			return nil, fmt.Errorf("unknown risk signal %q", signal)
+		}
+
+		weight, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+		if err != nil || weight < 0 {
+			return nil, fmt.Errorf("invalid risk weight %q for %s", parts[1], signal)
+		}
+
+		weights[signal] = weight
+	}
+
+	return weights, nil
+}
+
+func loadRiskConfig() riskConfig {
+	weights, err := parseRiskWeights(os.Getenv("riskWeights"))
+	if err != nil {
+		log.Printf("\nCould not parse riskWeights, using defaults\n%v\n", err)
+		weights = defaultRiskWeights()
+	}
+
+	return riskConfig{
+		Weights:            weights,
+		NotifyThreshold:    positiveIntFromEnv("riskNotifyThreshold", defaultRiskNotifyThreshold),
+		ChallengeThreshold: positiveIntFromEnv("riskChallengeThreshold", defaultRiskChallengeThreshold),
+		DenyThreshold:      positiveIntFromEnv("riskDenyThreshold", defaultRiskDenyThreshold),
+	}
+}
+
+var geoIP struct {
+	sync.Once
+	db *utils.GeoDB
+}
+
+func loadGeoIP() {
+	path := os.Getenv("geoIPDatabaseFile")
+	if path == "" {
+		return
+	}
+
+	db, err := utils.OpenGeoDB(path)
+	if err != nil {
+		log.Printf("\nCould not load geoIPDatabaseFile, logins will not be geolocated\n%v\n", err)
+		return
+	}
+	geoIP.db = db
+}
+
+// locateIP places an IP with the geo-IP database, reporting false when none
+// is configured or it has no entry for the IP.
+func locateIP(ip string) (utils.GeoLocation, bool) {
+	geoIP.Do(loadGeoIP)
+
+	address := net.ParseIP(ip)
+	if geoIP.db == nil || address == nil {
+		return utils.GeoLocation{}, false
+	}
+
+	location, ok, err := geoIP.db.Lookup(address)
+	if err != nil {
+		log.Printf("\nCould not geolocate %s\n%v\n", ip, err)
+		return utils.GeoLocation{}, false
+	}
+	return location, ok
+}
+
+func maxTravelSpeedKmh() float64 {
+	speed, err := strconv.ParseFloat(os.Getenv("maxTravelSpeedKmh"), 64)
+	if err != nil || speed <= 0 {
+		return defaultMaxTravelSpeedKmh
+	}
+	return speed
+}
+
+// assessLocationRisk compares a login with the user's earlier successful
+// ones: the last that could be placed on a map, and the countries they have
+// signed in from. A user with no located history raises no signals.
+func assessLocationRisk(attempt LoginAttempt, last *LoginAttempt, countries []string, maxSpeedKmh float64) []riskSignal {
+	var signals []riskSignal
+
+	if attempt.Country != "" && len(countries) > 0 {
+		known := false
+		for _, country := range countries {
+			if country == attempt.Country {
+				known = true
+				break
+			}
+		}
+		if !known {
+			signals = append(signals, riskSignal{
+				Name:   riskNewCountry,
+				Reason: fmt.Sprintf("first login from %s", attempt.Country),
+			})
+		}
+	}
+
+	if last != nil && last.Latitude != nil && last.Longitude != nil &&
+		attempt.Latitude != nil && attempt.Longitude != nil {
+		distance := utils.DistanceKm(*last.Latitude, *last.Longitude, *attempt.Latitude, *attempt.Longitude)
+		elapsed := attempt.CreatedAt.Sub(last.CreatedAt)
+		if distance > minImpossibleTravelKm && (elapsed <= 0 || distance/elapsed.Hours() > maxSpeedKmh) {
+			signals = append(signals, riskSignal{
+				Name: riskImpossibleTravel,
+				Reason: fmt.Sprintf(
+					"%.0f km from the previous login %s earlier, faster than %.0f km/h",
+					distance, elapsed.Round(time.Minute), maxSpeedKmh,
+				),
+			})
+		}
+	}
+
+	return signals
+}
+
+func checkLocation(login loginRiskContext) ([]riskSignal, error) {
+	attempt := login.attempt
+	if attempt.Country == "" && attempt.Latitude == nil {
+		return nil, nil
+	}
+
+	var last []LoginAttempt
+	if err := login.db.Where("user_id = ? AND succeeded AND latitude IS NOT NULL", attempt.UserID).
+		Order("id DESC").Limit(1).Find(&last).Error; err != nil {
+		return nil, err
+	}
+
+	var countries []string
+	if err := login.db.Model(&LoginAttempt{}).
+		Where("user_id = ? AND succeeded AND country <> ''", attempt.UserID).
+		Distinct().Pluck("country", &countries).Error; err != nil {
+		return nil, err
+	}
+
+	var lastLocated *LoginAttempt
+	if len(last) > 0 {
+		lastLocated = &last[0]
+	}
+
+	return assessLocationRisk(attempt, lastLocated, countries, maxTravelSpeedKmh()), nil
+}
+
+func checkNewDevice(login loginRiskContext) ([]riskSignal, error) {
+	var previous, known int64
+	if err := login.db.Model(&LoginAttempt{}).
+		Where("user_id = ? AND succeeded", login.user.ID).Count(&previous).Error; err != nil {
+		return nil, err
+	}
+
+	if err := login.db.Model(&LoginAttempt{}).
+		Where("user_id = ? AND succeeded AND device_fingerprint = ?", login.user.ID, login.attempt.DeviceFingerprint).
+		Count(&known).Error; err != nil {
+		return nil, err
+	}
+
+	if previous == 0 || known > 0 {
+		return nil, nil
+	}
+
+	return []riskSignal{{
+		Name:   riskNewDevice,
+		Reason: fmt.Sprintf("first login from %s", utils.DescribeDevice(login.attempt.UserAgent)),
+	}}, nil
+}
+
+func checkFailedAttempts(login loginRiskContext) ([]riskSignal, error) {
+	var failed int64
+	if err := login.db.Model(&LoginAttempt{}).
+		Where("user_id = ? AND NOT succeeded AND created_at > ?", login.user.ID, login.attempt.CreatedAt.Add(-riskFailedAttemptWindow)).
+		Count(&failed).Error; err != nil {
+		return nil, err
+	}
+
+	if failed < riskFailedAttemptLimit {
+		return nil, nil
+	}
+
+	return []riskSignal{{
+		Name:   riskFailedAttempts,
+		Reason: fmt.Sprintf("%d failed logins in the %s before", failed, riskFailedAttemptWindow),
+	}}, nil
+}
+
+func checkAccountAge(login loginRiskContext) ([]riskSignal, error) {
+	age := login.attempt.CreatedAt.Sub(login.user.CreatedAt)
+	if login.user.CreatedAt.IsZero() || age >= riskNewAccountAge {
+		return nil, nil
+	}
+
+	return []riskSignal{{
+		Name:   riskNewAccount,
+		Reason: fmt.Sprintf("account created %s before", age.Round(time.Minute)),
+	}}, nil
+}
+
+var riskLists struct {
+	sync.Mutex
+	loadedAt   time.Time
+	reputation *utils.IPSet
+	torExits   *utils.IPSet
+}
+
+// refreshRiskLists reloads the IP lists at most once per reload interval, so
+// updated feeds reach warm Lambdas without a redeploy. A failed reload keeps
+// the previous lists.
+func refreshRiskLists() (*utils.IPSet, *utils.IPSet) {
+	riskLists.Lock()
+	defer riskLists.Unlock()
+
+	interval, err := time.ParseDuration(os.Getenv("riskListsReloadInterval"))
+	if err != nil || interval <= 0 {
+		interval = defaultRiskListsReloadInterval
+	}
+
+	if !riskLists.loadedAt.IsZero() && time.Since(riskLists.loadedAt) < interval {
+		return riskLists.reputation, riskLists.torExits
+	}
+	riskLists.loadedAt = time.Now()
+
+	reputation := utils.NewIPSet()
+	for _, path := range strings.Split(os.Getenv("ipReputationFiles"), ",") {
+		if path = strings.TrimSpace(path); path == "" {
+			continue
+		}
+
+		list, err := utils.LoadIPListFile(path)
+		if err != nil {
+			log.Printf("\nCould not load IP reputation list %s, keeping previous lists\n%v\n", path, err)
+			return riskLists.reputation, riskLists.torExits
+		}
+		reputation.Merge(list)
+	}
+
+	torExits := utils.NewIPSet()
+	if path := os.Getenv("torExitNodesFile"); path != "" {
+		list, err := utils.LoadIPListFile(path)
+		if err != nil {
+			log.Printf("\nCould not load torExitNodesFile, keeping previous lists\n%v\n", err)
+			return riskLists.reputation, riskLists.torExits
+		}
+		torExits = list
+	}
+
+	riskLists.reputation, riskLists.torExits = reputation, torExits
+	return reputation, torExits
+}
+
+func expireRiskLists() {
+	riskLists.Lock()
+	riskLists.loadedAt = time.Time{}
+	riskLists.Unlock()
+}
+
+func checkIPLists(login loginRiskContext) ([]riskSignal, error) {
+	ip := net.ParseIP(login.attempt.IP)
+	if ip == nil {
+		return nil, nil
+	}
+
+	reputation, torExits := refreshRiskLists()
+
+	var signals []riskSignal
+	if reputation.Contains(ip) {
+		signals = append(signals, riskSignal{Name: riskIPReputation, Reason: "login from an address on an IP reputation list"})
+	}
+
+	if torExits.Contains(ip) {
+		signals = append(signals, riskSignal{Name: riskTorExit, Reason: "login from a Tor exit node"})
+	}
+
+	return signals, nil
+}
+
+func describeCountry(country string) string {
+	if country == "" {
+		return "an unknown location"
+	}
+	return country
+}
+
+// sendLoginRiskMail tells the user about a login the risk engine flagged,
+// whether it went through or was denied.
+func sendLoginRiskMail(user User, attempt LoginAttempt, assessment riskAssessment) {
+	subject, outcome := "Unusual sign-in to your account", "The sign-in was allowed."
+	if assessment.Decision == riskDecisionDeny {
+		subject, outcome = "Blocked sign-in to your account", "The sign-in was blocked, but the correct password was used."
+	}
+
+	reasons := make([]string, 0, len(assessment.Signals))
+	for _, signal := range assessment.Signals {
+		reasons = append(reasons, "- "+signal.Reason)
+	}
+
+	sendMail(utils.Mail{
+		To:      user.Email,
+		Subject: subject,
+		Body: fmt.Sprintf(
+			"We noticed a sign-in to your account that looked unusual:\n%s\n\nLocation: %s\nDevice: %s\nIP address: %s\nTime: %s\n\n%s If this wasn't you, change your password now.",
+			strings.Join(reasons, "\n"), describeCountry(attempt.Country), utils.DescribeDevice(attempt.UserAgent),
+			attempt.IP, attempt.CreatedAt.Format(time.RFC1123), outcome,
+		),
+	})
+}
@@ -0,0 +1,316 @@
+package platform_exercise
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/campallison/platform-exercise/utils"
+	"github.com/google/go-cmp/cmp"
+	"gorm.io/gorm"
+)
+
+func Test_assessLocationRisk(t *testing.T) {
+	at := func(minutes int, country string, latitude, longitude float64) LoginAttempt {
+		return LoginAttempt{
+			CreatedAt: time.Date(2021, 1, 1, 12, minutes, 0, 0, time.UTC),
+			Country:   country,
+			Latitude:  &latitude,
+			Longitude: &longitude,
+		}
+	}
+	losAngeles := at(0, "US", 34.05, -118.24)
+
+	cases := []struct {
+		name      string
+		attempt   LoginAttempt
+		last      *LoginAttempt
+		countries []string
+		expected  []string
+	}{
+		{
+			name:    "first located login",
+			attempt: at(30, "US", 34.05, -118.24),
+		},
+		{
+			name:      "nearby login soon after",
+			attempt:   at(30, "US", 34.42, -119.70),
+			last:      &losAngeles,
+			countries: []string{"US"},
+		},
+		{
+			name:      "London an hour after Los Angeles",
+			attempt:   at(60, "GB", 51.51, -0.13),
+			last:      &losAngeles,
+			countries: []string{"US"},
+			expected:  []string{riskNewCountry, riskImpossibleTravel},
+		},
+		{
+			name:      "New York an hour after Los Angeles",
+			attempt:   at(60, "US", 40.71, -74.01),
+			last:      &losAngeles,
+			countries: []string{"US"},
+			expected:  []string{riskImpossibleTravel},
+		},
+		{
+			name:      "new country without coordinates",
+			attempt:   LoginAttempt{Country: "JP"},
+			last:      &losAngeles,
+			countries: []string{"US", "GB"},
+			expected:  []string{riskNewCountry},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var res []string
+			for _, signal := range assessLocationRisk(c.attempt, c.last, c.countries, defaultMaxTravelSpeedKmh) {
+				res = append(res, signal.Name)
+			}
+
+			if diff := cmp.Diff(c.expected, res); diff != "" {
+				t.Errorf("\nUnexpected signals (-want, +got)\n%s", diff)
+			}
+		})
+	}
+}
+
+func Test_parseRiskWeights(t *testing.T) {
+	weights, err := parseRiskWeights("tor_exit=80, new_device=0")
+	utils.AssertErrorsEqual(t, nil, err)
+
+	expected := defaultRiskWeights()
+	expected[riskTorExit] = 80
+	expected[riskNewDevice] = 0
+	if diff := cmp.Diff(expected, weights); diff != "" {
+		t.Errorf("\nUnexpected weights (-want, +got)\n%s", diff)
+	}
+
+	for _, config := range []string{"tor_exit", "vpn=10", "new_device=-5", "new_device=lots"} {
+		if _, err := parseRiskWeights(config); err == nil {
+			t.Errorf("expected %q to be rejected", config)
+		}
+	}
+}
+
+func Test_scoreRisk(t *testing.T) {
+	config := riskConfig{
+		Weights:            defaultRiskWeights(),
+		NotifyThreshold:    defaultRiskNotifyThreshold,
+		ChallengeThreshold: defaultRiskChallengeThreshold,
+		DenyThreshold:      defaultRiskDenyThreshold,
+	}
+	config.Weights[riskNewDevice] = 0
+
+	signal := func(name string) riskSignal { return riskSignal{Name: name, Reason: name} }
+
+	cases := []struct {
+		name     string
+		signals  []riskSignal
+		score    int
+		decision string
+		notify   bool
+	}{
+		{name: "no signals", decision: riskDecisionAllow},
+		{name: "new account", signals: []riskSignal{signal(riskNewAccount)}, score: 10, decision: riskDecisionAllow},
+		{name: "new country", signals: []riskSignal{signal(riskNewCountry)}, score: 25, decision: riskDecisionAllow, notify: true},
+		{name: "impossible travel", signals: []riskSignal{signal(riskImpossibleTravel)}, score: 50, decision: riskDecisionChallenge, notify: true},
+		{
+			name:     "Tor exit after failed logins",
+			signals:  []riskSignal{signal(riskTorExit), signal(riskFailedAttempts), signal(riskNewDevice)},
+			score:    70,
+			decision: riskDecisionChallenge,
+			notify:   true,
+		},
+		{
+			name:     "impossible travel from a listed IP",
+			signals:  []riskSignal{signal(riskImpossibleTravel), signal(riskIPReputation)},
+			score:    90,
+			decision: riskDecisionDeny,
+			notify:   true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			res := scoreRisk(c.signals, config)
+
+			if diff := cmp.Diff([]interface{}{c.score, c.decision, c.notify}, []interface{}{res.Score, res.Decision, res.Notify}); diff != "" {
+				t.Errorf("\nUnexpected assessment (-want, +got)\n%s", diff)
+			}
+
+			for _, signal := range res.Signals {
+				if signal.Weight == 0 {
+					t.Errorf("expected %s to be dropped at weight 0", signal.Name)
+				}
+			}
+		})
+	}
+}
+
+func Test_Login_risk(t *testing.T) {
+	databaseTest(t, func(database *gorm.DB) {
+		clearDatabase(database)
+		id := "0d1c2b3a-4e5f-4a6b-8c7d-9e0f1a2b3c4d"
+		password := "SkunkStripeMapleNeckRosewoodFingerboard"
+		hash, _ := HashPassword(password)
+		database.Save(&User{ID: id, Name: "Leo Fender", Email: "leo@fender.com", Password: hash, CreatedAt: time.Now().Add(-48 * time.Hour)})
+
+		geoDB, err := utils.NewGeoDB(utils.BuildTestGeoDB(6, map[string]utils.GeoLocation{
+			"203.0.113.0/24":  {Country: "US", Latitude: 34.05, Longitude: -118.24, HasCoordinates: true},
+			"198.51.100.0/24": {Country: "GB", Latitude: 51.51, Longitude: -0.13, HasCoordinates: true},
+		}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		geoIP.Do(func() {})
+		geoIP.db = geoDB
+		defer func() { geoIP.db = nil }()
+
+		mailbox := withTestMailer(t)
+
+		userAgent := "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/87.0.4280.88 Safari/537.36"
+		home := RequestMeta{IP: "203.0.113.7", UserAgent: userAgent}
+		abroad := RequestMeta{IP: "198.51.100.4", UserAgent: userAgent}
+		login := func(meta RequestMeta) (LoginResponse, error) {
+			return Login(LoginRequest{Credential: Credential{Email: "leo@fender.com", Password: password}, Meta: meta})
+		}
+
+		if _, err := login(home); err != nil {
+			t.Fatal(err)
+		}
+
+		t.Run("denies impossible travel through a Tor exit node", func(t *testing.T) {
+			torExitNodes := filepath.Join(t.TempDir(), "tor-exit-nodes.txt")
+			ioutil.WriteFile(torExitNodes, []byte(abroad.IP+"\n"), 0644)
+			os.Setenv("torExitNodesFile", torExitNodes)
+			expireRiskLists()
+			defer func() {
+				os.Unsetenv("torExitNodesFile")
+				expireRiskLists()
+			}()
+			mailbox.Reset()
+
+			_, err := login(abroad)
+			utils.AssertErrorsEqual(t, utils.LoginFailedError(), err)
+
+			if len(mailbox.Sent) != 1 || mailbox.Sent[0].Subject != "Blocked sign-in to your account" {
+				t.Errorf("expected a blocked sign-in mail, got %+v", mailbox.Sent)
+			}
+		})
+
+		t.Run("challenges impossible travel by default", func(t *testing.T) {
+			mailbox.Reset()
+
+			_, err := login(abroad)
+			apiError, _ := err.(utils.APIError)
+			challenge, ok := apiError.Errors.(*utils.LoginChallenge)
+			if !ok {
+				t.Fatalf("expected a login challenge, got %v", err)
+			}
+
+			if len(mailbox.Sent) != 1 {
+				t.Fatalf("expected the code to be mailed, got %+v", mailbox.Sent)
+			}
+			code := regexp.MustCompile(`code is ([0-9]+)`).FindStringSubmatch(mailbox.Sent[0].Body)[1]
+
+			_, err = VerifyLogin(VerifyLoginRequest{ChallengeID: challenge.ChallengeID, Code: "not the code", Meta: abroad})
+			utils.AssertErrorsEqual(t, utils.LoginChallengeFailedError(), err)
+
+			res, err := VerifyLogin(VerifyLoginRequest{ChallengeID: challenge.ChallengeID, Code: code, Meta: abroad})
+			utils.AssertErrorsEqual(t, nil, err)
+
+			if res.AccessToken == "" {
+				t.Error("expected a token once the code was entered")
+			}
+
+			_, err = VerifyLogin(VerifyLoginRequest{ChallengeID: challenge.ChallengeID, Code: code, Meta: abroad})
+			utils.AssertErrorsEqual(t, utils.LoginChallengeFailedError(), err)
+		})
+
+		t.Run("records the signals in the login history", func(t *testing.T) {
+			history, err := GetLoginHistory(LoginHistoryRequest{ID: id})
+			utils.AssertErrorsEqual(t, nil, err)
+
+			var got [][]string
+			for _, entry := range history.Logins {
+				got = append(got, append([]string{entry.Country}, entry.RiskSignals...))
+			}
+
+			expected := [][]string{
+				{"GB", riskNewCountry, riskImpossibleTravel},
+				{"GB"},
+				{"GB", riskNewCountry, riskImpossibleTravel, riskTorExit},
+				{"US"},
+			}
+			if diff := cmp.Diff(expected, got); diff != "" {
+				t.Errorf("\nUnexpected history (-want, +got)\n%s", diff)
+			}
+		})
+
+		t.Run("explains each decision in the audit log", func(t *testing.T) {
+			var decisions []string
+			for _, action := range []string{auditLoginBlocked, auditLoginChallenged} {
+				events, err := ListAuditEvents(ListAuditEventsRequest{SubjectID: id, Action: action, Limit: 1})
+				utils.AssertErrorsEqual(t, nil, err)
+
+				if len(events.Events) != 1 {
+					t.Fatalf("expected a %s event", action)
+				}
+
+				var assessment riskAssessment
+				json.Unmarshal(events.Events[0].Details, &assessment)
+				decisions = append(decisions, assessment.Decision)
+
+				if regexp.MustCompile(`"reason"|GB`).Match(events.Events[0].Details) {
+					t.Errorf("expected no readable reasons in the %s event, got %s", action, events.Events[0].Details)
+				}
+			}
+
+			if diff := cmp.Diff([]string{riskDecisionDeny, riskDecisionChallenge}, decisions); diff != "" {
+				t.Errorf("\nUnexpected decisions (-want, +got)\n%s", diff)
+			}
+		})
+
+		t.Run("limits wrong codes across challenges", func(t *testing.T) {
+			var user User
+			database.First(&user, "id = ?", id)
+			challengeID := func() string {
+				apiError, _ := startLoginChallenge(database, user, newLoginAttempt(id, true, abroad)).(utils.APIError)
+				challenge, ok := apiError.Errors.(*utils.LoginChallenge)
+				if !ok {
+					t.Fatalf("expected a login challenge, got %v", apiError)
+				}
+				return challenge.ChallengeID
+			}
+			mailbox.Reset()
+
+			first := challengeID()
+			if again := challengeID(); again != first || len(mailbox.Sent) != 1 {
+				t.Errorf("expected the live challenge back without a new code, got %s and %d mails", again, len(mailbox.Sent))
+			}
+
+			guessWrong := func(challengeID string) {
+				for i := 0; i < maxLoginChallengeAttempts; i++ {
+					_, err := VerifyLogin(VerifyLoginRequest{ChallengeID: challengeID, Code: "not the code", Meta: abroad})
+					utils.AssertErrorsEqual(t, utils.LoginChallengeFailedError(), err)
+				}
+			}
+
+			guessWrong(first)
+			second := challengeID()
+			if second == first {
+				t.Error("expected a new challenge once the guesses were used up")
+			}
+			guessWrong(second)
+
+			if !loginCodesExhausted(database, id, time.Now()) {
+				t.Errorf("expected %d wrong codes to stop further challenges", maxFailedLoginCodes)
+			}
+		})
+	})
+}
@@ -0,0 +1,52 @@
+package platform_exercise
+
+import (
+	"log"
+	"net"
+	"net/smtp"
+	"os"
+	"sync"
+
+	"github.com/campallison/platform-exercise/utils"
+)
+
+var (
+	mailerOnce sync.Once
+	mailer     utils.Mailer
+)
+
+// newMailer sends through the SMTP relay at smtpAddr when one is configured,
+// and otherwise drops outgoing mail.
+func newMailer() utils.Mailer {
+	addr := os.Getenv("smtpAddr")
+	if addr == "" {
+		return utils.LogMailer{}
+	}
+
+	var auth smtp.Auth
+	if username := os.Getenv("smtpUsername"); username != "" {
+		host, _, _ := net.SplitHostPort(addr)
+		auth = smtp.PlainAuth("", username, os.Getenv("smtpPassword"), host)
+	}
+
+	return utils.SMTPMailer{Addr: addr, From: os.Getenv("mailFrom"), Auth: auth}
+}
+
+// currentMailer returns the mailer, creating it on first use.
+func currentMailer() utils.Mailer {
+	mailerOnce.Do(func() {
+		if mailer == nil {
+			mailer = newMailer()
+		}
+	})
+
+	return mailer
+}
+
+// sendMail is best effort: a failure is logged but never fails the request
+// that triggered the mail.
+func sendMail(mail utils.Mail) {
+	if err := currentMailer().Send(mail); err != nil {
+		log.Printf("\nCould not send mail to %s\n%v\n", mail.To, err)
+	}
+}
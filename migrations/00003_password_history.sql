@@ -0,0 +1,13 @@
+-- +goose Up
+CREATE TABLE password_history (
+    created_at timestamp with time zone,
+    updated_at timestamp with time zone,
+    id uuid DEFAULT uuid_generate_v4() NOT NULL,
+    user_id uuid NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    password text NOT NULL,
+    PRIMARY KEY(id)
+);
+CREATE INDEX password_history_user_id_created_at_idx ON password_history (user_id, created_at DESC);
+
+-- +goose Down
+DROP TABLE password_history;
@@ -0,0 +1,11 @@
+-- +goose Up
+CREATE TABLE email_domain_rules (
+    created_at timestamp with time zone,
+    updated_at timestamp with time zone,
+    domain text NOT NULL,
+    list text NOT NULL CHECK (list IN ('blocked', 'allowed')),
+    PRIMARY KEY(domain, list)
+);
+
+-- +goose Down
+DROP TABLE email_domain_rules;
@@ -0,0 +1,36 @@
+-- +goose Up
+-- Backfills with the default provider rules (lowercasing, Gmail dot-stripping).
+-- Deployments with a custom emailProviderRules should re-save affected users.
+ALTER TABLE users ADD COLUMN canonical_email text;
+UPDATE users SET canonical_email = lower(email);
+UPDATE users
+SET canonical_email = replace(split_part(canonical_email, '@', 1), '.', '') || '@gmail.com'
+WHERE split_part(canonical_email, '@', 2) IN ('gmail.com', 'googlemail.com');
+
+-- +goose StatementBegin
+DO $$
+DECLARE
+    collisions text;
+BEGIN
+    SELECT string_agg(canonical_email || ' (' || ids || ')', ', ')
+    INTO collisions
+    FROM (
+        SELECT canonical_email, string_agg(id::text, ' ') AS ids
+        FROM users
+        GROUP BY canonical_email
+        HAVING count(*) > 1
+    ) duplicates;
+
+    IF collisions IS NOT NULL THEN
+        RAISE EXCEPTION 'users share a canonical email and must be merged before migrating: %', collisions;
+    END IF;
+END
+$$;
+-- +goose StatementEnd
+
+ALTER TABLE users ALTER COLUMN canonical_email SET NOT NULL;
+CREATE UNIQUE INDEX users_canonical_email_key ON users (canonical_email);
+
+-- +goose Down
+DROP INDEX users_canonical_email_key;
+ALTER TABLE users DROP COLUMN canonical_email;
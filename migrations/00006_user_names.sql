@@ -0,0 +1,11 @@
+-- +goose Up
+ALTER TABLE users
+    ADD COLUMN given_name text NOT NULL DEFAULT '',
+    ADD COLUMN family_name text NOT NULL DEFAULT '',
+    ADD COLUMN display_name text NOT NULL DEFAULT '';
+
+-- +goose Down
+ALTER TABLE users
+    DROP COLUMN display_name,
+    DROP COLUMN family_name,
+    DROP COLUMN given_name;
@@ -0,0 +1,56 @@
+-- +goose Up
+CREATE TABLE roles (
+    created_at timestamp with time zone,
+    updated_at timestamp with time zone,
+    name text NOT NULL,
+    description text NOT NULL DEFAULT '',
+    PRIMARY KEY(name)
+);
+
+CREATE TABLE permissions (
+    created_at timestamp with time zone,
+    updated_at timestamp with time zone,
+    name text NOT NULL,
+    description text NOT NULL DEFAULT '',
+    PRIMARY KEY(name)
+);
+
+CREATE TABLE role_permissions (
+    role text NOT NULL REFERENCES roles(name) ON DELETE CASCADE,
+    permission text NOT NULL REFERENCES permissions(name) ON DELETE CASCADE,
+    PRIMARY KEY(role, permission)
+);
+
+CREATE TABLE user_roles (
+    created_at timestamp with time zone,
+    updated_at timestamp with time zone,
+    user_id uuid NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    role text NOT NULL REFERENCES roles(name) ON DELETE CASCADE,
+    PRIMARY KEY(user_id, role)
+);
+
+INSERT INTO roles (created_at, updated_at, name, description) VALUES
+    (now(), now(), 'support', 'Support staff who can view and correct other accounts'),
+    (now(), now(), 'admin', 'Administrators with every permission');
+
+INSERT INTO permissions (created_at, updated_at, name, description) VALUES
+    (now(), now(), 'users:read', 'View any user'),
+    (now(), now(), 'users:write', 'Update any user'),
+    (now(), now(), 'users:delete', 'Delete any user'),
+    (now(), now(), 'roles:manage', 'Assign and revoke roles'),
+    (now(), now(), 'email-domains:manage', 'Edit the email domain lists');
+
+INSERT INTO role_permissions (role, permission) VALUES
+    ('support', 'users:read'),
+    ('support', 'users:write'),
+    ('admin', 'users:read'),
+    ('admin', 'users:write'),
+    ('admin', 'users:delete'),
+    ('admin', 'roles:manage'),
+    ('admin', 'email-domains:manage');
+
+-- +goose Down
+DROP TABLE user_roles;
+DROP TABLE role_permissions;
+DROP TABLE permissions;
+DROP TABLE roles;
@@ -0,0 +1,17 @@
+-- +goose NO TRANSACTION
+-- +goose Up
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+CREATE INDEX CONCURRENTLY IF NOT EXISTS users_created_at_id_idx ON users (created_at, id);
+CREATE INDEX CONCURRENTLY IF NOT EXISTS users_lower_email_id_idx ON users (lower(email), id);
+CREATE INDEX CONCURRENTLY IF NOT EXISTS users_lower_email_pattern_idx ON users (lower(email) text_pattern_ops);
+CREATE INDEX CONCURRENTLY IF NOT EXISTS users_name_id_idx ON users (name, id);
+CREATE INDEX CONCURRENTLY IF NOT EXISTS users_name_trgm_idx ON users USING gin (name gin_trgm_ops);
+CREATE INDEX CONCURRENTLY IF NOT EXISTS users_deleted_at_idx ON users (deleted_at);
+
+-- +goose Down
+DROP INDEX CONCURRENTLY IF EXISTS users_deleted_at_idx;
+DROP INDEX CONCURRENTLY IF EXISTS users_name_trgm_idx;
+DROP INDEX CONCURRENTLY IF EXISTS users_name_id_idx;
+DROP INDEX CONCURRENTLY IF EXISTS users_lower_email_pattern_idx;
+DROP INDEX CONCURRENTLY IF EXISTS users_lower_email_id_idx;
+DROP INDEX CONCURRENTLY IF EXISTS users_created_at_id_idx;
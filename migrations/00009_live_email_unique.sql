@@ -0,0 +1,13 @@
+-- +goose Up
+ALTER TABLE users DROP CONSTRAINT users_email_key;
+CREATE UNIQUE INDEX users_email_key ON users (email) WHERE deleted_at IS NULL;
+
+DROP INDEX users_canonical_email_key;
+CREATE UNIQUE INDEX users_canonical_email_key ON users (canonical_email) WHERE deleted_at IS NULL;
+
+-- +goose Down
+DROP INDEX users_canonical_email_key;
+CREATE UNIQUE INDEX users_canonical_email_key ON users (canonical_email);
+
+DROP INDEX users_email_key;
+ALTER TABLE users ADD CONSTRAINT users_email_key UNIQUE (email);
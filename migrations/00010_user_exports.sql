@@ -0,0 +1,16 @@
+-- +goose Up
+CREATE TABLE user_exports (
+    created_at timestamp with time zone,
+    updated_at timestamp with time zone,
+    id uuid DEFAULT uuid_generate_v4() NOT NULL,
+    user_id uuid NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    status text NOT NULL,
+    archive text,
+    expires_at timestamp with time zone,
+    PRIMARY KEY(id)
+);
+CREATE INDEX user_exports_user_id_idx ON user_exports (user_id);
+CREATE INDEX user_exports_status_idx ON user_exports (status);
+
+-- +goose Down
+DROP TABLE user_exports;
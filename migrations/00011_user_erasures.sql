@@ -0,0 +1,13 @@
+-- +goose Up
+-- Tombstones outlive the users they describe, so user_id is deliberately not
+-- a foreign key.
+CREATE TABLE user_erasures (
+    created_at timestamp with time zone,
+    updated_at timestamp with time zone,
+    user_id uuid NOT NULL,
+    completed_at timestamp with time zone,
+    PRIMARY KEY(user_id)
+);
+
+-- +goose Down
+DROP TABLE user_erasures;
@@ -0,0 +1,14 @@
+-- +goose Up
+CREATE TABLE email_changes (
+    created_at timestamp with time zone,
+    updated_at timestamp with time zone,
+    id uuid DEFAULT uuid_generate_v4() NOT NULL,
+    user_id uuid NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    new_email text NOT NULL,
+    expires_at timestamp with time zone NOT NULL,
+    PRIMARY KEY(id),
+    UNIQUE(user_id)
+);
+
+-- +goose Down
+DROP TABLE email_changes;
@@ -0,0 +1,61 @@
+-- +goose Up
+CREATE TABLE audit_events (
+    id bigserial NOT NULL,
+    created_at timestamp with time zone NOT NULL,
+    actor_id text NOT NULL DEFAULT '',
+    subject_id text NOT NULL DEFAULT '',
+    action text NOT NULL,
+    changed_fields text NOT NULL DEFAULT '',
+    ip text,
+    user_agent text,
+    pii_digest text NOT NULL DEFAULT '',
+    prev_hash text NOT NULL DEFAULT '',
+    hash text NOT NULL,
+    PRIMARY KEY(id)
+);
+CREATE INDEX audit_events_actor_id_idx ON audit_events (actor_id, id);
+CREATE INDEX audit_events_subject_id_idx ON audit_events (subject_id, id);
+CREATE INDEX audit_events_action_idx ON audit_events (action, id);
+CREATE INDEX audit_events_created_at_idx ON audit_events (created_at);
+
+-- Rows can only be added. The one update allowed is clearing ip and
+-- user_agent when a user is erased; pii_digest keeps the hash chain intact.
+-- +goose StatementBegin
+CREATE FUNCTION audit_events_append_only() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'UPDATE'
+        AND NEW.ip IS NULL
+        AND NEW.user_agent IS NULL
+        AND (NEW.id, NEW.created_at, NEW.actor_id, NEW.subject_id, NEW.action,
+             NEW.changed_fields, NEW.pii_digest, NEW.prev_hash, NEW.hash)
+            IS NOT DISTINCT FROM
+            (OLD.id, OLD.created_at, OLD.actor_id, OLD.subject_id, OLD.action,
+             OLD.changed_fields, OLD.pii_digest, OLD.prev_hash, OLD.hash)
+    THEN
+        RETURN NEW;
+    END IF;
+
+    RAISE EXCEPTION 'audit_events is append-only';
+END
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+CREATE TRIGGER audit_events_append_only
+    BEFORE UPDATE OR DELETE ON audit_events
+    FOR EACH ROW EXECUTE PROCEDURE audit_events_append_only();
+
+CREATE TRIGGER audit_events_no_truncate
+    BEFORE TRUNCATE ON audit_events
+    FOR EACH STATEMENT EXECUTE PROCEDURE audit_events_append_only();
+
+INSERT INTO permissions (created_at, updated_at, name, description) VALUES
+    (now(), now(), 'audit:read', 'View the audit log');
+
+INSERT INTO role_permissions (role, permission) VALUES
+    ('admin', 'audit:read');
+
+-- +goose Down
+DELETE FROM role_permissions WHERE permission = 'audit:read';
+DELETE FROM permissions WHERE name = 'audit:read';
+DROP TABLE audit_events;
+DROP FUNCTION audit_events_append_only();
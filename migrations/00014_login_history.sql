@@ -0,0 +1,16 @@
+-- +goose Up
+CREATE TABLE login_history (
+    id bigserial NOT NULL,
+    created_at timestamp with time zone NOT NULL,
+    user_id uuid NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    succeeded boolean NOT NULL,
+    ip text NOT NULL DEFAULT '',
+    user_agent text NOT NULL DEFAULT '',
+    device_fingerprint text NOT NULL DEFAULT '',
+    PRIMARY KEY(id)
+);
+CREATE INDEX login_history_user_id_id_idx ON login_history (user_id, id DESC);
+CREATE INDEX login_history_user_id_device_idx ON login_history (user_id, device_fingerprint) WHERE succeeded;
+
+-- +goose Down
+DROP TABLE login_history;
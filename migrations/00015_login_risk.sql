@@ -0,0 +1,27 @@
+-- +goose Up
+ALTER TABLE login_history
+    ADD COLUMN country text NOT NULL DEFAULT '',
+    ADD COLUMN latitude double precision,
+    ADD COLUMN longitude double precision,
+    ADD COLUMN risk_signals text NOT NULL DEFAULT '';
+
+CREATE TABLE login_challenges (
+    id uuid DEFAULT uuid_generate_v4() NOT NULL,
+    created_at timestamp with time zone NOT NULL,
+    user_id uuid NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    code_hash text NOT NULL,
+    attempts integer NOT NULL DEFAULT 0,
+    country text NOT NULL DEFAULT '',
+    risk_signals text NOT NULL DEFAULT '',
+    expires_at timestamp with time zone NOT NULL,
+    PRIMARY KEY(id)
+);
+CREATE INDEX login_challenges_user_id_idx ON login_challenges (user_id);
+
+-- +goose Down
+DROP TABLE login_challenges;
+ALTER TABLE login_history
+    DROP COLUMN country,
+    DROP COLUMN latitude,
+    DROP COLUMN longitude,
+    DROP COLUMN risk_signals;
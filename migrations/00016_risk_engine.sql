@@ -0,0 +1,54 @@
+-- +goose Up
+ALTER TABLE login_history ADD COLUMN risk_score integer NOT NULL DEFAULT 0;
+ALTER TABLE login_challenges ADD COLUMN risk_score integer NOT NULL DEFAULT 0;
+
+-- Risk decisions are explained in the audit log. Details are covered by the
+-- hash like every other column but ip and user_agent.
+ALTER TABLE audit_events ADD COLUMN details text NOT NULL DEFAULT '';
+
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION audit_events_append_only() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'UPDATE'
+        AND NEW.ip IS NULL
+        AND NEW.user_agent IS NULL
+        AND (NEW.id, NEW.created_at, NEW.actor_id, NEW.subject_id, NEW.action,
+             NEW.changed_fields, NEW.details, NEW.pii_digest, NEW.prev_hash, NEW.hash)
+            IS NOT DISTINCT FROM
+            (OLD.id, OLD.created_at, OLD.actor_id, OLD.subject_id, OLD.action,
+             OLD.changed_fields, OLD.details, OLD.pii_digest, OLD.prev_hash, OLD.hash)
+    THEN
+        RETURN NEW;
+    END IF;
+
+    RAISE EXCEPTION 'audit_events is append-only';
+END
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION audit_events_append_only() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'UPDATE'
+        AND NEW.ip IS NULL
+        AND NEW.user_agent IS NULL
+        AND (NEW.id, NEW.created_at, NEW.actor_id, NEW.subject_id, NEW.action,
+             NEW.changed_fields, NEW.pii_digest, NEW.prev_hash, NEW.hash)
+            IS NOT DISTINCT FROM
+            (OLD.id, OLD.created_at, OLD.actor_id, OLD.subject_id, OLD.action,
+             OLD.changed_fields, OLD.pii_digest, OLD.prev_hash, OLD.hash)
+    THEN
+        RETURN NEW;
+    END IF;
+
+    RAISE EXCEPTION 'audit_events is append-only';
+END
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+-- Dropping a column fires no row triggers. Events recorded with details will
+-- no longer verify once they are gone.
+ALTER TABLE audit_events DROP COLUMN details;
+ALTER TABLE login_challenges DROP COLUMN risk_score;
+ALTER TABLE login_history DROP COLUMN risk_score;
@@ -0,0 +1,41 @@
+-- +goose Up
+-- Failed logins from every source, kept for a day to spot attacks spread
+-- across accounts. Accounts and passwords are only stored as keyed digests.
+CREATE TABLE failed_logins (
+    id bigserial NOT NULL,
+    created_at timestamp with time zone NOT NULL,
+    ip text NOT NULL DEFAULT '',
+    subnet text NOT NULL DEFAULT '',
+    password_fingerprint text NOT NULL,
+    account_digest text NOT NULL,
+    PRIMARY KEY(id)
+);
+CREATE INDEX failed_logins_ip_idx ON failed_logins (ip, created_at);
+CREATE INDEX failed_logins_subnet_idx ON failed_logins (subnet, created_at);
+CREATE INDEX failed_logins_password_fingerprint_idx ON failed_logins (password_fingerprint, created_at);
+CREATE INDEX failed_logins_created_at_idx ON failed_logins (created_at);
+
+CREATE TABLE login_blocks (
+    id bigserial NOT NULL,
+    created_at timestamp with time zone NOT NULL,
+    kind text NOT NULL,
+    value text NOT NULL,
+    accounts integer NOT NULL,
+    reason text NOT NULL,
+    expires_at timestamp with time zone NOT NULL,
+    PRIMARY KEY(id)
+);
+CREATE INDEX login_blocks_kind_value_idx ON login_blocks (kind, value, expires_at);
+CREATE INDEX login_blocks_expires_at_idx ON login_blocks (expires_at);
+
+INSERT INTO permissions (created_at, updated_at, name, description) VALUES
+    (now(), now(), 'login-blocks:read', 'View sources blocked for attacking logins');
+
+INSERT INTO role_permissions (role, permission) VALUES
+    ('admin', 'login-blocks:read');
+
+-- +goose Down
+DELETE FROM role_permissions WHERE permission = 'login-blocks:read';
+DELETE FROM permissions WHERE name = 'login-blocks:read';
+DROP TABLE login_blocks;
+DROP TABLE failed_logins;
@@ -0,0 +1,24 @@
+-- +goose Up
+CREATE TABLE ip_rules (
+    id bigserial NOT NULL,
+    created_at timestamp with time zone NOT NULL,
+    updated_at timestamp with time zone NOT NULL,
+    action text NOT NULL,
+    cidr text NOT NULL,
+    scope text NOT NULL,
+    subject text NOT NULL DEFAULT '',
+    description text NOT NULL DEFAULT '',
+    PRIMARY KEY(id)
+);
+CREATE INDEX ip_rules_scope_subject_idx ON ip_rules (scope, subject);
+
+INSERT INTO permissions (created_at, updated_at, name, description) VALUES
+    (now(), now(), 'ip-rules:manage', 'Manage the networks logins and tokens may be used from');
+
+INSERT INTO role_permissions (role, permission) VALUES
+    ('admin', 'ip-rules:manage');
+
+-- +goose Down
+DELETE FROM role_permissions WHERE permission = 'ip-rules:manage';
+DELETE FROM permissions WHERE name = 'ip-rules:manage';
+DROP TABLE ip_rules;
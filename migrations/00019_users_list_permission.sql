@@ -0,0 +1,11 @@
+-- +goose Up
+-- Listing every account is for admins only; support staff look users up by ID.
+INSERT INTO permissions (created_at, updated_at, name, description) VALUES
+    (now(), now(), 'users:list', 'List and search all users');
+
+INSERT INTO role_permissions (role, permission) VALUES
+    ('admin', 'users:list');
+
+-- +goose Down
+DELETE FROM role_permissions WHERE permission = 'users:list';
+DELETE FROM permissions WHERE name = 'users:list';
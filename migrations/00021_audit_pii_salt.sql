@@ -0,0 +1,52 @@
+-- +goose Up
+-- pii_digest is salted with a random value per event, cleared with ip and
+-- user_agent on erasure, so an erased digest cannot be brute-forced from the
+-- small space of addresses and user agents. Events recorded before this
+-- migration have no salt and keep their unsalted digest, which the chain
+-- covers and so cannot be changed.
+ALTER TABLE audit_events ADD COLUMN pii_salt text;
+
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION audit_events_append_only() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'UPDATE'
+        AND NEW.ip IS NULL
+        AND NEW.user_agent IS NULL
+        AND NEW.pii_salt IS NULL
+        AND (NEW.id, NEW.created_at, NEW.actor_id, NEW.subject_id, NEW.action,
+             NEW.changed_fields, NEW.details, NEW.pii_digest, NEW.prev_hash, NEW.hash)
+            IS NOT DISTINCT FROM
+            (OLD.id, OLD.created_at, OLD.actor_id, OLD.subject_id, OLD.action,
+             OLD.changed_fields, OLD.details, OLD.pii_digest, OLD.prev_hash, OLD.hash)
+    THEN
+        RETURN NEW;
+    END IF;
+
+    RAISE EXCEPTION 'audit_events is append-only';
+END
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION audit_events_append_only() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'UPDATE'
+        AND NEW.ip IS NULL
+        AND NEW.user_agent IS NULL
+        AND (NEW.id, NEW.created_at, NEW.actor_id, NEW.subject_id, NEW.action,
+             NEW.changed_fields, NEW.details, NEW.pii_digest, NEW.prev_hash, NEW.hash)
+            IS NOT DISTINCT FROM
+            (OLD.id, OLD.created_at, OLD.actor_id, OLD.subject_id, OLD.action,
+             OLD.changed_fields, OLD.details, OLD.pii_digest, OLD.prev_hash, OLD.hash)
+    THEN
+        RETURN NEW;
+    END IF;
+
+    RAISE EXCEPTION 'audit_events is append-only';
+END
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+-- Dropping a column fires no row triggers.
+ALTER TABLE audit_events DROP COLUMN pii_salt;
@@ -0,0 +1,10 @@
+-- +goose Up
+-- method tells a wrong challenge code apart from a wrong password, so wrong
+-- codes can be counted per user across challenges.
+ALTER TABLE login_history ADD COLUMN method text NOT NULL DEFAULT 'password';
+CREATE INDEX login_history_user_id_failed_code_idx ON login_history (user_id, created_at)
+    WHERE NOT succeeded AND method = 'email_code';
+
+-- +goose Down
+DROP INDEX login_history_user_id_failed_code_idx;
+ALTER TABLE login_history DROP COLUMN method;
@@ -0,0 +1,13 @@
+-- +goose Up
+-- Batch validation can look up the DNS of a thousand domains per call, so it
+-- is for staff importing lists rather than for anonymous callers.
+INSERT INTO permissions (created_at, updated_at, name, description) VALUES
+    (now(), now(), 'emails:validate', 'Validate lists of email addresses');
+
+INSERT INTO role_permissions (role, permission) VALUES
+    ('support', 'emails:validate'),
+    ('admin', 'emails:validate');
+
+-- +goose Down
+DELETE FROM role_permissions WHERE permission = 'emails:validate';
+DELETE FROM permissions WHERE name = 'emails:validate';
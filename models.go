@@ -7,17 +7,199 @@ import (
 )
 
 type User struct {
-	CreatedAt time.Time      `json:"-"`
-	UpdatedAt time.Time      `json:"-"`
-	DeletedAt gorm.DeletedAt `sql:"index" json:"-"`
-	ID        string         `gorm:"primaryKey;default:uuid_generate_v4()" json:"id"`
-	Name      string         `json:"name"`
-	Email     string         `json:"email"`
-	Password  string         `json:"password"`
+	CreatedAt      time.Time      `json:"-"`
+	UpdatedAt      time.Time      `json:"-"`
+	DeletedAt      gorm.DeletedAt `sql:"index" json:"-"`
+	ID             string         `gorm:"primaryKey;default:uuid_generate_v4()" json:"id"`
+	Name           string         `json:"name"`
+	GivenName      string         `json:"givenName"`
+	FamilyName     string         `json:"familyName"`
+	DisplayName    string         `json:"displayName"`
+	Email          string         `json:"email"`
+	CanonicalEmail string         `json:"-"`
+	Password       string         `json:"password"`
+}
+
+func (u *User) BeforeSave(tx *gorm.DB) error {
+	if u.Email != "" {
+		u.CanonicalEmail = canonicalEmail(u.Email)
+	}
+	return nil
 }
 
 type InvalidToken struct {
 	CreatedAt time.Time `json:"-"`
 	UpdatedAt time.Time `json:"-"`
 	Token     string    `json:"token" gorm:"primaryKey"`
+	UserID    string    `json:"-"`
+}
+
+type PasswordHistory struct {
+	CreatedAt time.Time `json:"-"`
+	UpdatedAt time.Time `json:"-"`
+	ID        string    `gorm:"primaryKey;default:uuid_generate_v4()" json:"id"`
+	UserID    string    `json:"user_id"`
+	Password  string    `json:"-"`
+}
+
+func (PasswordHistory) TableName() string {
+	return "password_history"
+}
+
+type EmailDomainRule struct {
+	CreatedAt time.Time `json:"-"`
+	UpdatedAt time.Time `json:"-"`
+	Domain    string    `gorm:"primaryKey" json:"domain"`
+	List      string    `gorm:"primaryKey" json:"list"`
+}
+
+type Role struct {
+	CreatedAt   time.Time `json:"-"`
+	UpdatedAt   time.Time `json:"-"`
+	Name        string    `gorm:"primaryKey" json:"name"`
+	Description string    `json:"description"`
+}
+
+type Permission struct {
+	CreatedAt   time.Time `json:"-"`
+	UpdatedAt   time.Time `json:"-"`
+	Name        string    `gorm:"primaryKey" json:"name"`
+	Description string    `json:"description"`
+}
+
+type RolePermission struct {
+	Role       string `gorm:"primaryKey" json:"role"`
+	Permission string `gorm:"primaryKey" json:"permission"`
+}
+
+type UserRole struct {
+	CreatedAt time.Time `json:"-"`
+	UpdatedAt time.Time `json:"-"`
+	UserID    string    `gorm:"primaryKey" json:"user_id"`
+	Role      string    `gorm:"primaryKey" json:"role"`
+}
+
+type UserExportJob struct {
+	CreatedAt time.Time  `json:"createdAt"`
+	UpdatedAt time.Time  `json:"-"`
+	ID        string     `gorm:"primaryKey;default:uuid_generate_v4()" json:"id"`
+	UserID    string     `json:"userId"`
+	Status    string     `json:"status"`
+	Archive   string     `json:"-"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+}
+
+func (UserExportJob) TableName() string {
+	return "user_exports"
+}
+
+// UserErasure is the tombstone left when a user's data is erased. It holds
+// nothing but the user's ID, which is random and identifies no one once the
+// user row is gone.
+type UserErasure struct {
+	CreatedAt   time.Time  `json:"requestedAt"`
+	UpdatedAt   time.Time  `json:"-"`
+	UserID      string     `gorm:"primaryKey" json:"userId"`
+	CompletedAt *time.Time `json:"completedAt,omitempty"`
+}
+
+// EmailChange is an email change waiting for the user to confirm it from the
+// new address. A user has at most one; asking again replaces it.
+type EmailChange struct {
+	CreatedAt time.Time `json:"-"`
+	UpdatedAt time.Time `json:"-"`
+	ID        string    `gorm:"primaryKey;default:uuid_generate_v4()" json:"id"`
+	UserID    string    `json:"userId"`
+	NewEmail  string    `json:"newEmail"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// AuditEvent is one entry in the append-only audit log. Each entry's Hash
+// covers the previous entry's, so editing or removing a row breaks the chain
+// from there on. IP and UserAgent are only covered through PIIDigest, so they
+// can be cleared when a user is erased, along with the PIISalt that keeps the
+// digest from being reversed.
+type AuditEvent struct {
+	ID            int64     `gorm:"primaryKey" json:"id"`
+	CreatedAt     time.Time `json:"createdAt"`
+	ActorID       string    `json:"actorId"`
+	SubjectID     string    `json:"subjectId"`
+	Action        string    `json:"action"`
+	ChangedFields string    `json:"-"`
+	Details       string    `json:"-"`
+	IP            string    `gorm:"column:ip" json:"ip"`
+	UserAgent     string    `json:"userAgent"`
+	PIISalt       string    `gorm:"column:pii_salt" json:"-"`
+	PIIDigest     string    `gorm:"column:pii_digest" json:"-"`
+	PrevHash      string    `json:"prevHash"`
+	Hash          string    `json:"hash"`
+}
+
+type LoginAttempt struct {
+	ID                int64     `gorm:"primaryKey" json:"id"`
+	CreatedAt         time.Time `json:"createdAt"`
+	UserID            string    `json:"-"`
+	Succeeded         bool      `json:"succeeded"`
+	Method            string    `json:"method"`
+	IP                string    `gorm:"column:ip" json:"ip"`
+	UserAgent         string    `json:"userAgent"`
+	DeviceFingerprint string    `json:"deviceFingerprint"`
+	Country           string    `json:"country,omitempty"`
+	Latitude          *float64  `json:"latitude,omitempty"`
+	Longitude         *float64  `json:"longitude,omitempty"`
+	RiskScore         int       `json:"riskScore"`
+	RiskSignals       string    `json:"-"`
+}
+
+func (LoginAttempt) TableName() string {
+	return "login_history"
+}
+
+// LoginChallenge is a login held back by the risk policy until the user
+// enters the code emailed to them.
+type LoginChallenge struct {
+	ID          string `gorm:"primaryKey;default:uuid_generate_v4()"`
+	CreatedAt   time.Time
+	UserID      string
+	CodeHash    string
+	Attempts    int
+	Country     string
+	RiskScore   int
+	RiskSignals string
+	ExpiresAt   time.Time
+}
+
+// FailedLogin is a failed login from any source, kept to spot attacks that
+// try many accounts.
+type FailedLogin struct {
+	ID                  int64 `gorm:"primaryKey"`
+	CreatedAt           time.Time
+	IP                  string `gorm:"column:ip"`
+	Subnet              string
+	PasswordFingerprint string
+	AccountDigest       string
+}
+
+// LoginBlock stops logins from an IP, subnet or password until it expires.
+type LoginBlock struct {
+	ID        int64     `gorm:"primaryKey" json:"id"`
+	CreatedAt time.Time `json:"createdAt"`
+	Kind      string    `json:"kind"`
+	Value     string    `json:"value"`
+	Accounts  int       `json:"accounts"`
+	Reason    string    `json:"reason"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// IPRule allows or denies logins and tokens from a network, for everyone, for
+// holders of a role, or for one user.
+type IPRule struct {
+	ID          int64     `gorm:"primaryKey" json:"id"`
+	CreatedAt   time.Time `json:"createdAt"`
+	UpdatedAt   time.Time `json:"-"`
+	Action      string    `json:"action"`
+	CIDR        string    `gorm:"column:cidr" json:"cidr"`
+	Scope       string    `json:"scope"`
+	Subject     string    `json:"subject,omitempty"`
+	Description string    `json:"description,omitempty"`
 }
@@ -0,0 +1,112 @@
+package platform_exercise
+
+import (
+	"log"
+	"os"
+	"sync"
+
+	"github.com/campallison/platform-exercise/utils"
+)
+
+const (
+	defaultNameMinLength = 2
+	defaultNameMaxLength = 100
+)
+
+var (
+	nameScreenerOnce sync.Once
+	nameScreener     utils.NameScreener
+)
+
+type userNames struct {
+	Name        string
+	GivenName   string
+	FamilyName  string
+	DisplayName string
+}
+
+func nameRules() utils.NameRules {
+	return utils.NameRules{
+		MinLength: positiveIntFromEnv("nameMinLength", defaultNameMinLength),
+		MaxLength: positiveIntFromEnv("nameMaxLength", defaultNameMaxLength),
+	}
+}
+
+// displayNameRules are looser than nameRules, since display names are chosen
+// by the user rather than legal names and often contain digits.
+func displayNameRules() utils.NameRules {
+	rules := nameRules()
+	rules.AllowDigits = true
+	return rules
+}
+
+// normalizeNames validates every name that was supplied and returns them
+// normalized. Empty names are left empty.
+func normalizeNames(names userNames) (userNames, error) {
+	var err error
+
+	fields := []struct {
+		value *string
+		rules utils.NameRules
+	}{
+		{&names.Name, nameRules()},
+		{&names.GivenName, nameRules()},
+		{&names.FamilyName, nameRules()},
+		{&names.DisplayName, displayNameRules()},
+	}
+
+	for _, field := range fields {
+		if *field.value == "" {
+			continue
+		}
+
+		if *field.value, err = utils.ValidateName(*field.value, field.rules); err != nil {
+			return userNames{}, err
+		}
+	}
+
+	return names, nil
+}
+
+// loadNameScreener extends the built-in word lists with the files named by
+// profaneWordsFile and reservedNamesFile. A file that cannot be read is
+// logged and skipped rather than leaving names unscreened.
+func loadNameScreener() {
+	profane := utils.DefaultProfaneWords()
+	if path := os.Getenv("profaneWordsFile"); path != "" {
+		if words, err := utils.LoadWordListFile(path); err != nil {
+			log.Printf("\nCould not load profaneWordsFile, using defaults\n%v\n", err)
+		} else {
+			profane = append(profane, words...)
+		}
+	}
+
+	reserved := utils.DefaultReservedNames()
+	if path := os.Getenv("reservedNamesFile"); path != "" {
+		if names, err := utils.LoadWordListFile(path); err != nil {
+			log.Printf("\nCould not load reservedNamesFile, using defaults\n%v\n", err)
+		} else {
+			reserved = append(reserved, names...)
+		}
+	}
+
+	nameScreener = utils.NewWordListScreener(profane, reserved)
+}
+
+// screenNames runs every supplied name through the name screener, which is
+// public-facing content screening on top of the checks in normalizeNames.
+func screenNames(names userNames) error {
+	nameScreenerOnce.Do(loadNameScreener)
+
+	for _, name := range []string{names.Name, names.GivenName, names.FamilyName, names.DisplayName} {
+		if name == "" {
+			continue
+		}
+
+		if err := nameScreener.Screen(name); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,62 @@
+package platform_exercise
+
+import (
+	"os"
+	"strconv"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+const defaultPasswordHistoryDepth = 3
+
+// passwordHistoryDepth is the number of previous passwords, not counting the
+// current one, that a user may not switch back to. Each remembered password
+// costs a bcrypt comparison on update, so keep it small.
+func passwordHistoryDepth() int {
+	depth, err := strconv.Atoi(os.Getenv("passwordHistoryDepth"))
+	if err != nil || depth < 0 {
+		return defaultPasswordHistoryDepth
+	}
+	return depth
+}
+
+// isReusedPassword reports whether newPassword is the current password or
+// one of those remembered before it. The history cannot be skipped, so a
+// failure to read it is returned rather than treated as no match.
+func isReusedPassword(db *gorm.DB, userID string, oldPassword string, newPassword string) (bool, error) {
+	if newPassword == oldPassword {
+		return true, nil
+	}
+
+	depth := passwordHistoryDepth()
+	if depth == 0 {
+		return false, nil
+	}
+
+	var history []PasswordHistory
+	if err := db.Where("user_id = ?", userID).Order("created_at DESC").Limit(depth).Find(&history).Error; err != nil {
+		return false, err
+	}
+
+	for _, previous := range history {
+		if bcrypt.CompareHashAndPassword([]byte(previous.Password), []byte(newPassword)) == nil {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func recordPasswordHistory(tx *gorm.DB, userID string, hash string) error {
+	if err := tx.Create(&PasswordHistory{UserID: userID, Password: hash}).Error; err != nil {
+		return err
+	}
+
+	return tx.Exec(
+		`DELETE FROM password_history WHERE user_id = ? AND id NOT IN (
+			SELECT id FROM password_history WHERE user_id = ? ORDER BY created_at DESC LIMIT ?
+		)`,
+		userID, userID, passwordHistoryDepth(),
+	).Error
+}
@@ -0,0 +1,53 @@
+package platform_exercise
+
+import (
+	"os"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_passwordHistoryDepth(t *testing.T) {
+	cases := []struct {
+		name     string
+		env      string
+		expected int
+	}{
+		{
+			name:     "defaults when unset",
+			env:      "",
+			expected: defaultPasswordHistoryDepth,
+		},
+		{
+			name:     "uses configured depth",
+			env:      "7",
+			expected: 7,
+		},
+		{
+			name:     "allows disabling history",
+			env:      "0",
+			expected: 0,
+		},
+		{
+			name:     "defaults when negative",
+			env:      "-2",
+			expected: defaultPasswordHistoryDepth,
+		},
+		{
+			name:     "defaults when not a number",
+			env:      "lots",
+			expected: defaultPasswordHistoryDepth,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			os.Setenv("passwordHistoryDepth", c.env)
+			defer os.Unsetenv("passwordHistoryDepth")
+
+			if diff := cmp.Diff(c.expected, passwordHistoryDepth()); diff != "" {
+				t.Errorf("\nUnexpected depth (-want, +got)\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+package platform_exercise
+
+import (
+	"github.com/campallison/platform-exercise/utils"
+	"gorm.io/gorm"
+)
+
+const (
+	permReadUsers          = "users:read"
+	permListUsers          = "users:list"
+	permWriteUsers         = "users:write"
+	permDeleteUsers        = "users:delete"
+	permManageRoles        = "roles:manage"
+	permManageEmailDomains = "email-domains:manage"
+	permReadAudit          = "audit:read"
+	permReadLoginBlocks    = "login-blocks:read"
+	permManageIPRules      = "ip-rules:manage"
+	permValidateEmails     = "emails:validate"
+)
+
+func userRolesAndPermissions(db *gorm.DB, userID string) ([]string, []string, error) {
+	roles := []string{}
+	if err := db.Model(&UserRole{}).Where("user_id = ?", userID).Order("role").Pluck("role", &roles).Error; err != nil {
+		return nil, nil, err
+	}
+
+	permissions := []string{}
+	if err := db.Table("role_permissions").
+		Joins("JOIN user_roles ON user_roles.role = role_permissions.role").
+		Where("user_roles.user_id = ?", userID).
+		Distinct().
+		Order("role_permissions.permission").
+		Pluck("role_permissions.permission", &permissions).Error; err != nil {
+		return nil, nil, err
+	}
+
+	return roles, permissions, nil
+}
+
+func GetUserRoles(req GetUserRolesRequest) (UserRolesResponse, error) {
+	db := Init()
+
+	if err := db.Where("id = ?", req.ID).First(&User{}).Error; err != nil {
+		return UserRolesResponse{}, utils.UserNotFoundError(req.ID)
+	}
+
+	roles, permissions, err := userRolesAndPermissions(db, req.ID)
+	if err != nil {
+		return UserRolesResponse{}, utils.SaveRoleError(req.ID)
+	}
+
+	return UserRolesResponse{UserID: req.ID, Roles: roles, Permissions: permissions}, nil
+}
+
+// AssignRole grants a role to a user. Permissions are read afresh on every
+// request, so the change applies to tokens the user already holds.
+func AssignRole(req UserRoleRequest) (UserRolesResponse, error) {
+	db := Init()
+
+	if err := db.Where("id = ?", req.UserID).First(&User{}).Error; err != nil {
+		return UserRolesResponse{}, utils.UserNotFoundError(req.UserID)
+	}
+
+	if err := db.Where("name = ?", req.Role).First(&Role{}).Error; err != nil {
+		return UserRolesResponse{}, utils.RoleNotFoundError(req.Role)
+	}
+
+	if err := db.Save(&UserRole{UserID: req.UserID, Role: req.Role}).Error; err != nil {
+		return UserRolesResponse{}, utils.SaveRoleError(req.UserID)
+	}
+
+	return GetUserRoles(GetUserRolesRequest{ID: req.UserID})
+}
+
+func RevokeRole(req UserRoleRequest) (UserRolesResponse, error) {
+	db := Init()
+
+	result := db.Where("user_id = ? AND role = ?", req.UserID, req.Role).Delete(&UserRole{})
+	if result.Error != nil {
+		return UserRolesResponse{}, utils.SaveRoleError(req.UserID)
+	}
+
+	if result.RowsAffected == 0 {
+		return UserRolesResponse{}, utils.UserRoleNotFoundError(req.UserID, req.Role)
+	}
+
+	return GetUserRoles(GetUserRolesRequest{ID: req.UserID})
+}
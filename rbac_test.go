@@ -0,0 +1,176 @@
+package platform_exercise
+
+import (
+	"testing"
+
+	"github.com/campallison/platform-exercise/utils"
+	"github.com/google/go-cmp/cmp"
+	"gorm.io/gorm"
+)
+
+func Test_AssignRole(t *testing.T) {
+	databaseTest(t, func(database *gorm.DB) {
+		clearDatabase(database)
+		id := "6f1c2d3e-4b5a-4c6d-8e7f-9a0b1c2d3e4f"
+
+		cases := []struct {
+			name     string
+			setup    func(*gorm.DB)
+			req      UserRoleRequest
+			expected UserRolesResponse
+			err      error
+		}{
+			{
+				name:     "returns an error if the user does not exist",
+				req:      UserRoleRequest{UserID: id, Role: "support"},
+				expected: UserRolesResponse{},
+				err:      utils.UserNotFoundError(id),
+			},
+			{
+				name: "returns an error if the role does not exist",
+				setup: func(db *gorm.DB) {
+					db.Save(&User{ID: id, Name: "Freddie Tavares", Email: "freddie@fender.com"})
+				},
+				req:      UserRoleRequest{UserID: id, Role: "wizard"},
+				expected: UserRolesResponse{},
+				err:      utils.RoleNotFoundError("wizard"),
+			},
+			{
+				name: "grants the role and its permissions",
+				setup: func(db *gorm.DB) {
+					db.Save(&User{ID: id, Name: "Freddie Tavares", Email: "freddie@fender.com"})
+				},
+				req: UserRoleRequest{UserID: id, Role: "support"},
+				expected: UserRolesResponse{
+					UserID:      id,
+					Roles:       []string{"support"},
+					Permissions: []string{permReadUsers, permWriteUsers},
+				},
+			},
+			{
+				name: "assigning a role twice is harmless",
+				setup: func(db *gorm.DB) {
+					db.Save(&User{ID: id, Name: "Freddie Tavares", Email: "freddie@fender.com"})
+					db.Save(&UserRole{UserID: id, Role: "support"})
+				},
+				req: UserRoleRequest{UserID: id, Role: "support"},
+				expected: UserRolesResponse{
+					UserID:      id,
+					Roles:       []string{"support"},
+					Permissions: []string{permReadUsers, permWriteUsers},
+				},
+			},
+		}
+
+		for _, c := range cases {
+			t.Run(c.name, func(t *testing.T) {
+				clearDatabase(database)
+				if c.setup != nil {
+					c.setup(database)
+				}
+
+				res, err := AssignRole(c.req)
+				utils.AssertErrorsEqual(t, c.err, err)
+
+				if diff := cmp.Diff(c.expected, res); diff != "" {
+					t.Errorf("\nUnexpected roles (-want, +got)\n%s", diff)
+				}
+			})
+		}
+	})
+}
+
+func Test_RevokeRole(t *testing.T) {
+	databaseTest(t, func(database *gorm.DB) {
+		clearDatabase(database)
+		id := "6f1c2d3e-4b5a-4c6d-8e7f-9a0b1c2d3e4f"
+
+		cases := []struct {
+			name     string
+			setup    func(*gorm.DB)
+			req      UserRoleRequest
+			expected UserRolesResponse
+			err      error
+		}{
+			{
+				name: "returns an error if the user does not have the role",
+				setup: func(db *gorm.DB) {
+					db.Save(&User{ID: id, Name: "Freddie Tavares", Email: "freddie@fender.com"})
+				},
+				req:      UserRoleRequest{UserID: id, Role: "admin"},
+				expected: UserRolesResponse{},
+				err:      utils.UserRoleNotFoundError(id, "admin"),
+			},
+			{
+				name: "revokes the role and the permissions only it granted",
+				setup: func(db *gorm.DB) {
+					db.Save(&User{ID: id, Name: "Freddie Tavares", Email: "freddie@fender.com"})
+					db.Save(&UserRole{UserID: id, Role: "support"})
+					db.Save(&UserRole{UserID: id, Role: "admin"})
+				},
+				req: UserRoleRequest{UserID: id, Role: "admin"},
+				expected: UserRolesResponse{
+					UserID:      id,
+					Roles:       []string{"support"},
+					Permissions: []string{permReadUsers, permWriteUsers},
+				},
+			},
+		}
+
+		for _, c := range cases {
+			t.Run(c.name, func(t *testing.T) {
+				clearDatabase(database)
+				if c.setup != nil {
+					c.setup(database)
+				}
+
+				res, err := RevokeRole(c.req)
+				utils.AssertErrorsEqual(t, c.err, err)
+
+				if diff := cmp.Diff(c.expected, res); diff != "" {
+					t.Errorf("\nUnexpected roles (-want, +got)\n%s", diff)
+				}
+			})
+		}
+	})
+}
+
+func Test_RevokeRole_issuedToken(t *testing.T) {
+	databaseTest(t, func(database *gorm.DB) {
+		clearDatabase(database)
+
+		user := User{Name: "Freddie Tavares", Email: "freddie@fender.com"}
+		database.Save(&user)
+		database.Save(&UserRole{UserID: user.ID, Role: "admin"})
+
+		token, err := issueAccessToken(database, user)
+		utils.AssertErrorsEqual(t, nil, err)
+
+		_, err = RequirePermission("Bearer "+token.AccessToken, "", "", permDeleteUsers)
+		utils.AssertErrorsEqual(t, nil, err)
+
+		_, err = RevokeRole(UserRoleRequest{UserID: user.ID, Role: "admin"})
+		utils.AssertErrorsEqual(t, nil, err)
+
+		_, err = RequirePermission("Bearer "+token.AccessToken, "", "", permDeleteUsers)
+		utils.AssertErrorsEqual(t, utils.ForbiddenError(permDeleteUsers), err)
+	})
+}
+
+func Test_DeleteUser_issuedToken(t *testing.T) {
+	databaseTest(t, func(database *gorm.DB) {
+		clearDatabase(database)
+
+		user := User{Name: "Freddie Tavares", Email: "freddie@fender.com"}
+		database.Save(&user)
+		database.Save(&UserRole{UserID: user.ID, Role: "admin"})
+
+		token, err := issueAccessToken(database, user)
+		utils.AssertErrorsEqual(t, nil, err)
+
+		database.Delete(&user)
+
+		_, err = RequirePermission("Bearer "+token.AccessToken, "", "", permDeleteUsers)
+		utils.AssertErrorsEqual(t, utils.InvalidTokenError(), err)
+	})
+}
@@ -0,0 +1,110 @@
+package platform_exercise
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/campallison/platform-exercise/utils"
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+const (
+	defaultAccountRestoreWindow = 30 * 24 * time.Hour
+	restoreTokenPurpose         = "restore"
+)
+
+func accountRestoreWindow() time.Duration {
+	window, err := time.ParseDuration(os.Getenv("accountRestoreWindow"))
+	if err != nil || window <= 0 {
+		return defaultAccountRestoreWindow
+	}
+	return window
+}
+
+// sendRestoreMail emails a deleted user a link to undo the deletion. The token
+// names the deletion it was issued for, so it stops working once the account
+// is restored, even if the account is deleted again later.
+func sendRestoreMail(user User) {
+	expiry := user.DeletedAt.Time.Add(accountRestoreWindow())
+
+	token, err := signActionToken(restoreTokenPurpose, user.ID, expiry, jwt.MapClaims{
+		"DeletedAt": user.DeletedAt.Time.UTC().Format(time.RFC3339Nano),
+	})
+	if err != nil {
+		log.Printf("\nCould not sign restore token for user %s\n%v\n", user.ID, err)
+		return
+	}
+
+	link := fmt.Sprintf(
+		"%s?id=%s&token=%s",
+		os.Getenv("restoreAccountURL"), url.QueryEscape(user.ID), url.QueryEscape(token),
+	)
+
+	sendMail(utils.Mail{
+		To:      user.Email,
+		Subject: "Your account has been deleted",
+		Body: fmt.Sprintf(
+			"Your account was deleted. If this was a mistake, you can restore it until %s:\n\n%s\n\nAfter that it will be removed permanently.",
+			expiry.UTC().Format("January 2, 2006"), link,
+		),
+	})
+}
+
+// RestoreUser undoes a soft delete within the restore window. Without a
+// token the caller must already have been authorized by the handler; with
+// one, the token must come from the restore email for this deletion.
+func RestoreUser(req RestoreUserRequest) (User, error) {
+	db := Init()
+
+	var user User
+	if err := db.Unscoped().Where("id = ?", req.ID).First(&user).Error; err != nil {
+		return User{}, utils.UserNotFoundError(req.ID)
+	}
+
+	if !user.DeletedAt.Valid {
+		return User{}, utils.UserNotDeletedError(req.ID)
+	}
+
+	if time.Since(user.DeletedAt.Time) > accountRestoreWindow() {
+		return User{}, utils.RestoreWindowExpiredError(req.ID)
+	}
+
+	if req.Token != "" {
+		claims, err := parseActionToken(req.Token, restoreTokenPurpose)
+		if err != nil {
+			return User{}, err
+		}
+
+		if claims["Id"] != user.ID || claims["DeletedAt"] != user.DeletedAt.Time.UTC().Format(time.RFC3339Nano) {
+			return User{}, utils.InvalidTokenError()
+		}
+	}
+
+	if err := db.Unscoped().Model(&User{}).Where("id = ?", user.ID).Update("deleted_at", nil).Error; err != nil {
+		if isUniqueViolation(err) {
+			return User{}, utils.EmailAlreadyRegisteredError(user.Email)
+		}
+		return User{}, utils.SaveUserToDBError(user.Email)
+	}
+
+	var restored User
+	db.Where("id = ?", user.ID).First(&restored)
+
+	return restored, nil
+}
+
+// PurgeDeletedUsers permanently removes users deleted before the cutoff,
+// along with their rows in dependent tables, so their emails can be used again.
+func PurgeDeletedUsers(cutoff time.Time) (int64, error) {
+	db := Init()
+
+	result := db.Unscoped().Where("deleted_at IS NOT NULL AND deleted_at < ?", cutoff).Delete(&User{})
+	if result.Error != nil {
+		return 0, utils.PurgeUsersError(result.Error)
+	}
+
+	return result.RowsAffected, nil
+}
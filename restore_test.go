@@ -0,0 +1,195 @@
+package platform_exercise
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/campallison/platform-exercise/utils"
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"gorm.io/gorm"
+)
+
+func Test_parseActionToken(t *testing.T) {
+	id := "0d1c2b3a-4e5f-4a6b-8c7d-9e0f1a2b3c4d"
+	valid, _ := signActionToken(restoreTokenPurpose, id, time.Now().Add(time.Hour), jwt.MapClaims{"Extra": "value"})
+	expired, _ := signActionToken(restoreTokenPurpose, id, time.Now().Add(-time.Hour), nil)
+	otherPurpose, _ := signActionToken("other", id, time.Now().Add(time.Hour), nil)
+	accessToken := utils.CreateTestToken(id, "leo@fender.com")
+
+	cases := []struct {
+		name  string
+		token string
+		err   error
+	}{
+		{name: "valid token", token: valid},
+		{name: "expired token", token: expired, err: utils.InvalidTokenError()},
+		{name: "token for another purpose", token: otherPurpose, err: utils.InvalidTokenError()},
+		{name: "access token", token: accessToken, err: utils.InvalidTokenError()},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			claims, err := parseActionToken(c.token, restoreTokenPurpose)
+			utils.AssertErrorsEqual(t, c.err, err)
+
+			if c.err == nil {
+				if diff := cmp.Diff("value", claims["Extra"]); diff != "" {
+					t.Errorf("\nUnexpected claim (-want, +got)\n%s", diff)
+				}
+			}
+		})
+	}
+}
+
+func Test_RestoreUser(t *testing.T) {
+	databaseTest(t, func(database *gorm.DB) {
+		clearDatabase(database)
+		id := "0d1c2b3a-4e5f-4a6b-8c7d-9e0f1a2b3c4d"
+
+		deleteUser := func(db *gorm.DB, deletedAt time.Time) User {
+			db.Save(&User{ID: id, Name: "Leo Fender", Email: "leo@fender.com"})
+			db.Model(&User{}).Where("id = ?", id).Update("deleted_at", deletedAt)
+
+			var deleted User
+			db.Unscoped().Where("id = ?", id).First(&deleted)
+			return deleted
+		}
+
+		restoreToken := func(user User) string {
+			token, _ := signActionToken(restoreTokenPurpose, user.ID, time.Now().Add(time.Hour), jwt.MapClaims{
+				"DeletedAt": user.DeletedAt.Time.UTC().Format(time.RFC3339Nano),
+			})
+			return token
+		}
+
+		cases := []struct {
+			name     string
+			setup    func(*gorm.DB) RestoreUserRequest
+			expected User
+			err      error
+		}{
+			{
+				name: "restores a recently deleted user",
+				setup: func(db *gorm.DB) RestoreUserRequest {
+					deleteUser(db, time.Now().Add(-time.Hour))
+					return RestoreUserRequest{ID: id}
+				},
+				expected: User{ID: id, Name: "Leo Fender", Email: "leo@fender.com", CanonicalEmail: "leo@fender.com"},
+			},
+			{
+				name: "restores with the token from the restore email",
+				setup: func(db *gorm.DB) RestoreUserRequest {
+					return RestoreUserRequest{ID: id, Token: restoreToken(deleteUser(db, time.Now().Add(-time.Hour)))}
+				},
+				expected: User{ID: id, Name: "Leo Fender", Email: "leo@fender.com", CanonicalEmail: "leo@fender.com"},
+			},
+			{
+				name: "rejects a token issued for an earlier deletion",
+				setup: func(db *gorm.DB) RestoreUserRequest {
+					earlier := deleteUser(db, time.Now().Add(-2*time.Hour))
+					deleteUser(db, time.Now().Add(-time.Hour))
+					return RestoreUserRequest{ID: id, Token: restoreToken(earlier)}
+				},
+				err: utils.InvalidTokenError(),
+			},
+			{
+				name: "refuses users deleted before the window",
+				setup: func(db *gorm.DB) RestoreUserRequest {
+					deleteUser(db, time.Now().Add(-defaultAccountRestoreWindow-time.Hour))
+					return RestoreUserRequest{ID: id}
+				},
+				err: utils.RestoreWindowExpiredError(id),
+			},
+			{
+				name: "refuses while another user has the email",
+				setup: func(db *gorm.DB) RestoreUserRequest {
+					deleteUser(db, time.Now().Add(-time.Hour))
+					db.Save(&User{ID: "00000000-0000-4000-8000-000000000001", Name: "Other Name", Email: "leo@fender.com"})
+					return RestoreUserRequest{ID: id}
+				},
+				err: utils.EmailAlreadyRegisteredError("leo@fender.com"),
+			},
+			{
+				name: "refuses users who are not deleted",
+				setup: func(db *gorm.DB) RestoreUserRequest {
+					db.Save(&User{ID: id, Name: "Leo Fender", Email: "leo@fender.com"})
+					return RestoreUserRequest{ID: id}
+				},
+				err: utils.UserNotDeletedError(id),
+			},
+		}
+
+		for _, c := range cases {
+			t.Run(c.name, func(t *testing.T) {
+				clearDatabase(database)
+
+				res, err := RestoreUser(c.setup(database))
+				utils.AssertErrorsEqual(t, c.err, err)
+
+				if diff := cmp.Diff(
+					c.expected,
+					res,
+					cmpopts.IgnoreFields(User{}, "CreatedAt", "UpdatedAt", "DeletedAt", "Password"),
+				); diff != "" {
+					t.Errorf("\nUnexpected user (-want, +got)\n%s", diff)
+				}
+			})
+		}
+	})
+}
+
+func Test_DeleteUser_sendsRestoreMail(t *testing.T) {
+	databaseTest(t, func(database *gorm.DB) {
+		clearDatabase(database)
+		id := "0d1c2b3a-4e5f-4a6b-8c7d-9e0f1a2b3c4d"
+		database.Save(&User{ID: id, Name: "Leo Fender", Email: "leo@fender.com"})
+
+		os.Setenv("restoreAccountURL", "https://fender.com/restore")
+		defer os.Unsetenv("restoreAccountURL")
+
+		mailbox := withTestMailer(t)
+
+		if _, err := DeleteUser(DeleteUserRequest{ID: id}); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(mailbox.Sent) != 1 {
+			t.Fatalf("expected one restore mail, got %d", len(mailbox.Sent))
+		}
+
+		mail := mailbox.Sent[0]
+		if mail.To != "leo@fender.com" || !strings.Contains(mail.Body, "https://fender.com/restore?id="+id+"&token=") {
+			t.Errorf("unexpected restore mail %+v", mail)
+		}
+	})
+}
+
+func Test_PurgeDeletedUsers(t *testing.T) {
+	databaseTest(t, func(database *gorm.DB) {
+		clearDatabase(database)
+
+		now := time.Now()
+		database.Save(&User{ID: "00000000-0000-4000-8000-000000000001", Name: "Leo Fender", Email: "leo@fender.com"})
+		database.Save(&User{ID: "00000000-0000-4000-8000-000000000002", Name: "George Fullerton", Email: "george@fender.com"})
+		database.Save(&User{ID: "00000000-0000-4000-8000-000000000003", Name: "Freddie Tavares", Email: "freddie@fender.com"})
+		database.Model(&User{}).Where("id = ?", "00000000-0000-4000-8000-000000000002").Update("deleted_at", now.Add(-time.Hour))
+		database.Model(&User{}).Where("id = ?", "00000000-0000-4000-8000-000000000003").Update("deleted_at", now.Add(-48*time.Hour))
+
+		purged, err := PurgeDeletedUsers(now.Add(-24 * time.Hour))
+		utils.AssertErrorsEqual(t, nil, err)
+
+		if diff := cmp.Diff(int64(1), purged); diff != "" {
+			t.Errorf("\nUnexpected purge count (-want, +got)\n%s", diff)
+		}
+
+		var remaining int64
+		database.Unscoped().Model(&User{}).Count(&remaining)
+		if diff := cmp.Diff(int64(2), remaining); diff != "" {
+			t.Errorf("\nUnexpected remaining users (-want, +got)\n%s", diff)
+		}
+	})
+}
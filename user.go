@@ -1,15 +1,37 @@
 package platform_exercise
 
 import (
-	"regexp"
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/campallison/platform-exercise/utils"
 	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
 )
 
 const (
 	insecurePasswordThreshold = 2
 	bcryptGenerationCost      = 14
+	emailDeliverabilityTTL    = time.Hour
+
+	emailCodeUnparseable   = "unparseable"
+	emailCodeAliased       = "aliased"
+	emailCodeProhibited    = "prohibited_domain"
+	emailCodeUndeliverable = "undeliverable"
+
+	uniqueViolation = "23505"
+)
+
+var (
+	emailDeliverability = utils.NewDeliverabilityChecker(net.DefaultResolver, emailDeliverabilityTTL)
+	emailConfigOnce     sync.Once
+	emailSuggester      = utils.NewDomainSuggester(utils.DefaultPopularDomains(), utils.DefaultPopularTLDs())
 )
 
 func CheckPasswordStrength(password string) (err error) {
@@ -28,45 +50,146 @@ func HashPassword(password string) (string, error) {
 	return hash, nil
 }
 
-func isValidName(name string) bool {
-	regex := `^[^0-9_!¡?÷?¿/\\+=@#$%ˆ&*(){}|~<>;:[\]]{2,}$`
-	res, _ := regexp.MatchString(regex, name)
-	return res
+func loadEmailConfig() {
+	if config := os.Getenv("emailProviderRules"); config != "" {
+		var rules map[string]utils.ProviderRule
+		if err := json.Unmarshal([]byte(config), &rules); err != nil {
+			log.Printf("\nCould not parse emailProviderRules, keeping defaults\n%v\n", err)
+		} else {
+			utils.SetProviderRules(rules)
+		}
+	}
+
+	fallback, ok := utils.ParseAliasPolicy(os.Getenv("emailAliasPolicy"))
+	if !ok {
+		fallback = utils.AliasReject
+	}
+
+	domains, err := utils.ParseAliasPolicies(os.Getenv("emailAliasPolicyDomains"))
+	if err != nil {
+		log.Printf("\nCould not parse emailAliasPolicyDomains, ignoring overrides\n%v\n", err)
+		domains = map[string]utils.AliasPolicy{}
+	}
+
+	utils.SetAliasPolicies(fallback, domains)
+
+	suggestionDomains := utils.DefaultPopularDomains()
+	if config := os.Getenv("emailSuggestionDomains"); config != "" {
+		suggestionDomains = strings.Split(config, ",")
+	}
+
+	suggestionTLDs := utils.DefaultPopularTLDs()
+	if config := os.Getenv("emailSuggestionTLDs"); config != "" {
+		suggestionTLDs = strings.Split(config, ",")
+	}
+
+	emailSuggester = utils.NewDomainSuggester(suggestionDomains, suggestionTLDs)
+}
+
+func canonicalEmail(email string) string {
+	emailConfigOnce.Do(loadEmailConfig)
+
+	parsedEmail, err := utils.ParseEmail(email)
+	if err != nil {
+		return strings.ToLower(strings.TrimSpace(email))
+	}
+
+	return utils.CanonicalizeEmail(parsedEmail)
+}
+
+// isUniqueViolation reports whether err is Postgres rejecting a duplicate
+// value for a unique index. Only live users are indexed, so the email of a
+// deleted user can be registered again.
+func isUniqueViolation(err error) bool {
+	var pgErr interface{ SQLState() string }
+	return errors.As(err, &pgErr) && pgErr.SQLState() == uniqueViolation
+}
+
+func checkEmailDeliverability() bool {
+	return os.Getenv("checkEmailDeliverability") == "true"
 }
 
-func ValidateEmail(req ValidateEmailRequest) (bool, error) {
+func ValidateEmail(req ValidateEmailRequest) (ValidateEmailResponse, error) {
+	response := ValidateEmailResponse{Email: req.Email}
+
 	parsedEmail, err := utils.ParseEmail(req.Email)
 	if err != nil {
-		return false, utils.CouldNotParseEmailError(req.Email)
+		response.Code = emailCodeUnparseable
+		return response, utils.CouldNotParseEmailError(req.Email)
+	}
+
+	emailConfigOnce.Do(loadEmailConfig)
+	if domain := emailSuggester.Suggest(parsedEmail.Domain); domain != "" {
+		response.Suggestion = parsedEmail.LocalPart + "@" + domain
+	}
+
+	alias := utils.DecideAlias(parsedEmail)
+	if alias.Aliased {
+		response.AliasDecision = string(alias.Policy)
+		response.AliasReason = alias.Reason
 	}
 
-	if utils.IsAliasedEmail(parsedEmail.LocalPart) {
-		return false, utils.AliasedEmailError(req.Email)
+	if !alias.Allowed {
+		response.Code = emailCodeAliased
+		return response, utils.AliasedEmailError(req.Email)
 	}
 
+	refreshEmailDomains()
 	if utils.IsKnownSpamEmail(parsedEmail) {
-		return false, utils.ProhibitedEmailError(req.Email)
+		response.Code = emailCodeProhibited
+		return response, utils.ProhibitedEmailError(req.Email)
+	}
+
+	if checkEmailDeliverability() {
+		deliverability := emailDeliverability.Check(parsedEmail.Domain)
+		if !deliverability.Deliverable {
+			response.Code = emailCodeUndeliverable
+			response.Reason = deliverability.Reason
+			return response, utils.UndeliverableEmailError(req.Email)
+		}
 	}
 
-	return true, nil
+	response.IsValid = true
+	return response, nil
 }
 
 func CreateUser(req CreateUserRequest) (User, error) {
 	db := Init()
 	var user User
 
-	if !isValidName(req.Name) {
+	if req.Name == "" {
+		req.Name = strings.TrimSpace(req.GivenName + " " + req.FamilyName)
+	}
+
+	names, err := normalizeNames(userNames{
+		Name:        req.Name,
+		GivenName:   req.GivenName,
+		FamilyName:  req.FamilyName,
+		DisplayName: req.DisplayName,
+	})
+	if err != nil {
+		return User{}, err
+	}
+
+	if err := screenNames(names); err != nil {
+		return User{}, err
+	}
+
+	if names.Name == "" {
 		return User{}, utils.InvalidNameError(req.Name)
 	}
 
-	user.Name = req.Name
+	user.Name = names.Name
+	user.GivenName = names.GivenName
+	user.FamilyName = names.FamilyName
+	user.DisplayName = names.DisplayName
 
-	isValidEmail, err := ValidateEmail(ValidateEmailRequest{Email: req.Email})
+	validation, err := ValidateEmail(ValidateEmailRequest{Email: req.Email})
 	if err != nil {
 		return User{}, err
 	}
 
-	if isValidEmail {
+	if validation.IsValid {
 		user.Email = req.Email
 	}
 
@@ -82,12 +205,56 @@ func CreateUser(req CreateUserRequest) (User, error) {
 	user.Password = hashedPW
 
 	if err := db.Save(&user).Error; err != nil {
+		if isUniqueViolation(err) {
+			return duplicateSignup(db, user, req.Meta)
+		}
 		return User{}, utils.SaveUserToDBError(user.Email)
 	}
 
+	recordAudit(auditUserCreated, user.ID, req.Meta)
+	sendWelcomeMail(user)
+
 	return user, nil
 }
 
+// duplicateSignup answers a signup for an email that already has an account
+// exactly as if it had succeeded, so the endpoint cannot be used to find out
+// who is registered. The returned user is never saved; its ID is a fresh UUID
+// nobody can sign in to. The owner of the account is told instead, in place
+// of the welcome mail a new account gets.
+func duplicateSignup(db *gorm.DB, user User, meta RequestMeta) (User, error) {
+	if err := db.Raw("SELECT uuid_generate_v4()").Scan(&user.ID).Error; err != nil {
+		return User{}, utils.SaveUserToDBError(user.Email)
+	}
+
+	var existing User
+	if err := db.Where("canonical_email = ?", canonicalEmail(user.Email)).First(&existing).Error; err != nil {
+		log.Printf("\nCould not find the account behind a duplicate signup\n%v\n", err)
+		return user, nil
+	}
+
+	recordAudit(auditDuplicateSignup, existing.ID, meta)
+	sendDuplicateSignupMail(existing)
+
+	return user, nil
+}
+
+func sendWelcomeMail(user User) {
+	sendMail(utils.Mail{
+		To:      user.Email,
+		Subject: "Your account has been created",
+		Body:    "Welcome! Your account is ready, and you can sign in with this email address and the password you chose.",
+	})
+}
+
+func sendDuplicateSignupMail(user User) {
+	sendMail(utils.Mail{
+		To:      user.Email,
+		Subject: "Someone tried to sign up with your email",
+		Body:    "Someone tried to create an account with this email address, but it already has one.\n\nIf this was you, sign in with your existing password. If not, you can ignore this message; your account has not changed.",
+	})
+}
+
 func GetUser(req GetUserRequest) (User, error) {
 	db := Init()
 	var user User
@@ -106,6 +273,9 @@ func UpdateUser(req UpdateUserRequest) (User, error) {
 
 	if req.ID != "" &&
 		req.Name == "" &&
+		req.GivenName == "" &&
+		req.FamilyName == "" &&
+		req.DisplayName == "" &&
 		req.Email == "" &&
 		req.NewPassword == "" &&
 		req.OldPassword == "" {
@@ -117,8 +287,18 @@ func UpdateUser(req UpdateUserRequest) (User, error) {
 		return existing, utils.UserNotFoundError(req.ID)
 	}
 
-	if req.Name != "" && !isValidName(req.Name) {
-		return User{}, utils.InvalidNameError(req.Name)
+	names, err := normalizeNames(userNames{
+		Name:        req.Name,
+		GivenName:   req.GivenName,
+		FamilyName:  req.FamilyName,
+		DisplayName: req.DisplayName,
+	})
+	if err != nil {
+		return User{}, err
+	}
+
+	if err := screenNames(names); err != nil {
+		return User{}, err
 	}
 
 	var hashedPW string
@@ -129,6 +309,13 @@ func UpdateUser(req UpdateUserRequest) (User, error) {
 			err := bcrypt.CompareHashAndPassword([]byte(existing.Password), []byte(req.OldPassword))
 			if err != nil {
 				return User{}, utils.UnauthorizedError()
+			}
+
+			reused, err := isReusedPassword(db, existing.ID, req.OldPassword, req.NewPassword)
+			if err != nil {
+				return User{}, utils.SaveUserToDBError(existing.Email)
+			} else if reused {
+				return User{}, utils.ReusedPasswordError(passwordHistoryDepth())
 			} else {
 				hashedPW, err = HashPassword(req.NewPassword)
 				if err != nil {
@@ -138,27 +325,86 @@ func UpdateUser(req UpdateUserRequest) (User, error) {
 		}
 	}
 
-	if req.Email != "" {
-		if ok, err := ValidateEmail(ValidateEmailRequest{Email: req.Email}); !ok {
+	changingEmail := req.Email != "" && req.Email != existing.Email
+	if changingEmail {
+		if validation, err := ValidateEmail(ValidateEmailRequest{Email: req.Email}); !validation.IsValid {
 			return User{}, err
 		}
+
+		// A bearer token alone must not be enough to take over the account.
+		if req.OldPassword == "" {
+			return User{}, utils.ReauthenticationRequiredError()
+		}
+
+		if err := bcrypt.CompareHashAndPassword([]byte(existing.Password), []byte(req.OldPassword)); err != nil {
+			return User{}, utils.UnauthorizedError()
+		}
+
+		if isEmailRegistered(db, req.Email, existing.ID) {
+			return User{}, utils.EmailAlreadyRegisteredError(req.Email)
+		}
 	}
 
 	fields := map[string]interface{}{}
 
-	if req.Name != "" {
-		fields["name"] = req.Name
+	if names.Name != "" {
+		fields["name"] = names.Name
+	}
+
+	if names.GivenName != "" {
+		fields["given_name"] = names.GivenName
+	}
+
+	if names.FamilyName != "" {
+		fields["family_name"] = names.FamilyName
+	}
+
+	if names.DisplayName != "" {
+		fields["display_name"] = names.DisplayName
 	}
 
 	if hashedPW != "" {
 		fields["password"] = hashedPW
 	}
 
-	if req.Email != "" {
-		fields["email"] = req.Email
+	var mails []utils.Mail
+	if err := db.Transaction(func(tx *gorm.DB) error {
+		if len(fields) > 0 {
+			if err := tx.Model(&existing).Where(`id = ?`, req.ID).Updates(fields).Error; err != nil {
+				return err
+			}
+
+			if hashedPW != "" && passwordHistoryDepth() > 0 {
+				if err := recordPasswordHistory(tx, existing.ID, existing.Password); err != nil {
+					return err
+				}
+			}
+		}
+
+		// The new email only takes effect once confirmed from that address.
+		if changingEmail {
+			var err error
+			mails, err = requestEmailChange(tx, existing, req.Email)
+			return err
+		}
+
+		return nil
+	}); err != nil {
+		return User{}, utils.SaveUserToDBError(existing.Email)
 	}
 
-	db.Model(&existing).Where(`id = ?`, req.ID).Updates(fields)
+	for _, mail := range mails {
+		sendMail(mail)
+	}
+
+	changedFields := make([]string, 0, len(fields)+1)
+	for field := range fields {
+		changedFields = append(changedFields, field)
+	}
+	if changingEmail {
+		changedFields = append(changedFields, "pending_email")
+	}
+	recordAudit(auditUserUpdated, existing.ID, req.Meta, changedFields...)
 
 	var updated User
 	db.Table("users").Where("id = ?", req.ID).First(&updated)
@@ -195,5 +441,8 @@ func DeleteUser(req DeleteUserRequest) (string, error) {
 		return req.ID, utils.UserNotFoundError(user.ID)
 	}
 
+	recordAudit(auditUserDeleted, user.ID, req.Meta)
+	sendRestoreMail(user)
+
 	return req.ID, nil
 }
@@ -0,0 +1,266 @@
+package platform_exercise
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/campallison/platform-exercise/utils"
+	jwt "github.com/dgrijalva/jwt-go"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultExportInlineRowLimit = 500
+	defaultUserExportRetention  = 7 * 24 * time.Hour
+
+	userExportPending = "pending"
+	userExportReady   = "ready"
+)
+
+func userExportRetention() time.Duration {
+	retention, err := time.ParseDuration(os.Getenv("userExportRetention"))
+	if err != nil || retention <= 0 {
+		return defaultUserExportRetention
+	}
+	return retention
+}
+
+func userExportURL(userID string, exportID string) string {
+	return fmt.Sprintf("%s/user/%s/export/%s", os.Getenv("apiBaseURL"), userID, exportID)
+}
+
+// exportRowCount estimates the size of a user's export from the rows it
+// would read, to decide whether it can be built within the request.
+func exportRowCount(db *gorm.DB, userID string) (int64, error) {
+	var total int64
+	for _, model := range []interface{}{&PasswordHistory{}, &UserRole{}, &LoginAttempt{}, &InvalidToken{}, &EmailChange{}} {
+		var count int64
+		if err := db.Model(model).Where("user_id = ?", userID).Count(&count).Error; err != nil {
+			return 0, err
+		}
+		total += count
+	}
+
+	var auditEvents int64
+	if err := db.Model(&AuditEvent{}).Where("subject_id = ? OR actor_id = ?", userID, userID).Count(&auditEvents).Error; err != nil {
+		return 0, err
+	}
+
+	return total + auditEvents, nil
+}
+
+func buildUserExport(db *gorm.DB, userID string) (UserExport, error) {
+	var user User
+	if err := db.Unscoped().Where("id = ?", userID).First(&user).Error; err != nil {
+		return UserExport{}, utils.UserNotFoundError(userID)
+	}
+
+	export := UserExport{
+		GeneratedAt: time.Now().UTC(),
+		Profile: ExportedProfile{
+			ID:             user.ID,
+			Name:           user.Name,
+			GivenName:      user.GivenName,
+			FamilyName:     user.FamilyName,
+			DisplayName:    user.DisplayName,
+			Email:          user.Email,
+			CanonicalEmail: user.CanonicalEmail,
+			CreatedAt:      user.CreatedAt,
+			UpdatedAt:      user.UpdatedAt,
+		},
+		PasswordChanges: []time.Time{},
+		RevokedTokens:   []ExportedTokenRecord{},
+		LoginHistory:    []LoginHistoryEntry{},
+		AuditEvents:     []ListedAuditEvent{},
+	}
+
+	if user.DeletedAt.Valid {
+		deletedAt := user.DeletedAt.Time
+		export.Profile.DeletedAt = &deletedAt
+	}
+
+	roles, permissions, err := userRolesAndPermissions(db, userID)
+	if err != nil {
+		return UserExport{}, utils.UserExportError(userID)
+	}
+	export.Roles, export.Permissions = roles, permissions
+
+	// Only when each password was replaced is exported; the old hashes are
+	// kept to block reuse and are not the user's data to take away.
+	if err := db.Model(&PasswordHistory{}).
+		Where("user_id = ?", userID).
+		Order("created_at").
+		Pluck("created_at", &export.PasswordChanges).Error; err != nil {
+		return UserExport{}, utils.UserExportError(userID)
+	}
+
+	var invalidTokens []InvalidToken
+	if err := db.Where("user_id = ?", userID).Order("created_at").Find(&invalidTokens).Error; err != nil {
+		return UserExport{}, utils.UserExportError(userID)
+	}
+
+	for _, invalidToken := range invalidTokens {
+		if record, ok := exportedTokenRecord(invalidToken, userID); ok {
+			export.RevokedTokens = append(export.RevokedTokens, record)
+		}
+	}
+
+	var logins []LoginAttempt
+	if err := db.Where("user_id = ?", userID).Order("id").Find(&logins).Error; err != nil {
+		return UserExport{}, utils.UserExportError(userID)
+	}
+
+	for _, login := range logins {
+		export.LoginHistory = append(export.LoginHistory, loginHistoryEntry(login))
+	}
+
+	// An expired change is still stored about the user until it is replaced.
+	var changes []EmailChange
+	if err := db.Where("user_id = ?", userID).Limit(1).Find(&changes).Error; err != nil {
+		return UserExport{}, utils.UserExportError(userID)
+	}
+
+	if len(changes) > 0 {
+		export.PendingEmailChange = &ExportedEmailChange{
+			NewEmail:    changes[0].NewEmail,
+			RequestedAt: changes[0].CreatedAt,
+			ExpiresAt:   changes[0].ExpiresAt,
+		}
+	}
+
+	var auditEvents []AuditEvent
+	if err := db.Where("subject_id = ? OR actor_id = ?", userID, userID).Order("id").Find(&auditEvents).Error; err != nil {
+		return UserExport{}, utils.UserExportError(userID)
+	}
+
+	for _, event := range auditEvents {
+		// Where staff acted on the user, where the staff member was is theirs.
+		if event.ActorID != userID {
+			event.IP, event.UserAgent = "", ""
+		}
+		export.AuditEvents = append(export.AuditEvents, listedAuditEvent(event))
+	}
+
+	return export, nil
+}
+
+// exportedTokenRecord reads when a logged-out token of the user would have
+// expired from its claims; the signature was checked when it was logged out.
+// The Id claim is checked too, in case a row's user_id was filled in wrongly.
+func exportedTokenRecord(invalidToken InvalidToken, userID string) (ExportedTokenRecord, bool) {
+	var claims jwt.MapClaims
+	if _, _, err := new(jwt.Parser).ParseUnverified(invalidToken.Token, &claims); err != nil || claims["Id"] != userID {
+		return ExportedTokenRecord{}, false
+	}
+
+	expiresAt, _ := claims["ExpiresAt"].(string)
+	return ExportedTokenRecord{RevokedAt: invalidToken.CreatedAt, ExpiresAt: expiresAt}, true
+}
+
+// RequestUserExport returns a user's data at once when the account is small,
+// and otherwise queues an export for ProcessUserExports and returns where it
+// can be downloaded once ready.
+func RequestUserExport(req UserExportRequest) (UserExportResponse, error) {
+	db := Init()
+
+	if err := db.Where("id = ?", req.ID).First(&User{}).Error; err != nil {
+		return UserExportResponse{}, utils.UserNotFoundError(req.ID)
+	}
+
+	if !req.Async {
+		rows, err := exportRowCount(db, req.ID)
+		if err != nil {
+			return UserExportResponse{}, utils.UserExportError(req.ID)
+		}
+
+		if rows <= int64(positiveIntFromEnv("exportInlineRowLimit", defaultExportInlineRowLimit)) {
+			export, err := buildUserExport(db, req.ID)
+			if err != nil {
+				return UserExportResponse{}, err
+			}
+			return UserExportResponse{Status: userExportReady, Export: &export}, nil
+		}
+	}
+
+	var job UserExportJob
+	if err := db.Where("user_id = ? AND status = ?", req.ID, userExportPending).First(&job).Error; err != nil {
+		job = UserExportJob{UserID: req.ID, Status: userExportPending}
+		if err := db.Create(&job).Error; err != nil {
+			return UserExportResponse{}, utils.UserExportError(req.ID)
+		}
+	}
+
+	return UserExportResponse{
+		Status:      job.Status,
+		ExportID:    job.ID,
+		DownloadURL: userExportURL(req.ID, job.ID),
+	}, nil
+}
+
+func GetUserExport(req GetUserExportRequest) (UserExportJob, error) {
+	db := Init()
+
+	var job UserExportJob
+	if err := db.Where("id = ? AND user_id = ?", req.ExportID, req.ID).First(&job).Error; err != nil {
+		return UserExportJob{}, utils.UserExportNotFoundError(req.ExportID)
+	}
+
+	if job.ExpiresAt != nil && time.Now().After(*job.ExpiresAt) {
+		return UserExportJob{}, utils.UserExportNotFoundError(req.ExportID)
+	}
+
+	return job, nil
+}
+
+// ProcessUserExports builds queued exports, emails each user their download
+// link, and removes exports past their retention.
+func ProcessUserExports(now time.Time) (ProcessUserExportsResponse, error) {
+	db := Init()
+	var response ProcessUserExportsResponse
+
+	var jobs []UserExportJob
+	if err := db.Where("status = ?", userExportPending).Order("created_at").Find(&jobs).Error; err != nil {
+		return response, utils.ProcessUserExportsError(err)
+	}
+
+	for _, job := range jobs {
+		export, err := buildUserExport(db, job.UserID)
+		if err != nil {
+			log.Printf("\nCould not export user %s\n%v\n", job.UserID, err)
+			continue
+		}
+
+		archive, _ := json.Marshal(export)
+		expiresAt := now.Add(userExportRetention())
+
+		if err := db.Model(&job).Updates(map[string]interface{}{
+			"status":     userExportReady,
+			"archive":    string(archive),
+			"expires_at": expiresAt,
+		}).Error; err != nil {
+			log.Printf("\nCould not save export %s\n%v\n", job.ID, err)
+			continue
+		}
+
+		sendMail(utils.Mail{
+			To:      export.Profile.Email,
+			Subject: "Your data export is ready",
+			Body: fmt.Sprintf(
+				"The copy of your data you asked for is ready. Download it while signed in before %s:\n\n%s",
+				expiresAt.UTC().Format("January 2, 2006"), userExportURL(job.UserID, job.ID),
+			),
+		})
+		response.Processed++
+	}
+
+	result := db.Where("expires_at < ?", now).Delete(&UserExportJob{})
+	if result.Error != nil {
+		return response, utils.ProcessUserExportsError(result.Error)
+	}
+	response.Expired = result.RowsAffected
+
+	return response, nil
+}
@@ -0,0 +1,182 @@
+package platform_exercise
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/campallison/platform-exercise/utils"
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/google/go-cmp/cmp"
+	"gorm.io/gorm"
+)
+
+func Test_exportedTokenRecord(t *testing.T) {
+	id := "0d1c2b3a-4e5f-4a6b-8c7d-9e0f1a2b3c4d"
+	revokedAt := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	token, _ := jwt.NewWithClaims(jwt.SigningMethodHS512, jwt.MapClaims{
+		"Id":        id,
+		"ExpiresAt": "2021-01-01T12:00:00Z",
+	}).SignedString([]byte("secret"))
+
+	cases := []struct {
+		name     string
+		token    string
+		userID   string
+		expected ExportedTokenRecord
+		ok       bool
+	}{
+		{
+			name:     "token of the user",
+			token:    token,
+			userID:   id,
+			expected: ExportedTokenRecord{RevokedAt: revokedAt, ExpiresAt: "2021-01-01T12:00:00Z"},
+			ok:       true,
+		},
+		{
+			name:   "token of another user",
+			token:  token,
+			userID: "00000000-0000-4000-8000-000000000001",
+		},
+		{
+			name:   "malformed token",
+			token:  "not a token",
+			userID: id,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			res, ok := exportedTokenRecord(InvalidToken{CreatedAt: revokedAt, Token: c.token}, c.userID)
+
+			if diff := cmp.Diff(c.ok, ok); diff != "" {
+				t.Errorf("\nUnexpected ownership (-want, +got)\n%s", diff)
+			}
+
+			if diff := cmp.Diff(c.expected, res); diff != "" {
+				t.Errorf("\nUnexpected record (-want, +got)\n%s", diff)
+			}
+		})
+	}
+}
+
+func Test_RequestUserExport(t *testing.T) {
+	databaseTest(t, func(database *gorm.DB) {
+		clearDatabase(database)
+		id := "0d1c2b3a-4e5f-4a6b-8c7d-9e0f1a2b3c4d"
+		database.Save(&User{ID: id, Name: "Leo Fender", Email: "leo@fender.com", Password: "hash"})
+		database.Save(&UserRole{UserID: id, Role: "support"})
+		database.Save(&PasswordHistory{UserID: id, Password: "old hash"})
+
+		t.Run("returns small accounts directly", func(t *testing.T) {
+			res, err := RequestUserExport(UserExportRequest{ID: id})
+			utils.AssertErrorsEqual(t, nil, err)
+
+			if res.Status != userExportReady || res.Export == nil {
+				t.Fatalf("expected an export, got %+v", res)
+			}
+
+			if diff := cmp.Diff([]string{"support"}, res.Export.Roles); diff != "" {
+				t.Errorf("\nUnexpected roles (-want, +got)\n%s", diff)
+			}
+
+			if diff := cmp.Diff(1, len(res.Export.PasswordChanges)); diff != "" {
+				t.Errorf("\nUnexpected password changes (-want, +got)\n%s", diff)
+			}
+
+			archive, _ := json.Marshal(res.Export)
+			if strings.Contains(string(archive), "hash") {
+				t.Errorf("export leaks a password hash: %s", archive)
+			}
+		})
+
+		t.Run("counts logged-out tokens towards the inline limit", func(t *testing.T) {
+			os.Setenv("exportInlineRowLimit", "3")
+			defer os.Unsetenv("exportInlineRowLimit")
+
+			res, err := RequestUserExport(UserExportRequest{ID: id})
+			utils.AssertErrorsEqual(t, nil, err)
+
+			if res.Status != userExportReady {
+				t.Fatalf("expected an export, got %+v", res)
+			}
+
+			for _, token := range []string{"token one", "token two"} {
+				database.Save(&InvalidToken{Token: token, UserID: id})
+			}
+			defer database.Where("user_id = ?", id).Delete(&InvalidToken{})
+
+			res, err = RequestUserExport(UserExportRequest{ID: id})
+			utils.AssertErrorsEqual(t, nil, err)
+
+			if res.Status != userExportPending {
+				t.Errorf("expected the export to be queued, got %+v", res)
+			}
+		})
+
+		t.Run("queues one export when asked for it later", func(t *testing.T) {
+			first, err := RequestUserExport(UserExportRequest{ID: id, Async: true})
+			utils.AssertErrorsEqual(t, nil, err)
+
+			second, err := RequestUserExport(UserExportRequest{ID: id, Async: true})
+			utils.AssertErrorsEqual(t, nil, err)
+
+			if first.Status != userExportPending || first.ExportID == "" {
+				t.Fatalf("expected a pending export, got %+v", first)
+			}
+
+			if diff := cmp.Diff(first, second); diff != "" {
+				t.Errorf("\nUnexpected second export (-want, +got)\n%s", diff)
+			}
+		})
+	})
+}
+
+func Test_ProcessUserExports(t *testing.T) {
+	databaseTest(t, func(database *gorm.DB) {
+		clearDatabase(database)
+		id := "0d1c2b3a-4e5f-4a6b-8c7d-9e0f1a2b3c4d"
+		otherID := "00000000-0000-4000-8000-000000000001"
+		database.Save(&User{ID: id, Name: "Leo Fender", Email: "leo@fender.com"})
+		database.Save(&User{ID: otherID, Name: "George Fullerton", Email: "george@fender.com"})
+
+		mailbox := withTestMailer(t)
+
+		queued, _ := RequestUserExport(UserExportRequest{ID: id, Async: true})
+
+		res, err := ProcessUserExports(time.Now())
+		utils.AssertErrorsEqual(t, nil, err)
+
+		if diff := cmp.Diff(ProcessUserExportsResponse{Processed: 1}, res); diff != "" {
+			t.Errorf("\nUnexpected result (-want, +got)\n%s", diff)
+		}
+
+		if len(mailbox.Sent) != 1 || !strings.Contains(mailbox.Sent[0].Body, queued.DownloadURL) {
+			t.Errorf("expected the download link to be mailed, got %+v", mailbox.Sent)
+		}
+
+		job, err := GetUserExport(GetUserExportRequest{ID: id, ExportID: queued.ExportID})
+		utils.AssertErrorsEqual(t, nil, err)
+
+		var export UserExport
+		if err := json.Unmarshal([]byte(job.Archive), &export); err != nil {
+			t.Fatal(err)
+		}
+
+		if diff := cmp.Diff("leo@fender.com", export.Profile.Email); diff != "" {
+			t.Errorf("\nUnexpected export (-want, +got)\n%s", diff)
+		}
+
+		_, err = GetUserExport(GetUserExportRequest{ID: otherID, ExportID: queued.ExportID})
+		utils.AssertErrorsEqual(t, utils.UserExportNotFoundError(queued.ExportID), err)
+
+		res, err = ProcessUserExports(time.Now().Add(userExportRetention() + time.Hour))
+		utils.AssertErrorsEqual(t, nil, err)
+
+		if diff := cmp.Diff(ProcessUserExportsResponse{Expired: 1}, res); diff != "" {
+			t.Errorf("\nUnexpected result (-want, +got)\n%s", diff)
+		}
+	})
+}
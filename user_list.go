@@ -0,0 +1,228 @@
+package platform_exercise
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/campallison/platform-exercise/utils"
+)
+
+const (
+	defaultListUsersLimit = 50
+	maxListUsersLimit     = 200
+
+	userStatusActive  = "active"
+	userStatusDeleted = "deleted"
+)
+
+// listUsersSorts maps the sort names accepted by GET /users to the columns
+// they order by. Each is backed by an index on (column, id) so keyset
+// pagination stays cheap on large tables.
+var listUsersSorts = map[string]string{
+	"createdAt": "created_at",
+	"email":     "lower(email)",
+	"name":      "name",
+}
+
+// listUsersCursor marks the last row of a page. Value holds the sort column of
+// that row as text and ID breaks ties between rows sharing a value.
+type listUsersCursor struct {
+	Value string `json:"v"`
+	ID    string `json:"id"`
+}
+
+func encodeListUsersCursor(cursor listUsersCursor) string {
+	b, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeListUsersCursor(encoded string) (listUsersCursor, error) {
+	var cursor listUsersCursor
+
+	b, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return cursor, err
+	}
+
+	err = json.Unmarshal(b, &cursor)
+	return cursor, err
+}
+
+// parseListUsersRequest reads the query string of GET /users.
+func parseListUsersRequest(params map[string]string) (ListUsersRequest, error) {
+	req := ListUsersRequest{
+		EmailPrefix: params["emailPrefix"],
+		Name:        params["name"],
+		Status:      params["status"],
+		Sort:        params["sort"],
+		Order:       params["order"],
+		Cursor:      params["cursor"],
+		Limit:       defaultListUsersLimit,
+	}
+
+	if req.Sort == "" {
+		req.Sort = "createdAt"
+	}
+	if _, ok := listUsersSorts[req.Sort]; !ok {
+		return req, utils.InvalidQueryParameterError("sort", req.Sort)
+	}
+
+	if req.Order == "" {
+		req.Order = "desc"
+	}
+	if req.Order != "asc" && req.Order != "desc" {
+		return req, utils.InvalidQueryParameterError("order", req.Order)
+	}
+
+	if req.Status != "" && req.Status != userStatusActive && req.Status != userStatusDeleted {
+		return req, utils.InvalidQueryParameterError("status", req.Status)
+	}
+
+	if value := params["includeDeleted"]; value != "" {
+		includeDeleted, err := strconv.ParseBool(value)
+		if err != nil {
+			return req, utils.InvalidQueryParameterError("includeDeleted", value)
+		}
+		req.IncludeDeleted = includeDeleted
+	}
+
+	if value := params["limit"]; value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit <= 0 || limit > maxListUsersLimit {
+			return req, utils.InvalidQueryParameterError("limit", value)
+		}
+		req.Limit = limit
+	}
+
+	for key, dest := range map[string]*time.Time{
+		"createdAfter":  &req.CreatedAfter,
+		"createdBefore": &req.CreatedBefore,
+	} {
+		if value := params[key]; value != "" {
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return req, utils.InvalidQueryParameterError(key, value)
+			}
+			*dest = t
+		}
+	}
+
+	return req, nil
+}
+
+// escapeLike escapes the LIKE wildcards in user input so it matches literally.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+func ListUsers(req ListUsersRequest) (ListUsersResponse, error) {
+	db := Init()
+
+	column, ok := listUsersSorts[req.Sort]
+	if !ok {
+		return ListUsersResponse{}, utils.InvalidQueryParameterError("sort", req.Sort)
+	}
+
+	direction, comparison := "ASC", ">"
+	if req.Order == "desc" {
+		direction, comparison = "DESC", "<"
+	}
+
+	limit := req.Limit
+	if limit <= 0 || limit > maxListUsersLimit {
+		limit = defaultListUsersLimit
+	}
+
+	query := db.Model(&User{})
+	switch {
+	case req.Status == userStatusDeleted:
+		query = query.Unscoped().Where("deleted_at IS NOT NULL")
+	case req.Status == userStatusActive:
+	case req.IncludeDeleted:
+		query = query.Unscoped()
+	}
+
+	if req.EmailPrefix != "" {
+		query = query.Where(`lower(email) LIKE ? ESCAPE '\'`, escapeLike(strings.ToLower(req.EmailPrefix))+"%")
+	}
+
+	if req.Name != "" {
+		query = query.Where(`name ILIKE ? ESCAPE '\'`, "%"+escapeLike(req.Name)+"%")
+	}
+
+	if !req.CreatedAfter.IsZero() {
+		query = query.Where("created_at >= ?", req.CreatedAfter)
+	}
+
+	if !req.CreatedBefore.IsZero() {
+		query = query.Where("created_at < ?", req.CreatedBefore)
+	}
+
+	if req.Cursor != "" {
+		cursor, err := decodeListUsersCursor(req.Cursor)
+		if err != nil {
+			return ListUsersResponse{}, utils.InvalidQueryParameterError("cursor", req.Cursor)
+		}
+
+		var value interface{} = cursor.Value
+		if column == "created_at" {
+			if value, err = time.Parse(time.RFC3339Nano, cursor.Value); err != nil {
+				return ListUsersResponse{}, utils.InvalidQueryParameterError("cursor", req.Cursor)
+			}
+		}
+
+		query = query.Where(fmt.Sprintf("(%s, id) %s (?, ?)", column, comparison), value, cursor.ID)
+	}
+
+	var users []User
+	if err := query.
+		Order(fmt.Sprintf("%s %s, id %s", column, direction, direction)).
+		Limit(limit + 1).
+		Find(&users).Error; err != nil {
+		return ListUsersResponse{}, utils.ListUsersError()
+	}
+
+	response := ListUsersResponse{Users: []ListedUser{}}
+	if len(users) > limit {
+		users = users[:limit]
+		response.NextCursor = encodeListUsersCursor(listUsersCursorFor(users[len(users)-1], req.Sort))
+	}
+
+	for _, user := range users {
+		listed := ListedUser{
+			ID:          user.ID,
+			Name:        user.Name,
+			GivenName:   user.GivenName,
+			FamilyName:  user.FamilyName,
+			DisplayName: user.DisplayName,
+			Email:       user.Email,
+			Status:      userStatusActive,
+			CreatedAt:   user.CreatedAt,
+		}
+
+		if user.DeletedAt.Valid {
+			deletedAt := user.DeletedAt.Time
+			listed.Status = userStatusDeleted
+			listed.DeletedAt = &deletedAt
+		}
+
+		response.Users = append(response.Users, listed)
+	}
+
+	return response, nil
+}
+
+func listUsersCursorFor(user User, sort string) listUsersCursor {
+	switch sort {
+	case "email":
+		return listUsersCursor{Value: strings.ToLower(user.Email), ID: user.ID}
+	case "name":
+		return listUsersCursor{Value: user.Name, ID: user.ID}
+	default:
+		return listUsersCursor{Value: user.CreatedAt.UTC().Format(time.RFC3339Nano), ID: user.ID}
+	}
+}
@@ -0,0 +1,212 @@
+package platform_exercise
+
+import (
+	"testing"
+	"time"
+
+	"github.com/campallison/platform-exercise/utils"
+	"github.com/google/go-cmp/cmp"
+	"gorm.io/gorm"
+)
+
+func Test_parseListUsersRequest(t *testing.T) {
+	cases := []struct {
+		name     string
+		params   map[string]string
+		expected ListUsersRequest
+		err      error
+	}{
+		{
+			name:   "defaults",
+			params: map[string]string{},
+			expected: ListUsersRequest{
+				Sort:  "createdAt",
+				Order: "desc",
+				Limit: defaultListUsersLimit,
+			},
+		},
+		{
+			name: "every filter",
+			params: map[string]string{
+				"emailPrefix":    "leo@",
+				"name":           "fender",
+				"createdAfter":   "2021-01-01T00:00:00Z",
+				"createdBefore":  "2021-02-01T00:00:00Z",
+				"status":         "deleted",
+				"includeDeleted": "true",
+				"sort":           "email",
+				"order":          "asc",
+				"limit":          "10",
+				"cursor":         "abc",
+			},
+			expected: ListUsersRequest{
+				EmailPrefix:    "leo@",
+				Name:           "fender",
+				CreatedAfter:   time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC),
+				CreatedBefore:  time.Date(2021, 2, 1, 0, 0, 0, 0, time.UTC),
+				Status:         "deleted",
+				IncludeDeleted: true,
+				Sort:           "email",
+				Order:          "asc",
+				Limit:          10,
+				Cursor:         "abc",
+			},
+		},
+		{
+			name:   "unknown sort",
+			params: map[string]string{"sort": "password"},
+			err:    utils.InvalidQueryParameterError("sort", "password"),
+		},
+		{
+			name:   "limit over the maximum",
+			params: map[string]string{"limit": "1000"},
+			err:    utils.InvalidQueryParameterError("limit", "1000"),
+		},
+		{
+			name:   "malformed date",
+			params: map[string]string{"createdAfter": "yesterday"},
+			err:    utils.InvalidQueryParameterError("createdAfter", "yesterday"),
+		},
+		{
+			name:   "unknown status",
+			params: map[string]string{"status": "banned"},
+			err:    utils.InvalidQueryParameterError("status", "banned"),
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			res, err := parseListUsersRequest(c.params)
+			utils.AssertErrorsEqual(t, c.err, err)
+
+			if c.err == nil {
+				if diff := cmp.Diff(c.expected, res); diff != "" {
+					t.Errorf("\nUnexpected request (-want, +got)\n%s", diff)
+				}
+			}
+		})
+	}
+}
+
+func Test_listUsersCursor(t *testing.T) {
+	cursor := listUsersCursor{Value: "2021-01-01T00:00:00.123456Z", ID: "5a135f2a-976d-4db7-9910-8a96b043b1b6"}
+
+	res, err := decodeListUsersCursor(encodeListUsersCursor(cursor))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff(cursor, res); diff != "" {
+		t.Errorf("\nUnexpected cursor (-want, +got)\n%s", diff)
+	}
+
+	if _, err := decodeListUsersCursor("not a cursor"); err == nil {
+		t.Error("expected an error decoding a malformed cursor")
+	}
+}
+
+func Test_ListUsers(t *testing.T) {
+	databaseTest(t, func(database *gorm.DB) {
+		clearDatabase(database)
+
+		start := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+		users := []User{
+			{ID: "00000000-0000-4000-8000-000000000001", Name: "Leo Fender", Email: "leo@fender.com"},
+			{ID: "00000000-0000-4000-8000-000000000002", Name: "George Fullerton", Email: "george@fender.com"},
+			{ID: "00000000-0000-4000-8000-000000000003", Name: "Freddie Tavares", Email: "freddie@fender.com"},
+			{ID: "00000000-0000-4000-8000-000000000004", Name: "Doc Kauffman", Email: "doc@kandf.com"},
+		}
+		for i := range users {
+			users[i].CreatedAt = start.Add(time.Duration(i) * 24 * time.Hour)
+			database.Save(&users[i])
+		}
+		database.Delete(&User{}, "id = ?", users[3].ID)
+
+		ids := func(res ListUsersResponse) []string {
+			var ids []string
+			for _, user := range res.Users {
+				ids = append(ids, user.ID)
+			}
+			return ids
+		}
+
+		cases := []struct {
+			name     string
+			req      ListUsersRequest
+			expected []string
+		}{
+			{
+				name:     "newest first, without deleted users",
+				req:      ListUsersRequest{Sort: "createdAt", Order: "desc"},
+				expected: []string{users[2].ID, users[1].ID, users[0].ID},
+			},
+			{
+				name:     "including deleted users",
+				req:      ListUsersRequest{Sort: "createdAt", Order: "asc", IncludeDeleted: true},
+				expected: []string{users[0].ID, users[1].ID, users[2].ID, users[3].ID},
+			},
+			{
+				name:     "only deleted users",
+				req:      ListUsersRequest{Sort: "createdAt", Order: "asc", Status: "deleted"},
+				expected: []string{users[3].ID},
+			},
+			{
+				name:     "by email prefix, case-insensitive",
+				req:      ListUsersRequest{Sort: "email", Order: "asc", EmailPrefix: "GEO"},
+				expected: []string{users[1].ID},
+			},
+			{
+				name:     "LIKE wildcards in the prefix match literally",
+				req:      ListUsersRequest{Sort: "email", Order: "asc", EmailPrefix: "%"},
+				expected: nil,
+			},
+			{
+				name:     "by name substring",
+				req:      ListUsersRequest{Sort: "name", Order: "asc", Name: "ful"},
+				expected: []string{users[1].ID},
+			},
+			{
+				name: "by created date range",
+				req: ListUsersRequest{
+					Sort:          "createdAt",
+					Order:         "asc",
+					CreatedAfter:  start.Add(24 * time.Hour),
+					CreatedBefore: start.Add(48 * time.Hour),
+				},
+				expected: []string{users[1].ID},
+			},
+		}
+
+		for _, c := range cases {
+			t.Run(c.name, func(t *testing.T) {
+				res, err := ListUsers(c.req)
+				utils.AssertErrorsEqual(t, nil, err)
+
+				if diff := cmp.Diff(c.expected, ids(res)); diff != "" {
+					t.Errorf("\nUnexpected users (-want, +got)\n%s", diff)
+				}
+			})
+		}
+
+		t.Run("pages through every user with the cursor", func(t *testing.T) {
+			var seen []string
+			req := ListUsersRequest{Sort: "email", Order: "asc", Limit: 2}
+
+			for {
+				res, err := ListUsers(req)
+				utils.AssertErrorsEqual(t, nil, err)
+				seen = append(seen, ids(res)...)
+
+				if res.NextCursor == "" {
+					break
+				}
+				req.Cursor = res.NextCursor
+			}
+
+			expected := []string{users[2].ID, users[1].ID, users[0].ID}
+			if diff := cmp.Diff(expected, seen); diff != "" {
+				t.Errorf("\nUnexpected users (-want, +got)\n%s", diff)
+			}
+		})
+	})
+}
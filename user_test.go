@@ -1,6 +1,9 @@
 package platform_exercise
 
 import (
+	"fmt"
+	"net"
+	"os"
 	"testing"
 
 	"github.com/campallison/platform-exercise/utils"
@@ -17,6 +20,37 @@ func databaseTest(t *testing.T, handler func(database *gorm.DB)) {
 func clearDatabase(database *gorm.DB) {
 	session := database.Session(&gorm.Session{AllowGlobalUpdate: true})
 	session.Unscoped().Delete(User{})
+	session.Delete(UserErasure{})
+	session.Delete(FailedLogin{})
+	session.Delete(LoginBlock{})
+	session.Delete(IPRule{})
+}
+
+// withTestMailer sends mail to an in-memory mailbox until the test ends.
+func withTestMailer(t *testing.T) *utils.InMemoryMailer {
+	mailbox := &utils.InMemoryMailer{}
+	defaultMailer := currentMailer()
+	mailer = mailbox
+	t.Cleanup(func() { mailer = defaultMailer })
+	return mailbox
+}
+
+func Test_withTestMailer(t *testing.T) {
+	user := User{Name: "Leo Fender", Email: "leo@fender.com"}
+
+	t.Run("captures mail", func(t *testing.T) {
+		mailbox := withTestMailer(t)
+		sendWelcomeMail(user)
+
+		if len(mailbox.Sent) != 1 {
+			t.Errorf("expected one mail, got %+v", mailbox.Sent)
+		}
+	})
+
+	if mailer == nil {
+		t.Fatal("expected the default mailer to be restored")
+	}
+	sendWelcomeMail(user)
 }
 
 func Test_CreateUser(t *testing.T) {
@@ -49,7 +83,7 @@ func Test_CreateUser(t *testing.T) {
 					Password: strongPW,
 				},
 				expected: User{},
-				err:      utils.CouldNotParseEmailError("leo+tune@fender.com"),
+				err:      utils.AliasedEmailError("leo+tune@fender.com"),
 			},
 			{
 				name: "returns an error if email is prohibited",
@@ -82,7 +116,7 @@ func Test_CreateUser(t *testing.T) {
 				err:      utils.InvalidNameError("I am the greetest!"),
 			},
 			{
-				name: "returns an error if save to db fails",
+				name: "answers as if created when the email is already registered",
 				setup: func(db *gorm.DB) {
 					db.Save(&User{
 						Name:  "Jimi Hendrix",
@@ -94,8 +128,93 @@ func Test_CreateUser(t *testing.T) {
 					Email:    "voodoochild@fire.com",
 					Password: strongPW,
 				},
+				expected: User{
+					Name:           "Other Name",
+					Email:          "voodoochild@fire.com",
+					CanonicalEmail: "voodoochild@fire.com",
+				},
+				err: nil,
+			},
+			{
+				name: "answers as if created when the email differs from an existing one only by case",
+				setup: func(db *gorm.DB) {
+					db.Save(&User{
+						Name:  "Jimi Hendrix",
+						Email: "voodoochild@fire.com",
+					})
+				},
+				req: CreateUserRequest{
+					Name:     "Other Name",
+					Email:    "VoodooChild@Fire.com",
+					Password: strongPW,
+				},
+				expected: User{
+					Name:           "Other Name",
+					Email:          "VoodooChild@Fire.com",
+					CanonicalEmail: "voodoochild@fire.com",
+				},
+				err: nil,
+			},
+			{
+				name: "reuses the email of a deleted user",
+				setup: func(db *gorm.DB) {
+					db.Save(&User{
+						ID:    "0d1c2b3a-4e5f-4a6b-8c7d-9e0f1a2b3c4d",
+						Name:  "Jimi Hendrix",
+						Email: "voodoochild@fire.com",
+					})
+					db.Delete(&User{}, "id = ?", "0d1c2b3a-4e5f-4a6b-8c7d-9e0f1a2b3c4d")
+				},
+				req: CreateUserRequest{
+					Name:     "Other Name",
+					Email:    "voodoochild@fire.com",
+					Password: strongPW,
+				},
+				expected: User{
+					Name:           "Other Name",
+					Email:          "voodoochild@fire.com",
+					CanonicalEmail: "voodoochild@fire.com",
+				},
+				err: nil,
+			},
+			{
+				name: "builds the name from given and family names",
+				req: CreateUserRequest{
+					GivenName:   "Leo",
+					FamilyName:  "Fender",
+					DisplayName: "Leo F",
+					Email:       "leo@fender.com",
+					Password:    strongPW,
+				},
+				expected: User{
+					Name:           "Leo Fender",
+					GivenName:      "Leo",
+					FamilyName:     "Fender",
+					DisplayName:    "Leo F",
+					Email:          "leo@fender.com",
+					CanonicalEmail: "leo@fender.com",
+				},
+				err: nil,
+			},
+			{
+				name: "returns an error if a name is reserved",
+				req: CreateUserRequest{
+					Name:     "Fender Support",
+					Email:    "leo@fender.com",
+					Password: strongPW,
+				},
 				expected: User{},
-				err:      utils.SaveUserToDBError("voodoochild@fire.com"),
+				err:      utils.ReservedNameError("Fender Support"),
+			},
+			{
+				name: "returns an error if a name mixes scripts",
+				req: CreateUserRequest{
+					Name:     "L\u0435o Fender",
+					Email:    "leo@fender.com",
+					Password: strongPW,
+				},
+				expected: User{},
+				err:      utils.MixedScriptNameError("L\u0435o Fender"),
 			},
 			{
 				name: "successfully saves a user",
@@ -105,8 +224,9 @@ func Test_CreateUser(t *testing.T) {
 					Password: strongPW,
 				},
 				expected: User{
-					Name:  "Leo Fender",
-					Email: "leo@fender.com",
+					Name:           "Leo Fender",
+					Email:          "leo@fender.com",
+					CanonicalEmail: "leo@fender.com",
 				},
 				err: nil,
 			},
@@ -137,6 +257,59 @@ func Test_CreateUser(t *testing.T) {
 	})
 }
 
+func Test_CreateUser_existingEmail(t *testing.T) {
+	databaseTest(t, func(database *gorm.DB) {
+		clearDatabase(database)
+		strongPW := "s3tIt0nF!re&Play1tWithYourT33th"
+
+		mailbox := withTestMailer(t)
+
+		created, err := CreateUser(CreateUserRequest{Name: "Jimi Hendrix", Email: "voodoochild@fire.com", Password: strongPW})
+		utils.AssertErrorsEqual(t, nil, err)
+
+		duplicate, err := CreateUser(CreateUserRequest{Name: "Other Name", Email: "VoodooChild@Fire.com", Password: strongPW})
+		utils.AssertErrorsEqual(t, nil, err)
+
+		if duplicate.ID == "" || duplicate.ID == created.ID {
+			t.Errorf("expected a decoy ID, got %q", duplicate.ID)
+		}
+
+		var count int64
+		database.Model(&User{}).Where("canonical_email = ?", "voodoochild@fire.com").Count(&count)
+		if count != 1 {
+			t.Errorf("expected the existing account only, got %d accounts", count)
+		}
+
+		var mails [][]string
+		for _, mail := range mailbox.Sent {
+			mails = append(mails, []string{mail.To, mail.Subject})
+		}
+
+		expected := [][]string{
+			{"voodoochild@fire.com", "Your account has been created"},
+			{"voodoochild@fire.com", "Someone tried to sign up with your email"},
+		}
+		if diff := cmp.Diff(expected, mails); diff != "" {
+			t.Errorf("\nUnexpected mail (-want, +got)\n%s", diff)
+		}
+
+		t.Run("takes as long as creating an account", func(t *testing.T) {
+			signups := 0
+			assertSimilarTimings(t, timingSamples,
+				func() {
+					signups++
+					_, err := CreateUser(CreateUserRequest{Name: "Jimi Hendrix", Email: fmt.Sprintf("fresh%d@fire.com", signups), Password: strongPW})
+					utils.AssertErrorsEqual(t, nil, err)
+				},
+				func() {
+					_, err := CreateUser(CreateUserRequest{Name: "Other Name", Email: "voodoochild@fire.com", Password: strongPW})
+					utils.AssertErrorsEqual(t, nil, err)
+				},
+			)
+		})
+	})
+}
+
 func Test_GetUser(t *testing.T) {
 	databaseTest(t, func(database *gorm.DB) {
 		clearDatabase(database)
@@ -171,9 +344,10 @@ func Test_GetUser(t *testing.T) {
 					ID: id,
 				},
 				expected: User{
-					ID:    id,
-					Name:  "Enrico Fermi",
-					Email: "ilpapa@umich.edu",
+					ID:             id,
+					Name:           "Enrico Fermi",
+					Email:          "ilpapa@umich.edu",
+					CanonicalEmail: "ilpapa@umich.edu",
 				},
 				err: nil,
 			},
@@ -210,6 +384,8 @@ func Test_UpdateUser(t *testing.T) {
 		id := "13a185dd-1c2e-4092-81cc-ec306d18b2bd"
 		frysPW := "WalkinOnSunshine1999!"
 		frysHash, _ := HashPassword(frysPW)
+		previousPW := "CryogenicTubeNewYear1999"
+		previousHash, _ := HashPassword(previousPW)
 
 		cases := []struct {
 			name     string
@@ -310,52 +486,92 @@ func Test_UpdateUser(t *testing.T) {
 					Name: "Bender Rodriguez",
 				},
 				expected: User{
-					ID:    id,
-					Name:  "Bender Rodriguez",
-					Email: "deliveryboy@panuccis.net",
+					ID:             id,
+					Name:           "Bender Rodriguez",
+					Email:          "deliveryboy@panuccis.net",
+					CanonicalEmail: "deliveryboy@panuccis.net",
 				},
 				err: nil,
 			},
 			{
-				name: "successfully updates a valid email",
+				name: "requires the current password to change email",
 				setup: func(db *gorm.DB) {
 					db.Save(&User{
 						ID:       id,
 						Name:     "Philip Fry",
 						Email:    "deliveryboy@panuccis.net",
-						Password: "WalkinOnSunshine1999!",
+						Password: frysHash,
 					})
 				},
 				req: UpdateUserRequest{
 					ID:    id,
 					Email: "daffodil@shiny.com",
 				},
+				expected: User{},
+				err:      utils.ReauthenticationRequiredError(),
+			},
+			{
+				name: "does not change email given incorrect current password",
+				setup: func(db *gorm.DB) {
+					db.Save(&User{
+						ID:       id,
+						Name:     "Philip Fry",
+						Email:    "deliveryboy@panuccis.net",
+						Password: frysHash,
+					})
+				},
+				req: UpdateUserRequest{
+					ID:          id,
+					Email:       "daffodil@shiny.com",
+					OldPassword: "NotMyPassword3000",
+				},
+				expected: User{},
+				err:      utils.UnauthorizedError(),
+			},
+			{
+				name: "leaves a new email pending until it is confirmed",
+				setup: func(db *gorm.DB) {
+					db.Save(&User{
+						ID:       id,
+						Name:     "Philip Fry",
+						Email:    "deliveryboy@panuccis.net",
+						Password: frysHash,
+					})
+				},
+				req: UpdateUserRequest{
+					ID:          id,
+					Email:       "daffodil@shiny.com",
+					OldPassword: frysPW,
+				},
 				expected: User{
-					ID:    id,
-					Name:  "Philip Fry",
-					Email: "daffodil@shiny.com",
+					ID:             id,
+					Name:           "Philip Fry",
+					Email:          "deliveryboy@panuccis.net",
+					CanonicalEmail: "deliveryboy@panuccis.net",
 				},
 				err: nil,
 			},
 			{
-				name: "successfully updates a valid name and email",
+				name: "updates the name at once and the email once confirmed",
 				setup: func(db *gorm.DB) {
 					db.Save(&User{
 						ID:       id,
 						Name:     "Philip Fry",
 						Email:    "deliveryboy@panuccis.net",
-						Password: "WalkinOnSunshine1999!",
+						Password: frysHash,
 					})
 				},
 				req: UpdateUserRequest{
-					ID:    id,
-					Name:  "Bender Rodriguez",
-					Email: "daffodil@shiny.com",
+					ID:          id,
+					Name:        "Bender Rodriguez",
+					Email:       "daffodil@shiny.com",
+					OldPassword: frysPW,
 				},
 				expected: User{
-					ID:    id,
-					Name:  "Bender Rodriguez",
-					Email: "daffodil@shiny.com",
+					ID:             id,
+					Name:           "Bender Rodriguez",
+					Email:          "deliveryboy@panuccis.net",
+					CanonicalEmail: "deliveryboy@panuccis.net",
 				},
 				err: nil,
 			},
@@ -375,9 +591,10 @@ func Test_UpdateUser(t *testing.T) {
 					NewPassword: "BenderIsGreat3001!",
 				},
 				expected: User{
-					ID:    id,
-					Name:  "Philip Fry",
-					Email: "deliveryboy@panuccis.net",
+					ID:             id,
+					Name:           "Philip Fry",
+					Email:          "deliveryboy@panuccis.net",
+					CanonicalEmail: "deliveryboy@panuccis.net",
 				},
 				err: nil,
 			},
@@ -417,6 +634,46 @@ func Test_UpdateUser(t *testing.T) {
 				expected: User{},
 				err:      utils.InsecurePasswordError(),
 			},
+			{
+				name: "does not allow reusing the current password",
+				setup: func(db *gorm.DB) {
+					db.Save(&User{
+						ID:       id,
+						Name:     "Philip Fry",
+						Email:    "deliveryboy@panuccis.net",
+						Password: frysHash,
+					})
+				},
+				req: UpdateUserRequest{
+					ID:          id,
+					OldPassword: "WalkinOnSunshine1999!",
+					NewPassword: "WalkinOnSunshine1999!",
+				},
+				expected: User{},
+				err:      utils.ReusedPasswordError(defaultPasswordHistoryDepth),
+			},
+			{
+				name: "does not allow reusing a previous password",
+				setup: func(db *gorm.DB) {
+					db.Save(&User{
+						ID:       id,
+						Name:     "Philip Fry",
+						Email:    "deliveryboy@panuccis.net",
+						Password: frysHash,
+					})
+					db.Save(&PasswordHistory{
+						UserID:   id,
+						Password: previousHash,
+					})
+				},
+				req: UpdateUserRequest{
+					ID:          id,
+					OldPassword: "WalkinOnSunshine1999!",
+					NewPassword: previousPW,
+				},
+				expected: User{},
+				err:      utils.ReusedPasswordError(defaultPasswordHistoryDepth),
+			},
 		}
 
 		for _, c := range cases {
@@ -515,42 +772,69 @@ func Test_checkPasswordStrength(t *testing.T) {
 	}
 }
 
-func Test_isValidName(t *testing.T) {
+func Test_normalizeNames(t *testing.T) {
 	cases := []struct {
 		name     string
-		input    string
-		expected bool
+		input    userNames
+		expected userNames
+		err      error
 	}{
 		{
-			name:     "valid name returns true",
-			input:    "Leo Fender",
-			expected: true,
+			name:     "valid name",
+			input:    userNames{Name: "Leo Fender"},
+			expected: userNames{Name: "Leo Fender"},
 		},
 		{
-			name:     "valid name with foreign characters returns true",
-			input:    "陳大文",
-			expected: true,
+			name:     "valid name with foreign characters",
+			input:    userNames{Name: "陳大文"},
+			expected: userNames{Name: "陳大文"},
 		},
 		{
-			name:     "valid name with foreign characters returns true 2",
-			input:    "আবাসযোগ্য",
-			expected: true,
+			name:     "valid name with foreign characters 2",
+			input:    userNames{Name: "আবাসযোগ্য"},
+			expected: userNames{Name: "আবাসযোগ্য"},
 		},
 		{
-			name:     "valid name with foreign characters returns true 3",
-			input:    "Biréli Lagrène",
-			expected: true,
+			name:     "valid name with foreign characters 3",
+			input:    userNames{Name: "Biréli Lagrène"},
+			expected: userNames{Name: "Biréli Lagrène"},
 		},
 		{
-			name:     "invalid name returns false",
-			input:    "A$@p Rocky",
-			expected: false,
+			name:  "invalid name",
+			input: userNames{Name: "A$@p Rocky"},
+			err:   utils.InvalidNameError("A$@p Rocky"),
+		},
+		{
+			name: "every supplied name is normalized",
+			input: userNames{
+				Name:        " Leo  Fender ",
+				GivenName:   "Clarence Leonidas ",
+				FamilyName:  " Fender",
+				DisplayName: "Leo  F ",
+			},
+			expected: userNames{
+				Name:        "Leo Fender",
+				GivenName:   "Clarence Leonidas",
+				FamilyName:  "Fender",
+				DisplayName: "Leo F",
+			},
+		},
+		{
+			name:     "display names may contain digits",
+			input:    userNames{DisplayName: "Leo 1950"},
+			expected: userNames{DisplayName: "Leo 1950"},
+		},
+		{
+			name:  "family names may not contain digits",
+			input: userNames{FamilyName: "Fender 1950"},
+			err:   utils.InvalidNameError("Fender 1950"),
 		},
 	}
 
 	for _, c := range cases {
 		t.Run(c.name, func(t *testing.T) {
-			res := isValidName(c.input)
+			res, err := normalizeNames(c.input)
+			utils.AssertErrorsEqual(t, c.err, err)
 
 			if diff := cmp.Diff(c.expected, res); diff != "" {
 				t.Errorf("\nUnexpected result (-want, +got)\n%s", diff)
@@ -558,3 +842,204 @@ func Test_isValidName(t *testing.T) {
 		})
 	}
 }
+
+func Test_screenNames(t *testing.T) {
+	cases := []struct {
+		name  string
+		input userNames
+		err   error
+	}{
+		{
+			name:  "ordinary names pass",
+			input: userNames{Name: "Leo Fender", DisplayName: "Leo"},
+		},
+		{
+			name:  "reserved display name",
+			input: userNames{Name: "Leo Fender", DisplayName: "Fender Support"},
+			err:   utils.ReservedNameError("Fender Support"),
+		},
+		{
+			name:  "profane given name",
+			input: userNames{Name: "Leo Fender", GivenName: "Sh1t"},
+			err:   utils.ProfaneNameError("Sh1t"),
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			utils.AssertErrorsEqual(t, c.err, screenNames(c.input))
+		})
+	}
+}
+
+func Test_ValidateEmail(t *testing.T) {
+	resolver := &utils.InMemoryResolver{
+		MX: map[string][]*net.MX{
+			"fender.com": {{Host: "mx1.fender.com.", Pref: 10}},
+		},
+	}
+
+	cases := []struct {
+		name           string
+		deliverability string
+		req            ValidateEmailRequest
+		expected       ValidateEmailResponse
+		err            error
+	}{
+		{
+			name:     "valid email",
+			req:      ValidateEmailRequest{Email: "leo@fender.com"},
+			expected: ValidateEmailResponse{Email: "leo@fender.com", IsValid: true},
+		},
+		{
+			name:     "unparseable email",
+			req:      ValidateEmailRequest{Email: "leo@fender"},
+			expected: ValidateEmailResponse{Email: "leo@fender", Code: "unparseable"},
+			err:      utils.CouldNotParseEmailError("leo@fender"),
+		},
+		{
+			name:     "prohibited domain",
+			req:      ValidateEmailRequest{Email: "nuge@trashmail.com"},
+			expected: ValidateEmailResponse{Email: "nuge@trashmail.com", Code: "prohibited_domain"},
+			err:      utils.ProhibitedEmailError("nuge@trashmail.com"),
+		},
+		{
+			name: "aliased email is rejected by default",
+			req:  ValidateEmailRequest{Email: "leo+tune@fender.com"},
+			expected: ValidateEmailResponse{
+				Email:         "leo+tune@fender.com",
+				Code:          "aliased",
+				AliasDecision: "reject",
+				AliasReason:   "plus-addressing is not accepted for fender.com",
+			},
+			err: utils.AliasedEmailError("leo+tune@fender.com"),
+		},
+		{
+			name: "aliased email is allowed where the domain policy allows it",
+			req:  ValidateEmailRequest{Email: "leo+tune@tcell.io"},
+			expected: ValidateEmailResponse{
+				Email:         "leo+tune@tcell.io",
+				IsValid:       true,
+				AliasDecision: "allow",
+				AliasReason:   "plus-addressing is allowed for tcell.io",
+			},
+		},
+		{
+			name: "aliased email is allowed but deduplicated where the domain policy dedupes",
+			req:  ValidateEmailRequest{Email: "leo+tune@gmail.com"},
+			expected: ValidateEmailResponse{
+				Email:         "leo+tune@gmail.com",
+				IsValid:       true,
+				AliasDecision: "dedupe",
+				AliasReason:   "plus-addressing is allowed for gmail.com, but the address counts as leo@gmail.com when checking for existing accounts",
+			},
+		},
+		{
+			name: "mistyped popular domain is accepted with a suggestion",
+			req:  ValidateEmailRequest{Email: "leo@gmial.com"},
+			expected: ValidateEmailResponse{
+				Email:      "leo@gmial.com",
+				IsValid:    true,
+				Suggestion: "leo@gmail.com",
+			},
+		},
+		{
+			name:           "rejected email still carries a suggestion",
+			deliverability: "true",
+			req:            ValidateEmailRequest{Email: "leo@hotmail.con"},
+			expected: ValidateEmailResponse{
+				Email:      "leo@hotmail.con",
+				Code:       "undeliverable",
+				Reason:     "domain hotmail.con has no MX, A or AAAA records",
+				Suggestion: "leo@hotmail.com",
+			},
+			err: utils.UndeliverableEmailError("leo@hotmail.con"),
+		},
+		{
+			name:     "undeliverable domain is accepted when the check is disabled",
+			req:      ValidateEmailRequest{Email: "leo@fender-guitars.example"},
+			expected: ValidateEmailResponse{Email: "leo@fender-guitars.example", IsValid: true},
+		},
+		{
+			name:           "deliverable domain is accepted when the check is enabled",
+			deliverability: "true",
+			req:            ValidateEmailRequest{Email: "leo@fender.com"},
+			expected:       ValidateEmailResponse{Email: "leo@fender.com", IsValid: true},
+		},
+		{
+			name:           "undeliverable domain is rejected with a reason when the check is enabled",
+			deliverability: "true",
+			req:            ValidateEmailRequest{Email: "leo@fender-guitars.example"},
+			expected: ValidateEmailResponse{
+				Email:  "leo@fender-guitars.example",
+				Code:   "undeliverable",
+				Reason: "domain fender-guitars.example has no MX, A or AAAA records",
+			},
+			err: utils.UndeliverableEmailError("leo@fender-guitars.example"),
+		},
+	}
+
+	defaultDeliverability := emailDeliverability
+	emailDeliverability = utils.NewDeliverabilityChecker(resolver, emailDeliverabilityTTL)
+	defer func() { emailDeliverability = defaultDeliverability }()
+
+	emailConfigOnce.Do(loadEmailConfig)
+	utils.SetAliasPolicies(utils.AliasReject, map[string]utils.AliasPolicy{
+		"tcell.io":  utils.AliasAllow,
+		"gmail.com": utils.AliasDedupe,
+	})
+	defer utils.SetAliasPolicies(utils.AliasReject, map[string]utils.AliasPolicy{})
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			os.Setenv("checkEmailDeliverability", c.deliverability)
+			defer os.Unsetenv("checkEmailDeliverability")
+
+			res, err := ValidateEmail(c.req)
+			utils.AssertErrorsEqual(t, c.err, err)
+
+			if diff := cmp.Diff(c.expected, res); diff != "" {
+				t.Errorf("\nUnexpected validation (-want, +got)\n%s", diff)
+			}
+		})
+	}
+}
+
+func Test_canonicalEmail(t *testing.T) {
+	cases := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{
+			name:     "lowercases the address",
+			input:    "Leo@Fender.com",
+			expected: "leo@fender.com",
+		},
+		{
+			name:     "applies provider rules",
+			input:    "Leo.Fender@gmail.com",
+			expected: "leofender@gmail.com",
+		},
+		{
+			name:     "uses the punycode domain",
+			input:    "leo@Bücher.de",
+			expected: "leo@xn--bcher-kva.de",
+		},
+		{
+			name:     "falls back to lowercasing unparseable addresses",
+			input:    " Leo@Fender ",
+			expected: "leo@fender",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			res := canonicalEmail(c.input)
+
+			if diff := cmp.Diff(c.expected, res); diff != "" {
+				t.Errorf("\nUnexpected canonical email (-want, +got)\n%s", diff)
+			}
+		})
+	}
+}
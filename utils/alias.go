@@ -0,0 +1,123 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+type AliasPolicy string
+
+const (
+	AliasAllow  AliasPolicy = "allow"
+	AliasReject AliasPolicy = "reject"
+	AliasDedupe AliasPolicy = "dedupe"
+
+	aliasSeparator     = "+"
+	defaultAliasPolicy = AliasReject
+)
+
+var aliasPolicies = struct {
+	sync.RWMutex
+	fallback AliasPolicy
+	domains  map[string]AliasPolicy
+}{
+	fallback: defaultAliasPolicy,
+	domains:  map[string]AliasPolicy{},
+}
+
+type AliasDecision struct {
+	Aliased bool
+	Allowed bool
+	Policy  AliasPolicy
+	Reason  string
+}
+
+func ParseAliasPolicy(policy string) (AliasPolicy, bool) {
+	switch p := AliasPolicy(strings.ToLower(strings.TrimSpace(policy))); p {
+	case AliasAllow, AliasReject, AliasDedupe:
+		return p, true
+	}
+	return "", false
+}
+
+// ParseAliasPolicies reads per-domain overrides written as
+// "gmail.com=dedupe,fender.com=allow".
+func ParseAliasPolicies(config string) (map[string]AliasPolicy, error) {
+	policies := map[string]AliasPolicy{}
+
+	for _, entry := range strings.Split(config, ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("alias policy %q is not of the form domain=policy", entry)
+		}
+
+		policy, ok := ParseAliasPolicy(parts[1])
+		if !ok {
+			return nil, fmt.Errorf("unknown alias policy %q for %s", parts[1], parts[0])
+		}
+
+		policies[NormalizeDomainEntry(parts[0])] = policy
+	}
+
+	return policies, nil
+}
+
+func SetAliasPolicies(fallback AliasPolicy, domains map[string]AliasPolicy) {
+	aliasPolicies.Lock()
+	defer aliasPolicies.Unlock()
+
+	aliasPolicies.fallback = fallback
+	aliasPolicies.domains = domains
+}
+
+// AliasPolicyFor returns the policy of the domain's provider, so another
+// domain of the same provider, such as googlemail.com for gmail.com, follows
+// the same rules.
+func AliasPolicyFor(domain string) AliasPolicy {
+	domain = CanonicalDomain(domain)
+
+	aliasPolicies.RLock()
+	defer aliasPolicies.RUnlock()
+
+	if policy, ok := aliasPolicies.domains[domain]; ok {
+		return policy
+	}
+	return aliasPolicies.fallback
+}
+
+// StripAlias removes a plus-address tag, turning "leo+tune" into "leo".
+func StripAlias(localPart string) string {
+	if i := strings.Index(localPart, aliasSeparator); i > 0 {
+		return localPart[:i]
+	}
+	return localPart
+}
+
+func DecideAlias(email Email) AliasDecision {
+	policy := AliasPolicyFor(email.Domain)
+	if !IsAliasedEmail(email.LocalPart) {
+		return AliasDecision{Allowed: true, Policy: policy}
+	}
+
+	decision := AliasDecision{Aliased: true, Policy: policy}
+	switch policy {
+	case AliasAllow:
+		decision.Allowed = true
+		decision.Reason = fmt.Sprintf("plus-addressing is allowed for %s", email.Domain)
+	case AliasDedupe:
+		decision.Allowed = true
+		decision.Reason = fmt.Sprintf(
+			"plus-addressing is allowed for %s, but the address counts as %s@%s when checking for existing accounts",
+			email.Domain, StripAlias(email.LocalPart), email.Domain,
+		)
+	default:
+		decision.Reason = fmt.Sprintf("plus-addressing is not accepted for %s", email.Domain)
+	}
+
+	return decision
+}
@@ -0,0 +1,158 @@
+package utils
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_ParseAliasPolicies(t *testing.T) {
+	cases := []struct {
+		name     string
+		input    string
+		expected map[string]AliasPolicy
+		err      error
+	}{
+		{
+			name:     "empty config has no overrides",
+			input:    "",
+			expected: map[string]AliasPolicy{},
+		},
+		{
+			name:  "reads domain overrides",
+			input: "Gmail.com=dedupe, fender.com = allow ,",
+			expected: map[string]AliasPolicy{
+				"gmail.com":  AliasDedupe,
+				"fender.com": AliasAllow,
+			},
+		},
+		{
+			name:  "rejects an entry without a policy",
+			input: "gmail.com",
+			err:   errors.New(`alias policy "gmail.com" is not of the form domain=policy`),
+		},
+		{
+			name:  "rejects an unknown policy",
+			input: "gmail.com=sometimes",
+			err:   errors.New(`unknown alias policy "sometimes" for gmail.com`),
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			res, err := ParseAliasPolicies(c.input)
+			AssertErrorsEqual(t, c.err, err)
+
+			if diff := cmp.Diff(c.expected, res); diff != "" {
+				t.Errorf("\nUnexpected policies (-want, +got)\n%s", diff)
+			}
+		})
+	}
+}
+
+func Test_DecideAlias(t *testing.T) {
+	SetAliasPolicies(AliasReject, map[string]AliasPolicy{
+		"fender.com": AliasAllow,
+		"gmail.com":  AliasDedupe,
+	})
+	defer SetAliasPolicies(defaultAliasPolicy, map[string]AliasPolicy{})
+
+	cases := []struct {
+		name     string
+		input    Email
+		expected AliasDecision
+	}{
+		{
+			name:     "address without a tag is allowed",
+			input:    Email{LocalPart: "leo", Domain: "tcell.io"},
+			expected: AliasDecision{Allowed: true, Policy: AliasReject},
+		},
+		{
+			name:  "tagged address is rejected by the default policy",
+			input: Email{LocalPart: "leo+tune", Domain: "tcell.io"},
+			expected: AliasDecision{
+				Aliased: true,
+				Policy:  AliasReject,
+				Reason:  "plus-addressing is not accepted for tcell.io",
+			},
+		},
+		{
+			name:  "tagged address is allowed by a domain override",
+			input: Email{LocalPart: "leo+tune", Domain: "fender.com"},
+			expected: AliasDecision{
+				Aliased: true,
+				Allowed: true,
+				Policy:  AliasAllow,
+				Reason:  "plus-addressing is allowed for fender.com",
+			},
+		},
+		{
+			name:  "tagged address is allowed and deduplicated by a domain override",
+			input: Email{LocalPart: "leo+tune", Domain: "gmail.com"},
+			expected: AliasDecision{
+				Aliased: true,
+				Allowed: true,
+				Policy:  AliasDedupe,
+				Reason:  "plus-addressing is allowed for gmail.com, but the address counts as leo@gmail.com when checking for existing accounts",
+			},
+		},
+		{
+			name:  "tagged address follows the policy of its provider's canonical domain",
+			input: Email{LocalPart: "leo+tune", Domain: "googlemail.com"},
+			expected: AliasDecision{
+				Aliased: true,
+				Allowed: true,
+				Policy:  AliasDedupe,
+				Reason:  "plus-addressing is allowed for googlemail.com, but the address counts as leo@googlemail.com when checking for existing accounts",
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			res := DecideAlias(c.input)
+
+			if diff := cmp.Diff(c.expected, res); diff != "" {
+				t.Errorf("\nUnexpected decision (-want, +got)\n%s", diff)
+			}
+		})
+	}
+}
+
+func Test_CanonicalizeEmail_aliasPolicy(t *testing.T) {
+	SetAliasPolicies(AliasAllow, map[string]AliasPolicy{"gmail.com": AliasDedupe})
+	defer SetAliasPolicies(defaultAliasPolicy, map[string]AliasPolicy{})
+
+	cases := []struct {
+		name     string
+		input    Email
+		expected string
+	}{
+		{
+			name:     "keeps the tag where aliases are allowed",
+			input:    Email{LocalPart: "leo+tune", Domain: "fender.com"},
+			expected: "leo+tune@fender.com",
+		},
+		{
+			name:     "drops the tag where aliases are deduplicated",
+			input:    Email{LocalPart: "Leo.Fender+Tune", Domain: "gmail.com"},
+			expected: "leofender@gmail.com",
+		},
+		{
+			name:     "drops the tag on another domain of a deduplicated provider",
+			input:    Email{LocalPart: "Leo.Fender+Tune", Domain: "googlemail.com"},
+			expected: "leofender@gmail.com",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			res := CanonicalizeEmail(c.input)
+
+			if diff := cmp.Diff(c.expected, res); diff != "" {
+				t.Errorf("\nUnexpected canonical email (-want, +got)\n%s", diff)
+			}
+		})
+	}
+}
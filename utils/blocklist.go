@@ -0,0 +1,148 @@
+package utils
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+const wildcardPrefix = "*."
+
+// domainSet matches a domain against its entries by walking up the domain's
+// labels, so lookups cost one map probe per label regardless of list size.
+// A plain entry such as "mailinator.com" matches the domain and every
+// subdomain, while "*.example.com" matches subdomains only.
+type domainSet struct {
+	suffixes  map[string]struct{}
+	wildcards map[string]struct{}
+}
+
+func newDomainSet(entries []string) domainSet {
+	set := domainSet{
+		suffixes:  map[string]struct{}{},
+		wildcards: map[string]struct{}{},
+	}
+
+	for _, entry := range entries {
+		entry = NormalizeDomainEntry(entry)
+		if entry == "" {
+			continue
+		}
+
+		if strings.HasPrefix(entry, wildcardPrefix) {
+			set.wildcards[strings.TrimPrefix(entry, wildcardPrefix)] = struct{}{}
+		} else {
+			set.suffixes[entry] = struct{}{}
+		}
+	}
+
+	return set
+}
+
+func (s domainSet) matches(domain string) bool {
+	candidate := domain
+	for {
+		if _, ok := s.suffixes[candidate]; ok {
+			return true
+		}
+
+		if _, ok := s.wildcards[candidate]; ok && candidate != domain {
+			return true
+		}
+
+		i := strings.Index(candidate, ".")
+		if i < 0 {
+			return false
+		}
+		candidate = candidate[i+1:]
+	}
+}
+
+func (s domainSet) len() int {
+	return len(s.suffixes) + len(s.wildcards)
+}
+
+type DomainBlocklist struct {
+	mu      sync.RWMutex
+	blocked domainSet
+	allowed domainSet
+}
+
+func NewDomainBlocklist(blocked []string, allowed []string) *DomainBlocklist {
+	return &DomainBlocklist{
+		blocked: newDomainSet(blocked),
+		allowed: newDomainSet(allowed),
+	}
+}
+
+// Replace swaps both lists at once, so concurrent lookups see either the old
+// or the new lists but never a mix of the two.
+func (b *DomainBlocklist) Replace(blocked []string, allowed []string) {
+	blockedSet := newDomainSet(blocked)
+	allowedSet := newDomainSet(allowed)
+
+	b.mu.Lock()
+	b.blocked = blockedSet
+	b.allowed = allowedSet
+	b.mu.Unlock()
+}
+
+// IsBlocked reports whether the domain is on the blocklist and not overridden
+// by the allowlist.
+func (b *DomainBlocklist) IsBlocked(domain string) bool {
+	domain = NormalizeDomainEntry(domain)
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if b.allowed.matches(domain) {
+		return false
+	}
+
+	return b.blocked.matches(domain)
+}
+
+func (b *DomainBlocklist) Len() (blocked int, allowed int) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	return b.blocked.len(), b.allowed.len()
+}
+
+func NormalizeDomainEntry(entry string) string {
+	entry = strings.ToLower(strings.TrimSpace(entry))
+	entry = strings.TrimPrefix(entry, "@")
+	return strings.TrimSuffix(entry, ".")
+}
+
+// ParseDomainList reads one domain per line, ignoring blank lines and
+// anything after a '#'.
+func ParseDomainList(r io.Reader) ([]string, error) {
+	var domains []string
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+
+		if entry := NormalizeDomainEntry(line); entry != "" {
+			domains = append(domains, entry)
+		}
+	}
+
+	return domains, scanner.Err()
+}
+
+func LoadDomainListFile(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return ParseDomainList(file)
+}
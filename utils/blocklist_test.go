@@ -0,0 +1,164 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_DomainBlocklist_IsBlocked(t *testing.T) {
+	blocklist := NewDomainBlocklist(
+		[]string{"mailinator.com", "*.spammy.net", "Trashmail.COM."},
+		[]string{"corp.mailinator.com", "*.ok.spammy.net"},
+	)
+
+	cases := []struct {
+		name     string
+		domain   string
+		expected bool
+	}{
+		{
+			name:     "blocks an exact match",
+			domain:   "mailinator.com",
+			expected: true,
+		},
+		{
+			name:     "blocks a subdomain of a listed domain",
+			domain:   "x.mailinator.com",
+			expected: true,
+		},
+		{
+			name:     "blocks a deeply nested subdomain",
+			domain:   "a.b.c.mailinator.com",
+			expected: true,
+		},
+		{
+			name:     "matches case-insensitively",
+			domain:   "X.MailInator.com",
+			expected: true,
+		},
+		{
+			name:     "normalizes list entries",
+			domain:   "trashmail.com",
+			expected: true,
+		},
+		{
+			name:     "does not block a domain that merely ends with the same text",
+			domain:   "notmailinator.com",
+			expected: false,
+		},
+		{
+			name:     "wildcard blocks subdomains",
+			domain:   "x.spammy.net",
+			expected: true,
+		},
+		{
+			name:     "wildcard does not block the bare domain",
+			domain:   "spammy.net",
+			expected: false,
+		},
+		{
+			name:     "allowlist overrides a blocked parent",
+			domain:   "corp.mailinator.com",
+			expected: false,
+		},
+		{
+			name:     "allowlist override covers subdomains",
+			domain:   "eu.corp.mailinator.com",
+			expected: false,
+		},
+		{
+			name:     "allowlist wildcard overrides a blocked wildcard",
+			domain:   "a.ok.spammy.net",
+			expected: false,
+		},
+		{
+			name:     "does not block an unlisted domain",
+			domain:   "fender.com",
+			expected: false,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			res := blocklist.IsBlocked(c.domain)
+
+			if diff := cmp.Diff(c.expected, res); diff != "" {
+				t.Errorf("\nUnexpected result (-want, +got)\n%s", diff)
+			}
+		})
+	}
+}
+
+func Test_DomainBlocklist_Replace(t *testing.T) {
+	blocklist := NewDomainBlocklist([]string{"mailinator.com"}, nil)
+	blocklist.Replace([]string{"yopmail.com"}, []string{"fender.com"})
+
+	cases := []struct {
+		name     string
+		domain   string
+		expected bool
+	}{
+		{
+			name:     "drops entries missing from the new list",
+			domain:   "mailinator.com",
+			expected: false,
+		},
+		{
+			name:     "adds entries from the new list",
+			domain:   "yopmail.com",
+			expected: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			res := blocklist.IsBlocked(c.domain)
+
+			if diff := cmp.Diff(c.expected, res); diff != "" {
+				t.Errorf("\nUnexpected result (-want, +got)\n%s", diff)
+			}
+		})
+	}
+
+	blocked, allowed := blocklist.Len()
+	if diff := cmp.Diff([]int{1, 1}, []int{blocked, allowed}); diff != "" {
+		t.Errorf("\nUnexpected list sizes (-want, +got)\n%s", diff)
+	}
+}
+
+func Test_ParseDomainList(t *testing.T) {
+	cases := []struct {
+		name     string
+		input    string
+		expected []string
+	}{
+		{
+			name:     "reads one domain per line",
+			input:    "mailinator.com\nyopmail.com\n",
+			expected: []string{"mailinator.com", "yopmail.com"},
+		},
+		{
+			name:     "skips comments and blank lines",
+			input:    "# disposable domains\n\nmailinator.com # popular\n   \n",
+			expected: []string{"mailinator.com"},
+		},
+		{
+			name:     "normalizes entries",
+			input:    "  @YopMail.com.\n*.Spammy.net\r\n",
+			expected: []string{"yopmail.com", "*.spammy.net"},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			res, err := ParseDomainList(strings.NewReader(c.input))
+			AssertErrorsEqual(t, nil, err)
+
+			if diff := cmp.Diff(c.expected, res); diff != "" {
+				t.Errorf("\nUnexpected domains (-want, +got)\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package utils
+
+import (
+	"strings"
+	"sync"
+)
+
+// ProviderRule describes how a mail provider treats local parts, so addresses
+// the provider delivers to the same mailbox share one canonical form.
+type ProviderRule struct {
+	StripDots       bool   `json:"stripDots"`
+	CanonicalDomain string `json:"canonicalDomain"`
+}
+
+var (
+	providerRulesMu sync.RWMutex
+	providerRules   = DefaultProviderRules()
+)
+
+func DefaultProviderRules() map[string]ProviderRule {
+	return map[string]ProviderRule{
+		"gmail.com":      {StripDots: true},
+		"googlemail.com": {StripDots: true, CanonicalDomain: "gmail.com"},
+	}
+}
+
+func SetProviderRules(rules map[string]ProviderRule) {
+	normalized := make(map[string]ProviderRule, len(rules))
+	for domain, rule := range rules {
+		rule.CanonicalDomain = strings.ToLower(rule.CanonicalDomain)
+		normalized[strings.ToLower(domain)] = rule
+	}
+
+	providerRulesMu.Lock()
+	providerRules = normalized
+	providerRulesMu.Unlock()
+}
+
+// CanonicalDomain returns the domain the provider rules treat domain as, in
+// lower case.
+func CanonicalDomain(domain string) string {
+	domain = strings.ToLower(domain)
+
+	providerRulesMu.RLock()
+	rule, ok := providerRules[domain]
+	providerRulesMu.RUnlock()
+
+	if ok && rule.CanonicalDomain != "" {
+		return rule.CanonicalDomain
+	}
+	return domain
+}
+
+// CanonicalizeEmail returns the form used to decide whether two addresses
+// belong to the same person: the whole address lowercased, the plus-address
+// tag dropped where the alias policy is dedupe, and the local part rewritten
+// according to the provider's rule.
+func CanonicalizeEmail(email Email) string {
+	localPart := strings.ToLower(email.LocalPart)
+	domain := strings.ToLower(email.Domain)
+
+	if AliasPolicyFor(domain) == AliasDedupe {
+		localPart = StripAlias(localPart)
+	}
+
+	providerRulesMu.RLock()
+	rule, ok := providerRules[domain]
+	providerRulesMu.RUnlock()
+
+	if ok {
+		if rule.StripDots {
+			localPart = strings.ReplaceAll(localPart, ".", "")
+		}
+
+		if rule.CanonicalDomain != "" {
+			domain = rule.CanonicalDomain
+		}
+	}
+
+	return localPart + "@" + domain
+}
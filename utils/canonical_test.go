@@ -0,0 +1,64 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_CanonicalizeEmail(t *testing.T) {
+	cases := []struct {
+		name     string
+		rules    map[string]ProviderRule
+		input    Email
+		expected string
+	}{
+		{
+			name:     "lowercases the whole address",
+			input:    Email{LocalPart: "Leo", Domain: "Fender.com"},
+			expected: "leo@fender.com",
+		},
+		{
+			name:     "keeps dots for providers without a rule",
+			input:    Email{LocalPart: "leo.fender", Domain: "fender.com"},
+			expected: "leo.fender@fender.com",
+		},
+		{
+			name:     "strips dots for gmail",
+			input:    Email{LocalPart: "Leo.Fender", Domain: "gmail.com"},
+			expected: "leofender@gmail.com",
+		},
+		{
+			name:     "maps googlemail to gmail",
+			input:    Email{LocalPart: "leo.fender", Domain: "googlemail.com"},
+			expected: "leofender@gmail.com",
+		},
+		{
+			name:     "uses configured rules",
+			rules:    map[string]ProviderRule{"Fender.com": {StripDots: true}},
+			input:    Email{LocalPart: "leo.fender", Domain: "fender.com"},
+			expected: "leofender@fender.com",
+		},
+		{
+			name:     "configured rules replace the defaults",
+			rules:    map[string]ProviderRule{"fender.com": {StripDots: true}},
+			input:    Email{LocalPart: "leo.fender", Domain: "gmail.com"},
+			expected: "leo.fender@gmail.com",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if c.rules != nil {
+				SetProviderRules(c.rules)
+				defer SetProviderRules(DefaultProviderRules())
+			}
+
+			res := CanonicalizeEmail(c.input)
+
+			if diff := cmp.Diff(c.expected, res); diff != "" {
+				t.Errorf("\nUnexpected canonical email (-want, +got)\n%s", diff)
+			}
+		})
+	}
+}
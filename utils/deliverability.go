@@ -0,0 +1,111 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const deliverabilityLookupTimeout = 2 * time.Second
+
+// Resolver is the subset of *net.Resolver used for deliverability checks, so
+// tests can swap in an in-memory zone.
+type Resolver interface {
+	LookupMX(ctx context.Context, name string) ([]*net.MX, error)
+	LookupHost(ctx context.Context, host string) ([]string, error)
+}
+
+type Deliverability struct {
+	Deliverable bool
+	Reason      string
+}
+
+type deliverabilityEntry struct {
+	result  Deliverability
+	expires time.Time
+}
+
+type DeliverabilityChecker struct {
+	resolver Resolver
+	ttl      time.Duration
+	now      func() time.Time
+	mu       sync.Mutex
+	cache    map[string]deliverabilityEntry
+}
+
+func NewDeliverabilityChecker(resolver Resolver, ttl time.Duration) *DeliverabilityChecker {
+	return &DeliverabilityChecker{
+		resolver: resolver,
+		ttl:      ttl,
+		now:      time.Now,
+		cache:    map[string]deliverabilityEntry{},
+	}
+}
+
+// Check reports whether the domain can receive mail, looking for MX records and
+// falling back to A/AAAA records as described in RFC 5321 section 5.1.
+// Temporary resolver failures are treated as deliverable and are not cached,
+// so a flaky resolver never locks users out.
+func (d *DeliverabilityChecker) Check(domain string) Deliverability {
+	domain = strings.TrimSuffix(strings.ToLower(domain), ".")
+
+	d.mu.Lock()
+	entry, ok := d.cache[domain]
+	d.mu.Unlock()
+	if ok && d.now().Before(entry.expires) {
+		return entry.result
+	}
+
+	result, cacheable := d.lookup(domain)
+	if cacheable {
+		d.mu.Lock()
+		d.cache[domain] = deliverabilityEntry{result: result, expires: d.now().Add(d.ttl)}
+		d.mu.Unlock()
+	}
+
+	return result
+}
+
+func (d *DeliverabilityChecker) lookup(domain string) (Deliverability, bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), deliverabilityLookupTimeout)
+	defer cancel()
+
+	mxs, err := d.resolver.LookupMX(ctx, domain)
+	if err != nil && !isNotFound(err) {
+		return Deliverability{Deliverable: true}, false
+	}
+
+	if len(mxs) == 1 && (mxs[0].Host == "." || mxs[0].Host == "") {
+		return Deliverability{
+			Deliverable: false,
+			Reason:      fmt.Sprintf("domain %s publishes a null MX record and does not accept mail", domain),
+		}, true
+	}
+
+	if len(mxs) > 0 {
+		return Deliverability{Deliverable: true}, true
+	}
+
+	hosts, err := d.resolver.LookupHost(ctx, domain)
+	if err != nil && !isNotFound(err) {
+		return Deliverability{Deliverable: true}, false
+	}
+
+	if len(hosts) > 0 {
+		return Deliverability{Deliverable: true}, true
+	}
+
+	return Deliverability{
+		Deliverable: false,
+		Reason:      fmt.Sprintf("domain %s has no MX, A or AAAA records", domain),
+	}, true
+}
+
+func isNotFound(err error) bool {
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr) && dnsErr.IsNotFound
+}
@@ -0,0 +1,133 @@
+package utils
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func testResolver() *InMemoryResolver {
+	return &InMemoryResolver{
+		MX: map[string][]*net.MX{
+			"fender.com":   {{Host: "mx1.fender.com.", Pref: 10}},
+			"nomail.com":   {{Host: ".", Pref: 0}},
+			"flaky-mx.com": {{Host: "mx.flaky-mx.com.", Pref: 10}},
+		},
+		Hosts: map[string][]string{
+			"tcell.io": {"192.0.2.10"},
+		},
+		Failures: map[string]error{
+			"timeout.com": &net.DNSError{Err: "i/o timeout", Name: "timeout.com", IsTimeout: true},
+			"broken.com":  errors.New("resolver exploded"),
+		},
+	}
+}
+
+func Test_DeliverabilityChecker_Check(t *testing.T) {
+	cases := []struct {
+		name     string
+		domain   string
+		expected Deliverability
+	}{
+		{
+			name:     "domain with MX records is deliverable",
+			domain:   "fender.com",
+			expected: Deliverability{Deliverable: true},
+		},
+		{
+			name:     "domain is matched case-insensitively",
+			domain:   "Fender.COM.",
+			expected: Deliverability{Deliverable: true},
+		},
+		{
+			name:     "domain without MX falls back to address records",
+			domain:   "tcell.io",
+			expected: Deliverability{Deliverable: true},
+		},
+		{
+			name:   "domain with a null MX is not deliverable",
+			domain: "nomail.com",
+			expected: Deliverability{
+				Deliverable: false,
+				Reason:      "domain nomail.com publishes a null MX record and does not accept mail",
+			},
+		},
+		{
+			name:   "domain with no records is not deliverable",
+			domain: "doesnotexist.example",
+			expected: Deliverability{
+				Deliverable: false,
+				Reason:      "domain doesnotexist.example has no MX, A or AAAA records",
+			},
+		},
+		{
+			name:     "temporary resolver failure fails open",
+			domain:   "timeout.com",
+			expected: Deliverability{Deliverable: true},
+		},
+		{
+			name:     "unexpected resolver failure fails open",
+			domain:   "broken.com",
+			expected: Deliverability{Deliverable: true},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			checker := NewDeliverabilityChecker(testResolver(), time.Hour)
+			res := checker.Check(c.domain)
+
+			if diff := cmp.Diff(c.expected, res); diff != "" {
+				t.Errorf("\nUnexpected deliverability (-want, +got)\n%s", diff)
+			}
+		})
+	}
+}
+
+func Test_DeliverabilityChecker_cache(t *testing.T) {
+	cases := []struct {
+		name     string
+		domain   string
+		advance  time.Duration
+		expected int
+	}{
+		{
+			name:     "caches definitive answers",
+			domain:   "doesnotexist.example",
+			advance:  time.Minute,
+			expected: 2,
+		},
+		{
+			name:     "looks up again after the cache entry expires",
+			domain:   "doesnotexist.example",
+			advance:  2 * time.Hour,
+			expected: 4,
+		},
+		{
+			name:     "does not cache temporary failures",
+			domain:   "timeout.com",
+			advance:  time.Minute,
+			expected: 2,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			resolver := testResolver()
+			now := time.Date(2021, 1, 5, 12, 0, 0, 0, time.UTC)
+			checker := NewDeliverabilityChecker(resolver, time.Hour)
+			checker.now = func() time.Time { return now }
+
+			checker.Check(c.domain)
+			now = now.Add(c.advance)
+			checker.Check(c.domain)
+
+			if diff := cmp.Diff(c.expected, resolver.Lookups); diff != "" {
+				t.Errorf("\nUnexpected lookup count (-want, +got)\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"regexp"
+	"strings"
+)
+
+var (
+	userAgentVersions = regexp.MustCompile(`[0-9]+([._][0-9]+)*`)
+	userAgentSpaces   = regexp.MustCompile(`\s+`)
+)
+
+// Checked in order, since most browsers also claim to be the ones they were
+// built from: Edge says it is Chrome, and Chrome says it is Safari.
+var (
+	userAgentBrowsers = []struct{ token, name string }{
+		{"edg/", "Edge"},
+		{"opr/", "Opera"},
+		{"firefox/", "Firefox"},
+		{"fxios/", "Firefox"},
+		{"crios/", "Chrome"},
+		{"chrome/", "Chrome"},
+		{"safari/", "Safari"},
+		{"curl/", "curl"},
+	}
+
+	userAgentSystems = []struct{ token, name string }{
+		{"iphone", "iOS"},
+		{"ipad", "iPadOS"},
+		{"android", "Android"},
+		{"windows", "Windows"},
+		{"mac os x", "macOS"},
+		{"cros", "ChromeOS"},
+		{"linux", "Linux"},
+	}
+)
+
+// DeviceFingerprint identifies the kind of device behind a user agent. Version
+// numbers are dropped so that browser and OS updates do not look like a new
+// device; the IP is left out because it changes with the network.
+func DeviceFingerprint(userAgent string) string {
+	normalized := strings.ToLower(userAgent)
+	normalized = userAgentVersions.ReplaceAllString(normalized, "")
+	normalized = strings.TrimSpace(userAgentSpaces.ReplaceAllString(normalized, " "))
+
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:16])
+}
+
+// DescribeDevice names the browser and operating system in a user agent for
+// people to read, such as "Chrome on macOS".
+func DescribeDevice(userAgent string) string {
+	lower := strings.ToLower(userAgent)
+
+	browser := "Unknown browser"
+	for _, candidate := range userAgentBrowsers {
+		if strings.Contains(lower, candidate.token) {
+			browser = candidate.name
+			break
+		}
+	}
+
+	for _, candidate := range userAgentSystems {
+		if strings.Contains(lower, candidate.token) {
+			return browser + " on " + candidate.name
+		}
+	}
+
+	return browser
+}
@@ -0,0 +1,45 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+const (
+	chromeMac      = "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/87.0.4280.88 Safari/537.36"
+	chromeMacLater = "Mozilla/5.0 (Macintosh; Intel Mac OS X 11_1_0) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/88.0.4324.96 Safari/537.36"
+	edgeWindows    = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/87.0.4280.88 Safari/537.36 Edg/87.0.664.66"
+	safariIPhone   = "Mozilla/5.0 (iPhone; CPU iPhone OS 14_3 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/14.0.2 Mobile/15E148 Safari/604.1"
+)
+
+func Test_DeviceFingerprint(t *testing.T) {
+	if DeviceFingerprint(chromeMac) != DeviceFingerprint(chromeMacLater) {
+		t.Error("expected browser and OS updates to keep the fingerprint")
+	}
+
+	if DeviceFingerprint(chromeMac) == DeviceFingerprint(edgeWindows) {
+		t.Error("expected different browsers to have different fingerprints")
+	}
+}
+
+func Test_DescribeDevice(t *testing.T) {
+	cases := []struct {
+		userAgent string
+		expected  string
+	}{
+		{userAgent: chromeMac, expected: "Chrome on macOS"},
+		{userAgent: edgeWindows, expected: "Edge on Windows"},
+		{userAgent: safariIPhone, expected: "Safari on iOS"},
+		{userAgent: "curl/7.64.1", expected: "curl"},
+		{userAgent: "", expected: "Unknown browser"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.expected, func(t *testing.T) {
+			if diff := cmp.Diff(c.expected, DescribeDevice(c.userAgent)); diff != "" {
+				t.Errorf("\nUnexpected description (-want, +got)\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,227 @@
+package utils
+
+import (
+	"net"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+const (
+	maxEmailLength     = 254
+	maxLocalPartLength = 64
+	maxDomainLength    = 253
+	maxLabelLength     = 63
+	acePrefix          = "xn--"
+	atextSpecials      = "!#$%&'*+-/=?^_`{|}~"
+)
+
+type Email struct {
+	LocalPart string
+	Domain    string
+	// SMTPUTF8 is set when the local part contains non-ASCII characters, which
+	// can only be delivered by servers supporting RFC 6531.
+	SMTPUTF8 bool
+}
+
+// ParseEmail parses an addr-spec following RFC 5322 as extended by RFC 6531.
+// The local part may be a dot-atom or a quoted string and may contain UTF-8.
+// The domain is NFC-normalized, lowercased and converted to its ASCII form,
+// with internationalized labels punycode-encoded, so Domain is always safe to
+// compare and to hand to a resolver. Length limits follow RFC 5321.
+func ParseEmail(email string) (Email, error) {
+	if len(email) > maxEmailLength || !utf8.ValidString(email) {
+		return Email{}, InvalidEmailError(email)
+	}
+
+	localPart, domain, ok := splitAddress(email)
+	if !ok || len(localPart) > maxLocalPartLength {
+		return Email{}, InvalidEmailError(email)
+	}
+
+	asciiDomain, ok := parseDomain(domain)
+	if !ok {
+		return Email{}, InvalidEmailError(email)
+	}
+
+	return Email{
+		LocalPart: localPart,
+		Domain:    asciiDomain,
+		SMTPUTF8:  !isASCII(localPart),
+	}, nil
+}
+
+func splitAddress(email string) (string, string, bool) {
+	if strings.HasPrefix(email, `"`) {
+		end, ok := quotedStringEnd(email)
+		if !ok || end >= len(email) || email[end] != '@' {
+			return "", "", false
+		}
+		return email[:end], email[end+1:], true
+	}
+
+	i := strings.LastIndex(email, "@")
+	if i < 0 || !isDotAtom(email[:i]) {
+		return "", "", false
+	}
+	return email[:i], email[i+1:], true
+}
+
+// quotedStringEnd returns the index just past the closing quote of the quoted
+// string at the start of s.
+func quotedStringEnd(s string) (int, bool) {
+	escaped := false
+	for i, r := range s[1:] {
+		switch {
+		case escaped:
+			if !isVisible(r) && r != ' ' && r != '\t' {
+				return 0, false
+			}
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == '"':
+			return i + 2, true
+		case !isVisible(r) && r != ' ' && r != '\t':
+			return 0, false
+		}
+	}
+	return 0, false
+}
+
+func isDotAtom(s string) bool {
+	if s == "" || strings.HasPrefix(s, ".") || strings.HasSuffix(s, ".") || strings.Contains(s, "..") {
+		return false
+	}
+
+	for _, r := range s {
+		if r != '.' && !isAtext(r) {
+			return false
+		}
+	}
+	return true
+}
+
+func isAtext(r rune) bool {
+	if r >= utf8.RuneSelf {
+		return isVisible(r)
+	}
+	return r >= 'a' && r <= 'z' ||
+		r >= 'A' && r <= 'Z' ||
+		r >= '0' && r <= '9' ||
+		strings.ContainsRune(atextSpecials, r)
+}
+
+func isVisible(r rune) bool {
+	if r < utf8.RuneSelf {
+		return r > ' ' && r < 0x7f
+	}
+	return unicode.IsGraphic(r) && !unicode.IsSpace(r)
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+func parseDomain(domain string) (string, bool) {
+	if strings.HasPrefix(domain, "[") {
+		return parseDomainLiteral(domain)
+	}
+
+	domain = strings.ToLower(norm.NFC.String(domain))
+	domain = strings.NewReplacer("。", ".", "．", ".", "｡", ".").Replace(domain)
+
+	labels := strings.Split(domain, ".")
+	if len(labels) < 2 {
+		return "", false
+	}
+
+	for i, label := range labels {
+		asciiLabel, ok := toASCIILabel(label)
+		if !ok {
+			return "", false
+		}
+		labels[i] = asciiLabel
+	}
+
+	if !isValidTLD(labels[len(labels)-1]) {
+		return "", false
+	}
+
+	asciiDomain := strings.Join(labels, ".")
+	if len(asciiDomain) > maxDomainLength {
+		return "", false
+	}
+
+	return asciiDomain, true
+}
+
+func parseDomainLiteral(domain string) (string, bool) {
+	if !strings.HasSuffix(domain, "]") {
+		return "", false
+	}
+
+	literal := domain[1 : len(domain)-1]
+	if strings.HasPrefix(strings.ToLower(literal), "ipv6:") {
+		ip := net.ParseIP(literal[len("ipv6:"):])
+		if ip == nil || ip.To4() != nil {
+			return "", false
+		}
+		return "[IPv6:" + ip.String() + "]", true
+	}
+
+	ip := net.ParseIP(literal)
+	if ip == nil || ip.To4() == nil || strings.Contains(literal, ":") {
+		return "", false
+	}
+	return "[" + ip.String() + "]", true
+}
+
+func toASCIILabel(label string) (string, bool) {
+	if label == "" || strings.HasPrefix(label, "-") || strings.HasSuffix(label, "-") {
+		return "", false
+	}
+
+	if isASCII(label) {
+		for _, r := range label {
+			if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-') {
+				return "", false
+			}
+		}
+		return label, len(label) <= maxLabelLength
+	}
+
+	for _, r := range label {
+		if !unicode.IsLetter(r) && !unicode.IsMark(r) && !unicode.IsDigit(r) && r != '-' {
+			return "", false
+		}
+	}
+
+	encoded, ok := punycodeEncode(label)
+	if !ok {
+		return "", false
+	}
+
+	asciiLabel := acePrefix + encoded
+	return asciiLabel, len(asciiLabel) <= maxLabelLength
+}
+
+func isValidTLD(tld string) bool {
+	if strings.HasPrefix(tld, acePrefix) {
+		return true
+	}
+
+	for _, r := range tld {
+		if r < 'a' || r > 'z' {
+			return false
+		}
+	}
+	return len(tld) >= 2
+}
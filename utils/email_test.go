@@ -0,0 +1,289 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_ParseEmail_RFC(t *testing.T) {
+	longLocal := strings.Repeat("a", 64)
+	longLabel := strings.Repeat("b", 63)
+
+	cases := []struct {
+		name     string
+		input    string
+		err      error
+		expected Email
+	}{
+		{
+			name:     "dot-atom local part with specials",
+			input:    "o'reilly.j_smith!#$%&*/=?^`{|}~-@fender.com",
+			expected: Email{LocalPart: "o'reilly.j_smith!#$%&*/=?^`{|}~-", Domain: "fender.com"},
+		},
+		{
+			name:     "quoted local part with spaces and an at sign",
+			input:    `"leo fender@home"@fender.com`,
+			expected: Email{LocalPart: `"leo fender@home"`, Domain: "fender.com"},
+		},
+		{
+			name:     "quoted local part with escaped quote",
+			input:    `"leo\"fender"@fender.com`,
+			expected: Email{LocalPart: `"leo\"fender"`, Domain: "fender.com"},
+		},
+		{
+			name:     "quoted local part may contain consecutive dots",
+			input:    `"leo..fender"@fender.com`,
+			expected: Email{LocalPart: `"leo..fender"`, Domain: "fender.com"},
+		},
+		{
+			name:     "domain is lowercased",
+			input:    "Leo@Fender.COM",
+			expected: Email{LocalPart: "Leo", Domain: "fender.com"},
+		},
+		{
+			name:     "internationalized domain is converted to punycode",
+			input:    "leo@bücher.de",
+			expected: Email{LocalPart: "leo", Domain: "xn--bcher-kva.de"},
+		},
+		{
+			name:     "internationalized TLD is converted to punycode",
+			input:    "leo@例え.テスト",
+			expected: Email{LocalPart: "leo", Domain: "xn--r8jz45g.xn--zckzah"},
+		},
+		{
+			name:     "ideographic full stop separates labels",
+			input:    "leo@例え。テスト",
+			expected: Email{LocalPart: "leo", Domain: "xn--r8jz45g.xn--zckzah"},
+		},
+		{
+			name:     "decomposed characters are normalized before encoding",
+			input:    "leo@bu\u0308cher.de",
+			expected: Email{LocalPart: "leo", Domain: "xn--bcher-kva.de"},
+		},
+		{
+			name:     "unicode local part requires SMTPUTF8",
+			input:    "josé@fender.com",
+			expected: Email{LocalPart: "josé", Domain: "fender.com", SMTPUTF8: true},
+		},
+		{
+			name:     "long TLD is accepted",
+			input:    "leo@fender.international",
+			expected: Email{LocalPart: "leo", Domain: "fender.international"},
+		},
+		{
+			name:     "IPv4 domain literal",
+			input:    "leo@[192.0.2.1]",
+			expected: Email{LocalPart: "leo", Domain: "[192.0.2.1]"},
+		},
+		{
+			name:     "IPv6 domain literal",
+			input:    "leo@[IPv6:2001:db8::1]",
+			expected: Email{LocalPart: "leo", Domain: "[IPv6:2001:db8::1]"},
+		},
+		{
+			name:     "local part at the length limit",
+			input:    longLocal + "@fender.com",
+			expected: Email{LocalPart: longLocal, Domain: "fender.com"},
+		},
+		{
+			name:     "label at the length limit",
+			input:    "leo@" + longLabel + ".com",
+			expected: Email{LocalPart: "leo", Domain: longLabel + ".com"},
+		},
+		{
+			name:  "consecutive dots in local part",
+			input: "leo..fender@fender.com",
+			err:   InvalidEmailError("leo..fender@fender.com"),
+		},
+		{
+			name:  "leading dot in local part",
+			input: ".leo@fender.com",
+			err:   InvalidEmailError(".leo@fender.com"),
+		},
+		{
+			name:  "trailing dot in local part",
+			input: "leo.@fender.com",
+			err:   InvalidEmailError("leo.@fender.com"),
+		},
+		{
+			name:  "consecutive dots in domain",
+			input: "leo@fender..com",
+			err:   InvalidEmailError("leo@fender..com"),
+		},
+		{
+			name:  "trailing dot in domain",
+			input: "leo@fender.com.",
+			err:   InvalidEmailError("leo@fender.com."),
+		},
+		{
+			name:  "unquoted space",
+			input: "leo fender@fender.com",
+			err:   InvalidEmailError("leo fender@fender.com"),
+		},
+		{
+			name:  "unquoted at sign",
+			input: "leo@fender@fender.com",
+			err:   InvalidEmailError("leo@fender@fender.com"),
+		},
+		{
+			name:  "unterminated quoted string",
+			input: `"leo@fender.com`,
+			err:   InvalidEmailError(`"leo@fender.com`),
+		},
+		{
+			name:  "text after quoted string",
+			input: `"leo"fender@fender.com`,
+			err:   InvalidEmailError(`"leo"fender@fender.com`),
+		},
+		{
+			name:  "control character",
+			input: "leo\x00@fender.com",
+			err:   InvalidEmailError("leo\x00@fender.com"),
+		},
+		{
+			name:  "hyphen at start of label",
+			input: "leo@-fender.com",
+			err:   InvalidEmailError("leo@-fender.com"),
+		},
+		{
+			name:  "underscore in domain",
+			input: "leo@fen_der.com",
+			err:   InvalidEmailError("leo@fen_der.com"),
+		},
+		{
+			name:  "numeric TLD",
+			input: "leo@fender.123",
+			err:   InvalidEmailError("leo@fender.123"),
+		},
+		{
+			name:  "symbol in internationalized label",
+			input: "leo@bü☃cher.de",
+			err:   InvalidEmailError("leo@bü☃cher.de"),
+		},
+		{
+			name:  "local part over the length limit",
+			input: longLocal + "a@fender.com",
+			err:   InvalidEmailError(longLocal + "a@fender.com"),
+		},
+		{
+			name:  "label over the length limit",
+			input: "leo@" + longLabel + "b.com",
+			err:   InvalidEmailError("leo@" + longLabel + "b.com"),
+		},
+		{
+			name:  "address over the length limit",
+			input: "leo@" + strings.Repeat(longLabel+".", 4) + "com",
+			err:   InvalidEmailError("leo@" + strings.Repeat(longLabel+".", 4) + "com"),
+		},
+		{
+			name:  "malformed IPv4 literal",
+			input: "leo@[192.0.2.300]",
+			err:   InvalidEmailError("leo@[192.0.2.300]"),
+		},
+		{
+			name:  "invalid UTF-8",
+			input: "leo\xff@fender.com",
+			err:   InvalidEmailError("leo\xff@fender.com"),
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			res, err := ParseEmail(c.input)
+
+			AssertErrorsEqual(t, c.err, err)
+
+			if diff := cmp.Diff(c.expected, res); diff != "" {
+				t.Errorf("\nUnexpected email (-want, +got)\n%s", diff)
+			}
+		})
+	}
+}
+
+func Test_punycodeEncode(t *testing.T) {
+	cases := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{
+			name:     "mixed basic and non-basic",
+			input:    "bücher",
+			expected: "bcher-kva",
+		},
+		{
+			name:     "another mixed label",
+			input:    "mañana",
+			expected: "maana-pta",
+		},
+		{
+			name:     "only non-basic code points",
+			input:    "例え",
+			expected: "r8jz45g",
+		},
+		{
+			name:     "katakana",
+			input:    "テスト",
+			expected: "zckzah",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			res, ok := punycodeEncode(c.input)
+			if !ok {
+				t.Fatalf("could not encode %q", c.input)
+			}
+
+			if diff := cmp.Diff(c.expected, res); diff != "" {
+				t.Errorf("\nUnexpected encoding (-want, +got)\n%s", diff)
+			}
+		})
+	}
+}
+
+func FuzzParseEmail(f *testing.F) {
+	for _, seed := range []string{
+		"leo@fender.com",
+		`"leo fender"@fender.com`,
+		"josé@bücher.de",
+		"leo@[IPv6:2001:db8::1]",
+		"leo..fender@fender.com",
+		"@fender.com",
+	} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, input string) {
+		email, err := ParseEmail(input)
+		if err != nil {
+			return
+		}
+
+		if len(input) > maxEmailLength {
+			t.Errorf("accepted %d byte address %q", len(input), input)
+		}
+
+		if email.LocalPart == "" || len(email.LocalPart) > maxLocalPartLength {
+			t.Errorf("accepted local part %q of %q", email.LocalPart, input)
+		}
+
+		if !isASCII(email.Domain) || len(email.Domain) > maxDomainLength {
+			t.Errorf("domain %q of %q is not a valid ASCII domain", email.Domain, input)
+		}
+
+		if !utf8.ValidString(email.LocalPart) {
+			t.Errorf("local part %q of %q is not valid UTF-8", email.LocalPart, input)
+		}
+
+		reparsed, err := ParseEmail(email.LocalPart + "@" + email.Domain)
+		if err != nil {
+			t.Errorf("could not reparse %q: %v", input, err)
+		} else if diff := cmp.Diff(email, reparsed); diff != "" {
+			t.Errorf("\nParsing is not idempotent for %q (-first, +second)\n%s", input, diff)
+		}
+	})
+}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"time"
 )
 
 type APIError struct {
@@ -76,6 +77,14 @@ func SaveUserToDBError(email string) error {
 	)
 }
 
+func EmailAlreadyRegisteredError(email string) error {
+	return NewAPIError(
+		fmt.Sprintf("an account with email %s already exists", email),
+		errors.New("email already registered"),
+		http.StatusConflict,
+	)
+}
+
 func UserNotFoundError(id string) error {
 	return NewAPIError(
 		fmt.Sprintf("user ID %s not found", id),
@@ -147,3 +156,349 @@ func LogoutFailedError(err error) error {
 		Code:    http.StatusInternalServerError,
 	}
 }
+
+// ReusedPasswordError describes the passwords a new one was checked against:
+// the current one and the depth remembered before it.
+func ReusedPasswordError(depth int) error {
+	message := "new password matches the current password, choose a different password"
+	if depth > 0 {
+		message = fmt.Sprintf("new password matches the current password or one of the %d before it, choose a different password", depth)
+	}
+
+	return NewAPIError(message, errors.New("password reused"), http.StatusBadRequest)
+}
+
+func UndeliverableEmailError(email string) error {
+	return NewAPIError(
+		fmt.Sprintf("undeliverable email %s, domain cannot receive mail", email),
+		errors.New("email domain cannot receive mail"),
+		http.StatusBadRequest,
+	)
+}
+
+func InvalidDomainRuleError(domain string, list string) error {
+	return NewAPIError(
+		fmt.Sprintf("invalid domain rule %s on list %s, list must be blocked or allowed", domain, list),
+		errors.New("invalid domain rule"),
+		http.StatusBadRequest,
+	)
+}
+
+func SaveDomainRuleError(domain string) error {
+	return NewAPIError(
+		fmt.Sprintf("error saving domain rule for %s", domain),
+		errors.New("error saving domain rule to database"),
+		http.StatusInternalServerError,
+	)
+}
+
+func DomainRuleNotFoundError(domain string, list string) error {
+	return NewAPIError(
+		fmt.Sprintf("domain %s not found on list %s", domain, list),
+		errors.New("domain rule not found"),
+		http.StatusNotFound,
+	)
+}
+
+func MalformedEmailBatchError(err error) error {
+	return NewAPIError(
+		"malformed email batch, expected a JSON array or one email per line",
+		err,
+		http.StatusBadRequest,
+	)
+}
+
+func EmptyEmailBatchError() error {
+	return NewAPIError(
+		"email batch is empty",
+		errors.New("no emails provided"),
+		http.StatusBadRequest,
+	)
+}
+
+func EmailBatchTooLargeError(size int, limit int) error {
+	return NewAPIError(
+		fmt.Sprintf("email batch of %d exceeds the limit of %d emails", size, limit),
+		errors.New("email batch too large"),
+		http.StatusRequestEntityTooLarge,
+	)
+}
+
+func NameLengthError(name string, min int, max int) error {
+	return NewAPIError(
+		fmt.Sprintf("invalid name %s, must be between %d and %d characters", name, min, max),
+		errors.New("name length out of range"),
+		http.StatusBadRequest,
+	)
+}
+
+func MixedScriptNameError(name string) error {
+	return NewAPIError(
+		fmt.Sprintf("invalid name %s, mixes characters from different scripts", name),
+		errors.New("name mixes scripts"),
+		http.StatusBadRequest,
+	)
+}
+
+func ProfaneNameError(name string) error {
+	return NewAPIError(
+		fmt.Sprintf("name %s is not allowed, contains offensive language", name),
+		errors.New("name contains offensive language"),
+		http.StatusUnprocessableEntity,
+	)
+}
+
+func ReservedNameError(name string) error {
+	return NewAPIError(
+		fmt.Sprintf("name %s is reserved", name),
+		errors.New("name is reserved"),
+		http.StatusUnprocessableEntity,
+	)
+}
+
+func ForbiddenError(permission string) error {
+	return APIError{
+		Message: fmt.Sprintf("forbidden, requires permission %s", permission),
+		Errors:  errors.New("forbidden"),
+		Code:    http.StatusForbidden,
+	}
+}
+
+func RoleNotFoundError(role string) error {
+	return NewAPIError(
+		fmt.Sprintf("role %s not found", role),
+		errors.New("role not found"),
+		http.StatusNotFound,
+	)
+}
+
+func UserRoleNotFoundError(id string, role string) error {
+	return NewAPIError(
+		fmt.Sprintf("user ID %s does not have role %s", id, role),
+		errors.New("user role not found"),
+		http.StatusNotFound,
+	)
+}
+
+func SaveRoleError(id string) error {
+	return NewAPIError(
+		fmt.Sprintf("error saving roles for user ID %s", id),
+		errors.New("error saving user roles to database"),
+		http.StatusInternalServerError,
+	)
+}
+
+func InvalidQueryParameterError(name string, value string) error {
+	return NewAPIError(
+		fmt.Sprintf("invalid value %q for query parameter %s", value, name),
+		errors.New("invalid query parameter"),
+		http.StatusBadRequest,
+	)
+}
+
+func ListUsersError() error {
+	return NewAPIError(
+		"error listing users",
+		errors.New("error querying users"),
+		http.StatusInternalServerError,
+	)
+}
+
+func UserNotDeletedError(id string) error {
+	return NewAPIError(
+		fmt.Sprintf("user ID %s is not deleted", id),
+		errors.New("user not deleted"),
+		http.StatusConflict,
+	)
+}
+
+func RestoreWindowExpiredError(id string) error {
+	return NewAPIError(
+		fmt.Sprintf("user ID %s was deleted too long ago to be restored", id),
+		errors.New("restore window expired"),
+		http.StatusGone,
+	)
+}
+
+func PurgeUsersError(err error) error {
+	return NewAPIError(
+		"error purging deleted users",
+		err,
+		http.StatusInternalServerError,
+	)
+}
+
+func UserExportNotFoundError(id string) error {
+	return NewAPIError(
+		fmt.Sprintf("export %s not found or expired", id),
+		errors.New("export not found"),
+		http.StatusNotFound,
+	)
+}
+
+func UserExportError(id string) error {
+	return NewAPIError(
+		fmt.Sprintf("error exporting data for user ID %s", id),
+		errors.New("error exporting user data"),
+		http.StatusInternalServerError,
+	)
+}
+
+func ProcessUserExportsError(err error) error {
+	return NewAPIError(
+		"error processing user exports",
+		err,
+		http.StatusInternalServerError,
+	)
+}
+
+func EraseUserError(id string) error {
+	return NewAPIError(
+		fmt.Sprintf("error erasing user ID %s, retry to resume", id),
+		errors.New("error erasing user"),
+		http.StatusInternalServerError,
+	)
+}
+
+func ReauthenticationRequiredError() error {
+	return NewAPIError(
+		"current password is required to change email",
+		errors.New("reauthentication required"),
+		http.StatusUnauthorized,
+	)
+}
+
+func EmailChangeNotFoundError(id string) error {
+	return NewAPIError(
+		fmt.Sprintf("no pending email change for user ID %s", id),
+		errors.New("email change not found"),
+		http.StatusNotFound,
+	)
+}
+
+// StepUpChallenge tells a client how to satisfy a step-up requirement. It is
+// carried by pointer so the APIError holding it stays comparable.
+type StepUpChallenge struct {
+	Error   string   `json:"error"`
+	MaxAge  int      `json:"maxAge"`
+	Methods []string `json:"methods"`
+}
+
+func StepUpRequiredError(maxAge time.Duration, methods []string) error {
+	return NewAPIError(
+		"recent authentication required",
+		&StepUpChallenge{
+			Error:   "insufficient_user_authentication",
+			MaxAge:  int(maxAge.Seconds()),
+			Methods: methods,
+		},
+		http.StatusUnauthorized,
+	)
+}
+
+func ListAuditEventsError() error {
+	return NewAPIError(
+		"error reading the audit log",
+		errors.New("error querying audit events"),
+		http.StatusInternalServerError,
+	)
+}
+
+func LoginHistoryError(id string) error {
+	return NewAPIError(
+		fmt.Sprintf("error reading login history for user ID %s", id),
+		errors.New("error querying login history"),
+		http.StatusInternalServerError,
+	)
+}
+
+// LoginChallenge tells a client that a login needs a second factor before a
+// token is issued. Like StepUpChallenge it is carried by pointer.
+type LoginChallenge struct {
+	Error       string    `json:"error"`
+	ChallengeID string    `json:"challengeId"`
+	Methods     []string  `json:"methods"`
+	ExpiresAt   time.Time `json:"expiresAt"`
+}
+
+func LoginChallengeRequiredError(challengeID string, methods []string, expiresAt time.Time) error {
+	return NewAPIError(
+		"additional verification required",
+		&LoginChallenge{
+			Error:       "mfa_required",
+			ChallengeID: challengeID,
+			Methods:     methods,
+			ExpiresAt:   expiresAt,
+		},
+		http.StatusUnauthorized,
+	)
+}
+
+func LoginChallengeFailedError() error {
+	return NewAPIError(
+		"invalid or expired code",
+		errors.New("login challenge failed"),
+		http.StatusUnauthorized,
+	)
+}
+
+func LoginSourceBlockedError(retryAfter time.Duration) error {
+	return NewAPIError(
+		"too many failed logins, try again later",
+		&RetryAfter{Seconds: int(retryAfter.Seconds())},
+		http.StatusTooManyRequests,
+	)
+}
+
+// RetryAfter says when a rate-limited request may be tried again.
+type RetryAfter struct {
+	Seconds int
+}
+
+func ListLoginBlocksError() error {
+	return NewAPIError(
+		"error reading login blocks",
+		errors.New("error querying login blocks"),
+		http.StatusInternalServerError,
+	)
+}
+
+func IPNotAllowedError(ip string) error {
+	return NewAPIError(
+		fmt.Sprintf("access from %s is not allowed", ip),
+		errors.New("source IP not allowed"),
+		http.StatusForbidden,
+	)
+}
+
+func InvalidIPRuleError(reason string) error {
+	return NewAPIError(
+		fmt.Sprintf("invalid IP rule: %s", reason),
+		errors.New("invalid IP rule"),
+		http.StatusBadRequest,
+	)
+}
+
+func IPRuleNotFoundError(id string) error {
+	return NewAPIError(
+		fmt.Sprintf("IP rule %s not found", id),
+		errors.New("IP rule not found"),
+		http.StatusNotFound,
+	)
+}
+
+func ListIPRulesError() error {
+	return NewAPIError(
+		"error reading IP rules",
+		errors.New("error querying IP rules"),
+		http.StatusInternalServerError,
+	)
+}
+
+func SaveIPRuleError() error {
+	return NewAPIError(
+		"error saving IP rules",
+		errors.New("error writing IP rules"),
+		http.StatusInternalServerError,
+	)
+}
@@ -0,0 +1,358 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net"
+)
+
+// GeoDB reads a MaxMind DB (MMDB) file, such as GeoLite2-City, entirely from
+// memory. Only what is needed to geolocate an IP is implemented: the search
+// tree and the data section decoder.
+type GeoDB struct {
+	buf        []byte
+	nodeCount  uint
+	recordSize uint
+	ipVersion  uint
+	dataStart  uint
+	ipv4Start  uint
+}
+
+// GeoLocation is where an IP was placed by the database. Databases without
+// coordinates, like GeoLite2-Country, leave HasCoordinates false.
+type GeoLocation struct {
+	Country        string
+	Latitude       float64
+	Longitude      float64
+	HasCoordinates bool
+}
+
+var geoMetadataMarker = []byte("\xab\xcd\xefMaxMind.com")
+
+const (
+	geoTypeExtended = iota
+	geoTypePointer
+	geoTypeString
+	geoTypeDouble
+	geoTypeBytes
+	geoTypeUint16
+	geoTypeUint32
+	geoTypeMap
+	geoTypeInt32
+	geoTypeUint64
+	geoTypeUint128
+	geoTypeArray
+	geoTypeContainer
+	geoTypeEndMarker
+	geoTypeBoolean
+	geoTypeFloat
+)
+
+func OpenGeoDB(path string) (*GeoDB, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewGeoDB(buf)
+}
+
+func NewGeoDB(buf []byte) (*GeoDB, error) {
+	markerAt := bytes.LastIndex(buf, geoMetadataMarker)
+	if markerAt < 0 {
+		return nil, errors.New("not a MaxMind DB: metadata marker not found")
+	}
+
+	db := &GeoDB{buf: buf}
+	metadata, _, err := db.decode(uint(markerAt+len(geoMetadataMarker)), 0)
+	if err != nil {
+		return nil, fmt.Errorf("reading MaxMind DB metadata: %v", err)
+	}
+
+	fields, _ := metadata.(map[string]interface{})
+	db.nodeCount = geoUint(fields["node_count"])
+	db.recordSize = geoUint(fields["record_size"])
+	db.ipVersion = geoUint(fields["ip_version"])
+
+	if db.recordSize != 24 && db.recordSize != 28 && db.recordSize != 32 {
+		return nil, fmt.Errorf("unsupported MaxMind DB record size %d", db.recordSize)
+	}
+
+	if db.ipVersion != 4 && db.ipVersion != 6 {
+		return nil, fmt.Errorf("unsupported MaxMind DB IP version %d", db.ipVersion)
+	}
+
+	// The data section follows the search tree and 16 bytes of padding.
+	db.dataStart = db.nodeCount*db.recordSize/4 + 16
+	if db.dataStart > uint(markerAt) {
+		return nil, errors.New("MaxMind DB search tree is larger than the file")
+	}
+
+	// IPv6 databases hold IPv4 addresses under ::/96.
+	if db.ipVersion == 6 {
+		node := uint(0)
+		for i := 0; i < 96 && node < db.nodeCount; i++ {
+			node = db.readRecord(node, 0)
+		}
+		db.ipv4Start = node
+	}
+
+	return db, nil
+}
+
+// Lookup places an IP, reporting false when the database has no entry for it.
+func (db *GeoDB) Lookup(ip net.IP) (GeoLocation, bool, error) {
+	address, node := ip.To4(), uint(0)
+	if address == nil {
+		if db.ipVersion == 4 {
+			return GeoLocation{}, false, nil
+		}
+		address = ip.To16()
+		if address == nil {
+			return GeoLocation{}, false, errors.New("invalid IP address")
+		}
+	} else if db.ipVersion == 6 {
+		node = db.ipv4Start
+	}
+
+	for i := 0; i < len(address)*8 && node < db.nodeCount; i++ {
+		bit := uint(address[i/8]>>(7-uint(i%8))) & 1
+		node = db.readRecord(node, bit)
+	}
+
+	if node == db.nodeCount {
+		return GeoLocation{}, false, nil
+	}
+
+	if node < db.nodeCount {
+		return GeoLocation{}, false, errors.New("MaxMind DB search tree ended inside a node")
+	}
+
+	record, _, err := db.decode(db.dataStart+node-db.nodeCount-16, 0)
+	if err != nil {
+		return GeoLocation{}, false, err
+	}
+
+	return geoLocation(record), true, nil
+}
+
+func (db *GeoDB) readRecord(node uint, bit uint) uint {
+	b := db.buf[node*db.recordSize/4:]
+
+	switch db.recordSize {
+	case 24:
+		b = b[bit*3:]
+		return uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+	case 28:
+		if bit == 0 {
+			return uint(b[3]&0xf0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+		}
+		return uint(b[3]&0x0f)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6])
+	default:
+		return uint(binary.BigEndian.Uint32(b[bit*4:]))
+	}
+}
+
+// decode reads the value at offset and returns it with the offset just past
+// it. Pointers are relative to the data section, except within the metadata,
+// which is decoded with a base of 0 and never contains them.
+func (db *GeoDB) decode(offset uint, depth int) (interface{}, uint, error) {
+	if depth > 32 {
+		return nil, 0, errors.New("MaxMind DB data nests too deeply")
+	}
+
+	if offset >= uint(len(db.buf)) {
+		return nil, 0, errors.New("MaxMind DB data offset out of range")
+	}
+
+	control := db.buf[offset]
+	offset++
+	kind := uint(control >> 5)
+
+	if kind == geoTypePointer {
+		target, next, err := db.pointer(control, offset)
+		if err != nil {
+			return nil, 0, err
+		}
+		value, _, err := db.decode(db.dataStart+target, depth+1)
+		return value, next, err
+	}
+
+	if kind == geoTypeExtended {
+		if offset >= uint(len(db.buf)) {
+			return nil, 0, errors.New("MaxMind DB data offset out of range")
+		}
+		kind = uint(db.buf[offset]) + 7
+		offset++
+	}
+
+	size, offset, err := db.size(control, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	switch kind {
+	case geoTypeMap:
+		fields := make(map[string]interface{}, size)
+		for i := uint(0); i < size; i++ {
+			key, next, err := db.decode(offset, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			value, next, err := db.decode(next, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			name, _ := key.(string)
+			fields[name] = value
+			offset = next
+		}
+		return fields, offset, nil
+	case geoTypeArray:
+		values := make([]interface{}, 0, size)
+		for i := uint(0); i < size; i++ {
+			value, next, err := db.decode(offset, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			values = append(values, value)
+			offset = next
+		}
+		return values, offset, nil
+	case geoTypeBoolean:
+		return size != 0, offset, nil
+	}
+
+	if offset+size > uint(len(db.buf)) {
+		return nil, 0, errors.New("MaxMind DB value runs past the end of the file")
+	}
+	b := db.buf[offset : offset+size]
+	next := offset + size
+
+	switch kind {
+	case geoTypeString:
+		return string(b), next, nil
+	case geoTypeBytes:
+		return b, next, nil
+	case geoTypeDouble:
+		if size != 8 {
+			return nil, 0, errors.New("MaxMind DB double is not 8 bytes")
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), next, nil
+	case geoTypeFloat:
+		if size != 4 {
+			return nil, 0, errors.New("MaxMind DB float is not 4 bytes")
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), next, nil
+	case geoTypeUint16, geoTypeUint32, geoTypeUint64, geoTypeInt32:
+		var n uint64
+		for _, c := range b {
+			n = n<<8 | uint64(c)
+		}
+		if kind == geoTypeInt32 {
+			return int64(int32(uint32(n))), next, nil
+		}
+		return n, next, nil
+	case geoTypeUint128:
+		// Too wide to be a location field, so it is kept as bytes.
+		return b, next, nil
+	}
+
+	return nil, 0, fmt.Errorf("unsupported MaxMind DB data type %d", kind)
+}
+
+func (db *GeoDB) size(control byte, offset uint) (uint, uint, error) {
+	size := uint(control & 0x1f)
+	if size < 29 {
+		return size, offset, nil
+	}
+
+	extra := size - 28
+	if offset+extra > uint(len(db.buf)) {
+		return 0, 0, errors.New("MaxMind DB size runs past the end of the file")
+	}
+
+	var n uint
+	for _, c := range db.buf[offset : offset+extra] {
+		n = n<<8 | uint(c)
+	}
+
+	switch size {
+	case 29:
+		return 29 + n, offset + extra, nil
+	case 30:
+		return 285 + n, offset + extra, nil
+	default:
+		return 65821 + n, offset + extra, nil
+	}
+}
+
+func (db *GeoDB) pointer(control byte, offset uint) (uint, uint, error) {
+	length := uint(control>>3)&0x3 + 1
+	if offset+length > uint(len(db.buf)) {
+		return 0, 0, errors.New("MaxMind DB pointer runs past the end of the file")
+	}
+
+	var n uint
+	if length < 4 {
+		n = uint(control & 0x7)
+	}
+	for _, c := range db.buf[offset : offset+length] {
+		n = n<<8 | uint(c)
+	}
+
+	switch length {
+	case 2:
+		n += 2048
+	case 3:
+		n += 526336
+	}
+
+	return n, offset + length, nil
+}
+
+func geoUint(value interface{}) uint {
+	n, _ := value.(uint64)
+	return uint(n)
+}
+
+func geoLocation(record interface{}) GeoLocation {
+	fields, _ := record.(map[string]interface{})
+	var location GeoLocation
+
+	for _, key := range []string{"country", "registered_country"} {
+		country, _ := fields[key].(map[string]interface{})
+		if code, ok := country["iso_code"].(string); ok && code != "" {
+			location.Country = code
+			break
+		}
+	}
+
+	coordinates, _ := fields["location"].(map[string]interface{})
+	latitude, hasLatitude := coordinates["latitude"].(float64)
+	longitude, hasLongitude := coordinates["longitude"].(float64)
+	if hasLatitude && hasLongitude {
+		location.Latitude = latitude
+		location.Longitude = longitude
+		location.HasCoordinates = true
+	}
+
+	return location
+}
+
+const earthRadiusKm = 6371.0
+
+// DistanceKm is the great-circle distance between two points.
+func DistanceKm(latitude1, longitude1, latitude2, longitude2 float64) float64 {
+	radians := func(degrees float64) float64 { return degrees * math.Pi / 180 }
+
+	dLatitude := radians(latitude2 - latitude1)
+	dLongitude := radians(longitude2 - longitude1)
+	a := math.Sin(dLatitude/2)*math.Sin(dLatitude/2) +
+		math.Cos(radians(latitude1))*math.Cos(radians(latitude2))*math.Sin(dLongitude/2)*math.Sin(dLongitude/2)
+
+	return 2 * earthRadiusKm * math.Atan2(math.Sqrt(a), math.Sqrt(1-a))
+}
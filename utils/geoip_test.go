@@ -0,0 +1,82 @@
+package utils
+
+import (
+	"math"
+	"net"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_GeoDB_Lookup(t *testing.T) {
+	networks := map[string]GeoLocation{
+		"203.0.113.0/24":  {Country: "US", Latitude: 34.05, Longitude: -118.24, HasCoordinates: true},
+		"198.51.100.0/25": {Country: "GB", Latitude: 51.51, Longitude: -0.13, HasCoordinates: true},
+		"192.0.2.0/24":    {Country: "JP"},
+		"2001:db8::/32":   {Country: "DE", Latitude: 52.52, Longitude: 13.40, HasCoordinates: true},
+	}
+
+	cases := []struct {
+		ip       string
+		expected GeoLocation
+		found    bool
+	}{
+		{ip: "203.0.113.7", expected: networks["203.0.113.0/24"], found: true},
+		{ip: "198.51.100.4", expected: networks["198.51.100.0/25"], found: true},
+		{ip: "198.51.100.200"},
+		{ip: "192.0.2.1", expected: networks["192.0.2.0/24"], found: true},
+		{ip: "2001:db8::1", expected: networks["2001:db8::/32"], found: true},
+		{ip: "10.0.0.1"},
+	}
+
+	for _, ipVersion := range []int{4, 6} {
+		db, err := NewGeoDB(BuildTestGeoDB(ipVersion, networks))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, c := range cases {
+			if ipVersion == 4 && c.ip == "2001:db8::1" {
+				c.expected, c.found = GeoLocation{}, false
+			}
+
+			t.Run(c.ip, func(t *testing.T) {
+				res, found, err := db.Lookup(net.ParseIP(c.ip))
+				AssertErrorsEqual(t, nil, err)
+
+				if diff := cmp.Diff([]interface{}{c.expected, c.found}, []interface{}{res, found}); diff != "" {
+					t.Errorf("\nUnexpected location in IPv%d database (-want, +got)\n%s", ipVersion, diff)
+				}
+			})
+		}
+	}
+}
+
+func Test_GeoDB_decodePointer(t *testing.T) {
+	db := &GeoDB{buf: []byte{geoTypeString<<5 | 2, 'U', 'S', geoTypePointer << 5, 0}}
+
+	value, next, err := db.decode(3, 0)
+	AssertErrorsEqual(t, nil, err)
+
+	if diff := cmp.Diff([]interface{}{"US", uint(5)}, []interface{}{value, next}); diff != "" {
+		t.Errorf("\nUnexpected value (-want, +got)\n%s", diff)
+	}
+}
+
+func Test_NewGeoDB(t *testing.T) {
+	if _, err := NewGeoDB([]byte("not a database")); err == nil {
+		t.Error("expected an error for a file without metadata")
+	}
+}
+
+func Test_DistanceKm(t *testing.T) {
+	// Los Angeles to London is about 8,760 km.
+	distance := DistanceKm(34.05, -118.24, 51.51, -0.13)
+	if math.Abs(distance-8760) > 20 {
+		t.Errorf("expected about 8760 km, got %.0f", distance)
+	}
+
+	if distance := DistanceKm(51.51, -0.13, 51.51, -0.13); distance != 0 {
+		t.Errorf("expected no distance between a point and itself, got %f", distance)
+	}
+}
@@ -0,0 +1,118 @@
+package utils
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+)
+
+// IPSet holds addresses and CIDR networks, such as a Tor exit node list or an
+// IP reputation feed.
+type IPSet struct {
+	addresses map[string]struct{}
+	networks  []*net.IPNet
+}
+
+func NewIPSet() *IPSet {
+	return &IPSet{addresses: map[string]struct{}{}}
+}
+
+// Add takes an address or a CIDR network.
+func (s *IPSet) Add(entry string) error {
+	if strings.Contains(entry, "/") {
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return fmt.Errorf("invalid network %q", entry)
+		}
+		s.networks = append(s.networks, network)
+		return nil
+	}
+
+	ip := net.ParseIP(entry)
+	if ip == nil {
+		return fmt.Errorf("invalid IP address %q", entry)
+	}
+	s.addresses[ip.String()] = struct{}{}
+	return nil
+}
+
+// Merge adds every entry of other to s.
+func (s *IPSet) Merge(other *IPSet) {
+	for address := range other.addresses {
+		s.addresses[address] = struct{}{}
+	}
+	s.networks = append(s.networks, other.networks...)
+}
+
+func (s *IPSet) Contains(ip net.IP) bool {
+	if s == nil || ip == nil {
+		return false
+	}
+
+	if _, ok := s.addresses[ip.String()]; ok {
+		return true
+	}
+
+	for _, network := range s.networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *IPSet) Len() int {
+	if s == nil {
+		return 0
+	}
+	return len(s.addresses) + len(s.networks)
+}
+
+// ParseIPList reads one address or CIDR network per line, ignoring blank
+// lines and anything after a '#'.
+func ParseIPList(r io.Reader) (*IPSet, error) {
+	set := NewIPSet()
+
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		entry := scanner.Text()
+		if i := strings.Index(entry, "#"); i >= 0 {
+			entry = entry[:i]
+		}
+
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+
+		if err := set.Add(entry); err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+	}
+
+	return set, scanner.Err()
+}
+
+func LoadIPListFile(path string) (*IPSet, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return ParseIPList(file)
+}
+
+// Subnet is the network an address most likely shares with its neighbours:
+// the /24 of an IPv4 address or the /64 of an IPv6 one.
+func Subnet(ip net.IP) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		return (&net.IPNet{IP: ip4.Mask(net.CIDRMask(24, 32)), Mask: net.CIDRMask(24, 32)}).String()
+	}
+	if ip16 := ip.To16(); ip16 != nil {
+		return (&net.IPNet{IP: ip16.Mask(net.CIDRMask(64, 128)), Mask: net.CIDRMask(64, 128)}).String()
+	}
+	return ""
+}
@@ -0,0 +1,56 @@
+package utils
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_ParseIPList(t *testing.T) {
+	set, err := ParseIPList(strings.NewReader("# exit nodes\n185.220.101.1\n\n2001:db8::7 # v6\n198.51.100.0/24\n"))
+	AssertErrorsEqual(t, nil, err)
+
+	cases := []struct {
+		ip       string
+		expected bool
+	}{
+		{ip: "185.220.101.1", expected: true},
+		{ip: "185.220.101.2"},
+		{ip: "2001:db8:0:0::7", expected: true},
+		{ip: "198.51.100.200", expected: true},
+		{ip: "198.51.101.1"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.ip, func(t *testing.T) {
+			if diff := cmp.Diff(c.expected, set.Contains(net.ParseIP(c.ip))); diff != "" {
+				t.Errorf("\nUnexpected membership (-want, +got)\n%s", diff)
+			}
+		})
+	}
+
+	if _, err := ParseIPList(strings.NewReader("185.220.101.1\nnot an address\n")); err == nil {
+		t.Error("expected an error for an invalid entry")
+	}
+}
+
+func Test_Subnet(t *testing.T) {
+	cases := []struct {
+		ip       string
+		expected string
+	}{
+		{ip: "198.51.100.77", expected: "198.51.100.0/24"},
+		{ip: "2001:db8:1:2:3:4:5:6", expected: "2001:db8:1:2::/64"},
+		{ip: "::ffff:198.51.100.77", expected: "198.51.100.0/24"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.ip, func(t *testing.T) {
+			if diff := cmp.Diff(c.expected, Subnet(net.ParseIP(c.ip))); diff != "" {
+				t.Errorf("\nUnexpected subnet (-want, +got)\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,48 @@
+package utils
+
+import (
+	"fmt"
+	"log"
+	"net/smtp"
+	"strings"
+)
+
+type Mail struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+type Mailer interface {
+	Send(mail Mail) error
+}
+
+// LogMailer stands in for a relay in local development and deployments
+// without one. It logs only the subject of each mail it drops, since bodies
+// carry single-use tokens and codes, and the recipient would outlive erasure
+// in the logs.
+type LogMailer struct{}
+
+func (LogMailer) Send(mail Mail) error {
+	log.Printf("\nNo SMTP relay configured, not sending mail: %s\n", mail.Subject)
+	return nil
+}
+
+type SMTPMailer struct {
+	Addr string
+	From string
+	Auth smtp.Auth
+}
+
+func (m SMTPMailer) Send(mail Mail) error {
+	if strings.ContainsAny(mail.To+mail.Subject, "\r\n") {
+		return fmt.Errorf("refusing to send mail with a line break in its headers")
+	}
+
+	message := fmt.Sprintf(
+		"From: %s\r\nTo: %s\r\nSubject: %s\r\nMIME-Version: 1.0\r\nContent-Type: text/plain; charset=UTF-8\r\n\r\n%s\r\n",
+		m.From, mail.To, mail.Subject, strings.ReplaceAll(mail.Body, "\n", "\r\n"),
+	)
+
+	return smtp.SendMail(m.Addr, m.Auth, m.From, []string{mail.To}, []byte(message))
+}
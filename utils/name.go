@@ -0,0 +1,139 @@
+package utils
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// namePunctuation is the punctuation that appears in real names: apostrophes,
+// hyphens, periods for initials, commas for suffixes and the middle dots used
+// in Catalan and in Japanese transliterations.
+const namePunctuation = "'’-‐.,·・"
+
+type NameRules struct {
+	MinLength   int
+	MaxLength   int
+	AllowDigits bool
+}
+
+// highlyRestrictiveScripts are the script combinations UTS #39 accepts at its
+// "highly restrictive" level. Any other mix, such as Latin with Cyrillic, is
+// how lookalike names are built.
+var highlyRestrictiveScripts = [][]string{
+	{"Latin", "Han", "Hiragana", "Katakana"},
+	{"Latin", "Han", "Bopomofo"},
+	{"Latin", "Han", "Hangul"},
+}
+
+// NormalizeName NFC-normalizes a name, trims it and collapses runs of spaces.
+func NormalizeName(name string) string {
+	return strings.Join(strings.FieldsFunc(norm.NFC.String(name), unicode.IsSpace), " ")
+}
+
+// ValidateName returns the normalized form of name, or an error when it
+// contains control or invisible characters, symbols, characters from a mix of
+// scripts that could be used to impersonate another name, or falls outside the
+// length limits. Length is counted in code points after normalization.
+func ValidateName(name string, rules NameRules) (string, error) {
+	for _, r := range name {
+		if isInvisible(r) {
+			return "", InvalidNameError(name)
+		}
+	}
+
+	normalized := NormalizeName(name)
+	for _, r := range normalized {
+		if !isNameRune(r, rules.AllowDigits) {
+			return "", InvalidNameError(name)
+		}
+	}
+
+	length := utf8.RuneCountInString(normalized)
+	if length < rules.MinLength || rules.MaxLength > 0 && length > rules.MaxLength {
+		return "", NameLengthError(name, rules.MinLength, rules.MaxLength)
+	}
+
+	if !isSingleScript(normalized) {
+		return "", MixedScriptNameError(name)
+	}
+
+	return normalized, nil
+}
+
+func isInvisible(r rune) bool {
+	return r == utf8.RuneError ||
+		unicode.IsControl(r) ||
+		unicode.In(r,
+			unicode.Cf,
+			unicode.Co,
+			unicode.Zl,
+			unicode.Zp,
+			unicode.Other_Default_Ignorable_Code_Point,
+			unicode.Variation_Selector,
+		)
+}
+
+func isNameRune(r rune, allowDigits bool) bool {
+	switch {
+	case r == ' ', unicode.IsLetter(r), unicode.IsMark(r):
+		return true
+	case unicode.IsDigit(r):
+		return allowDigits
+	}
+	return strings.ContainsRune(namePunctuation, r)
+}
+
+func isSingleScript(name string) bool {
+	seen := map[string]bool{}
+	for _, r := range name {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		if script := scriptOf(r); script != "" {
+			seen[script] = true
+		}
+	}
+
+	if len(seen) <= 1 {
+		return true
+	}
+
+	for _, allowed := range highlyRestrictiveScripts {
+		if containsAll(allowed, seen) {
+			return true
+		}
+	}
+	return false
+}
+
+func scriptOf(r rune) string {
+	if r < utf8.RuneSelf {
+		return "Latin"
+	}
+
+	for name, table := range unicode.Scripts {
+		if name != "Common" && name != "Inherited" && unicode.Is(table, r) {
+			return name
+		}
+	}
+	return ""
+}
+
+func containsAll(allowed []string, scripts map[string]bool) bool {
+	for script := range scripts {
+		found := false
+		for _, a := range allowed {
+			if a == script {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,147 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_ValidateName(t *testing.T) {
+	rules := NameRules{MinLength: 2, MaxLength: 20}
+
+	cases := []struct {
+		name     string
+		input    string
+		rules    NameRules
+		expected string
+		err      error
+	}{
+		{
+			name:     "plain name",
+			input:    "Leo Fender",
+			rules:    rules,
+			expected: "Leo Fender",
+		},
+		{
+			name:     "surrounding and repeated whitespace is collapsed",
+			input:    "  Leo \u00a0  Fender\u3000",
+			rules:    rules,
+			expected: "Leo Fender",
+		},
+		{
+			name:     "decomposed characters are composed",
+			input:    "Bire\u0301li Lagre\u0300ne",
+			rules:    rules,
+			expected: "Biréli Lagrène",
+		},
+		{
+			name:     "apostrophes, hyphens and initials",
+			input:    "Mary-Jo O’Neil Jr.",
+			rules:    rules,
+			expected: "Mary-Jo O’Neil Jr.",
+		},
+		{
+			name:     "Japanese mixes Han and kana",
+			input:    "山田 はなこ",
+			rules:    rules,
+			expected: "山田 はなこ",
+		},
+		{
+			name:     "Korean mixes Han and Hangul",
+			input:    "金 민준",
+			rules:    rules,
+			expected: "金 민준",
+		},
+		{
+			name:     "digits are allowed when the rules allow them",
+			input:    "Leo 2",
+			rules:    NameRules{MinLength: 2, MaxLength: 20, AllowDigits: true},
+			expected: "Leo 2",
+		},
+		{
+			name:  "digits are rejected by default",
+			input: "Leo 2",
+			rules: rules,
+			err:   InvalidNameError("Leo 2"),
+		},
+		{
+			name:  "symbols are rejected",
+			input: "A$@p Rocky",
+			rules: rules,
+			err:   InvalidNameError("A$@p Rocky"),
+		},
+		{
+			name:  "control characters are rejected",
+			input: "Leo\x07Fender",
+			rules: rules,
+			err:   InvalidNameError("Leo\x07Fender"),
+		},
+		{
+			name:  "newlines are rejected rather than collapsed",
+			input: "Leo\nFender",
+			rules: rules,
+			err:   InvalidNameError("Leo\nFender"),
+		},
+		{
+			name:  "zero-width joiner is rejected",
+			input: "Leo\u200dFender",
+			rules: rules,
+			err:   InvalidNameError("Leo\u200dFender"),
+		},
+		{
+			name:  "right-to-left override is rejected",
+			input: "Leo\u202eFender",
+			rules: rules,
+			err:   InvalidNameError("Leo\u202eFender"),
+		},
+		{
+			name:  "Hangul filler is rejected",
+			input: "Leo\u3164",
+			rules: rules,
+			err:   InvalidNameError("Leo\u3164"),
+		},
+		{
+			name:  "Cyrillic lookalike in a Latin name is rejected",
+			input: "L\u0435o Fender",
+			rules: rules,
+			err:   MixedScriptNameError("L\u0435o Fender"),
+		},
+		{
+			name:  "Greek and Latin are rejected",
+			input: "Leo F\u03bfnder",
+			rules: rules,
+			err:   MixedScriptNameError("Leo F\u03bfnder"),
+		},
+		{
+			name:  "too short after trimming",
+			input: "  L  ",
+			rules: rules,
+			err:   NameLengthError("  L  ", 2, 20),
+		},
+		{
+			name:  "too long",
+			input: strings.Repeat("Leo", 7),
+			rules: rules,
+			err:   NameLengthError(strings.Repeat("Leo", 7), 2, 20),
+		},
+		{
+			name:     "length counts code points rather than bytes",
+			input:    strings.Repeat("é", 20),
+			rules:    rules,
+			expected: strings.Repeat("é", 20),
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			res, err := ValidateName(c.input, c.rules)
+
+			AssertErrorsEqual(t, c.err, err)
+
+			if diff := cmp.Diff(c.expected, res); diff != "" {
+				t.Errorf("\nUnexpected name (-want, +got)\n%s", diff)
+			}
+		})
+	}
+}
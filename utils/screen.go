@@ -0,0 +1,234 @@
+package utils
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// NameScreener decides whether a name is fit to be shown to other users,
+// returning an APIError when it is not.
+type NameScreener interface {
+	Screen(name string) error
+}
+
+// NameScreeners runs each screener in turn and returns the first refusal.
+type NameScreeners []NameScreener
+
+func (s NameScreeners) Screen(name string) error {
+	for _, screener := range s {
+		if err := screener.Screen(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var (
+	defaultProfaneWords = []string{
+		"fuck",
+		"shit",
+		"cunt",
+		"bitch",
+		"asshole",
+		"bastard",
+		"whore",
+		"slut",
+		"twat",
+		"wanker",
+	}
+	defaultReservedNames = []string{
+		"admin",
+		"administrator",
+		"moderator",
+		"support",
+		"help desk",
+		"customer service",
+		"fender support",
+		"fender official",
+		"fender staff",
+		"fender team",
+	}
+
+	// homoglyphs maps letters from other scripts that render like Latin letters.
+	homoglyphs = map[rune]rune{
+		'а': 'a', 'в': 'b', 'е': 'e', 'ѕ': 's', 'і': 'i', 'ј': 'j', 'к': 'k',
+		'м': 'm', 'н': 'h', 'о': 'o', 'р': 'p', 'с': 'c', 'т': 't', 'у': 'y',
+		'х': 'x', 'ԁ': 'd', 'ԛ': 'q', 'ԝ': 'w',
+		'α': 'a', 'β': 'b', 'ε': 'e', 'ι': 'i', 'κ': 'k', 'ν': 'v', 'ο': 'o',
+		'ρ': 'p', 'τ': 't', 'υ': 'u', 'χ': 'x',
+	}
+
+	// leetspeak maps digits and symbols standing in for letters. "l" and "i"
+	// fold together since "1" and "|" are used for both.
+	leetspeak = map[rune]rune{
+		'0': 'o', '1': 'i', '!': 'i', '|': 'i', 'l': 'i', '3': 'e', '4': 'a',
+		'@': 'a', '5': 's', '$': 's', '7': 't', '+': 't', '8': 'b',
+	}
+)
+
+func DefaultProfaneWords() []string {
+	return append([]string(nil), defaultProfaneWords...)
+}
+
+func DefaultReservedNames() []string {
+	return append([]string(nil), defaultReservedNames...)
+}
+
+// WordListScreener refuses names containing a listed profane word or naming a
+// reserved identity such as "Fender Support". Names and list entries are
+// compared after folding, so "Fender $upp0rt" and "Fеnder Support" with a
+// Cyrillic "е" are caught, while matching whole words keeps names such as
+// "Hitchcock" or "Cassidy" clear. The defaults leave out words that are also
+// common names, such as "Dick".
+type WordListScreener struct {
+	profane  map[string]struct{}
+	reserved [][]string
+}
+
+func NewWordListScreener(profane []string, reserved []string) *WordListScreener {
+	screener := &WordListScreener{profane: map[string]struct{}{}}
+
+	for _, word := range profane {
+		if folded := strings.Join(FoldName(word), ""); folded != "" {
+			screener.profane[folded] = struct{}{}
+		}
+	}
+
+	for _, name := range reserved {
+		if folded := FoldName(name); len(folded) > 0 {
+			screener.reserved = append(screener.reserved, folded)
+		}
+	}
+
+	return screener
+}
+
+func (s *WordListScreener) Screen(name string) error {
+	words := FoldName(name)
+
+	for _, word := range joinSpelledOut(words) {
+		if _, ok := s.profane[word]; ok {
+			return ProfaneNameError(name)
+		}
+	}
+
+	joined := strings.Join(words, "")
+	for _, reserved := range s.reserved {
+		if containsPhrase(words, reserved) || joined == strings.Join(reserved, "") {
+			return ReservedNameError(name)
+		}
+	}
+
+	return nil
+}
+
+// FoldName reduces a name to lowercase Latin words for comparison: compatibility
+// forms are decomposed, diacritics dropped, homoglyphs and leetspeak mapped to
+// the letters they imitate and runs of a repeated letter collapsed, so "Sh1iit"
+// and "shit" fold alike.
+func FoldName(name string) []string {
+	var words []string
+	var word []rune
+
+	flush := func() {
+		if len(word) > 0 {
+			words = append(words, string(word))
+			word = word[:0]
+		}
+	}
+
+	for _, r := range norm.NFKD.String(strings.ToLower(name)) {
+		if unicode.IsMark(r) {
+			continue
+		}
+		if folded, ok := homoglyphs[r]; ok {
+			r = folded
+		}
+		if folded, ok := leetspeak[r]; ok {
+			r = folded
+		}
+
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			flush()
+			continue
+		}
+		if len(word) > 0 && word[len(word)-1] == r {
+			continue
+		}
+		word = append(word, r)
+	}
+	flush()
+
+	return words
+}
+
+// joinSpelledOut returns the words along with each run of single letters
+// joined up, so "f u c k" is checked as "fuck".
+func joinSpelledOut(words []string) []string {
+	candidates := append([]string(nil), words...)
+
+	var run strings.Builder
+	for _, word := range append(words, "") {
+		if len([]rune(word)) == 1 {
+			run.WriteString(word)
+			continue
+		}
+		if len([]rune(run.String())) > 1 {
+			candidates = append(candidates, run.String())
+		}
+		run.Reset()
+	}
+
+	return candidates
+}
+
+func containsPhrase(words []string, phrase []string) bool {
+	for i := 0; i+len(phrase) <= len(words); i++ {
+		match := true
+		for j := range phrase {
+			if words[i+j] != phrase[j] {
+				match = false
+				break
+			}
+		}
+		if match {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseWordList reads one entry per line, ignoring blank lines and anything
+// after a '#'.
+func ParseWordList(r io.Reader) ([]string, error) {
+	var entries []string
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+
+		if entry := strings.TrimSpace(line); entry != "" {
+			entries = append(entries, entry)
+		}
+	}
+
+	return entries, scanner.Err()
+}
+
+func LoadWordListFile(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return ParseWordList(file)
+}
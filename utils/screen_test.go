@@ -0,0 +1,147 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_WordListScreener(t *testing.T) {
+	screener := NewWordListScreener(DefaultProfaneWords(), DefaultReservedNames())
+
+	cases := []struct {
+		name  string
+		input string
+		err   error
+	}{
+		{
+			name:  "ordinary name",
+			input: "Leo Fender",
+		},
+		{
+			name:  "profane word inside a longer word is allowed",
+			input: "Alfred Hitchcock",
+		},
+		{
+			name:  "profane word",
+			input: "Leo Shit",
+			err:   ProfaneNameError("Leo Shit"),
+		},
+		{
+			name:  "leetspeak",
+			input: "B1tch Please",
+			err:   ProfaneNameError("B1tch Please"),
+		},
+		{
+			name:  "repeated letters and symbols",
+			input: "$h!!!iit Happens",
+			err:   ProfaneNameError("$h!!!iit Happens"),
+		},
+		{
+			name:  "spelled out with spaces",
+			input: "W h o r e",
+			err:   ProfaneNameError("W h o r e"),
+		},
+		{
+			name:  "homoglyphs",
+			input: "B\u0430st\u0430rd",
+			err:   ProfaneNameError("B\u0430st\u0430rd"),
+		},
+		{
+			name:  "fullwidth letters",
+			input: "\uff53\uff4c\uff55\uff54",
+			err:   ProfaneNameError("\uff53\uff4c\uff55\uff54"),
+		},
+		{
+			name:  "reserved name",
+			input: "Fender Support",
+			err:   ReservedNameError("Fender Support"),
+		},
+		{
+			name:  "reserved name within a longer name",
+			input: "Official Fender Support Team",
+			err:   ReservedNameError("Official Fender Support Team"),
+		},
+		{
+			name:  "reserved name with leetspeak and a Cyrillic e",
+			input: "F\u0435nder $upp0rt",
+			err:   ReservedNameError("F\u0435nder $upp0rt"),
+		},
+		{
+			name:  "reserved name without spaces",
+			input: "FenderSupport",
+			err:   ReservedNameError("FenderSupport"),
+		},
+		{
+			name:  "reserved single word",
+			input: "Admin",
+			err:   ReservedNameError("Admin"),
+		},
+		{
+			name:  "reserved word as part of another word is allowed",
+			input: "Badminton Fan",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			AssertErrorsEqual(t, c.err, screener.Screen(c.input))
+		})
+	}
+}
+
+func Test_NameScreeners(t *testing.T) {
+	screeners := NameScreeners{
+		NewWordListScreener(nil, []string{"admin"}),
+		NewWordListScreener([]string{"admin"}, nil),
+	}
+
+	AssertErrorsEqual(t, ReservedNameError("Admin"), screeners.Screen("Admin"))
+	AssertErrorsEqual(t, nil, screeners.Screen("Leo"))
+}
+
+func Test_FoldName(t *testing.T) {
+	cases := []struct {
+		name     string
+		input    string
+		expected []string
+	}{
+		{
+			name:     "lowercases and splits on punctuation",
+			input:    "Mary-Jo O'Neil",
+			expected: []string{"mary", "jo", "o", "nei"},
+		},
+		{
+			name:     "drops diacritics",
+			input:    "Biréli Lagrène",
+			expected: []string{"birei", "iagrene"},
+		},
+		{
+			name:     "collapses repeated letters",
+			input:    "Leeeeo",
+			expected: []string{"ieo"},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if diff := cmp.Diff(c.expected, FoldName(c.input)); diff != "" {
+				t.Errorf("\nUnexpected folding (-want, +got)\n%s", diff)
+			}
+		})
+	}
+}
+
+func Test_ParseWordList(t *testing.T) {
+	input := "# reserved names\nFender Support\n\n  admin  # staff\n"
+
+	res, err := ParseWordList(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff([]string{"Fender Support", "admin"}, res); diff != "" {
+		t.Errorf("\nUnexpected entries (-want, +got)\n%s", diff)
+	}
+}
@@ -0,0 +1,180 @@
+package utils
+
+import "strings"
+
+var (
+	popularEmailDomains = []string{
+		"gmail.com",
+		"yahoo.com",
+		"hotmail.com",
+		"outlook.com",
+		"aol.com",
+		"icloud.com",
+		"live.com",
+		"msn.com",
+		"me.com",
+		"mac.com",
+		"mail.com",
+		"ymail.com",
+		"googlemail.com",
+		"protonmail.com",
+		"proton.me",
+		"gmx.com",
+		"gmx.de",
+		"web.de",
+		"comcast.net",
+		"verizon.net",
+		"att.net",
+		"sbcglobal.net",
+		"yahoo.co.uk",
+		"hotmail.co.uk",
+		"btinternet.com",
+	}
+	popularTLDs = []string{
+		"com",
+		"net",
+		"org",
+		"edu",
+		"gov",
+		"io",
+		"co",
+		"us",
+		"uk",
+		"co.uk",
+		"de",
+		"fr",
+		"ca",
+		"au",
+		"me",
+		"info",
+		"biz",
+	}
+)
+
+func DefaultPopularDomains() []string {
+	return append([]string(nil), popularEmailDomains...)
+}
+
+func DefaultPopularTLDs() []string {
+	return append([]string(nil), popularTLDs...)
+}
+
+// DomainSuggester proposes corrections for mistyped email domains, first
+// against whole popular domains and then against popular TLDs alone. Lists are
+// checked in order, so ties go to the earlier, more popular entry.
+type DomainSuggester struct {
+	domains []string
+	tlds    []string
+	known   map[string]struct{}
+}
+
+func NewDomainSuggester(domains []string, tlds []string) *DomainSuggester {
+	suggester := &DomainSuggester{known: map[string]struct{}{}}
+
+	for _, domain := range domains {
+		if domain = NormalizeDomainEntry(domain); domain != "" {
+			suggester.domains = append(suggester.domains, domain)
+			suggester.known[domain] = struct{}{}
+		}
+	}
+
+	for _, tld := range tlds {
+		if tld = strings.TrimPrefix(NormalizeDomainEntry(tld), "."); tld != "" {
+			suggester.tlds = append(suggester.tlds, tld)
+		}
+	}
+
+	return suggester
+}
+
+// Suggest returns a corrected domain, or "" when the domain looks fine.
+func (s *DomainSuggester) Suggest(domain string) string {
+	domain = NormalizeDomainEntry(domain)
+	if _, ok := s.known[domain]; ok {
+		return ""
+	}
+
+	if suggestion := closest(domain, s.domains); suggestion != "" {
+		return suggestion
+	}
+
+	for _, tld := range s.tlds {
+		if strings.HasSuffix(domain, "."+tld) {
+			return ""
+		}
+	}
+
+	// Longer suffixes go first, so "fender.co.ik" becomes "fender.co.uk"
+	// rather than "fender.co.io".
+	labels := strings.Split(domain, ".")
+	for i := 1; i < len(labels); i++ {
+		if tld := closest(strings.Join(labels[i:], "."), s.tlds); tld != "" {
+			return strings.Join(labels[:i], ".") + "." + tld
+		}
+	}
+
+	return ""
+}
+
+func closest(target string, candidates []string) string {
+	limit := maxSuggestionDistance(target)
+
+	best := ""
+	bestDistance := limit + 1
+	for _, candidate := range candidates {
+		if d := editDistance(target, candidate); d > 0 && d < bestDistance {
+			best = candidate
+			bestDistance = d
+		}
+	}
+
+	return best
+}
+
+func maxSuggestionDistance(s string) int {
+	if len(s) <= 8 {
+		return 1
+	}
+	return 2
+}
+
+// editDistance is the optimal string alignment distance: insertions,
+// deletions, substitutions and transpositions of adjacent characters each
+// cost one, which covers "gmial" as well as "hotmial".
+func editDistance(a string, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	rows := make([][]int, len(ra)+1)
+	for i := range rows {
+		rows[i] = make([]int, len(rb)+1)
+		rows[i][0] = i
+	}
+	for j := range rows[0] {
+		rows[0][j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+
+			rows[i][j] = minInt(rows[i-1][j]+1, rows[i][j-1]+1, rows[i-1][j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				rows[i][j] = minInt(rows[i][j], rows[i-2][j-2]+1)
+			}
+		}
+	}
+
+	return rows[len(ra)][len(rb)]
+}
+
+func minInt(values ...int) int {
+	m := values[0]
+	for _, v := range values[1:] {
+		if v < m {
+			m = v
+		}
+	}
+	return m
+}
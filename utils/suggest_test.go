@@ -0,0 +1,135 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_DomainSuggester_Suggest(t *testing.T) {
+	suggester := NewDomainSuggester(DefaultPopularDomains(), DefaultPopularTLDs())
+
+	cases := []struct {
+		name     string
+		domain   string
+		expected string
+	}{
+		{
+			name:     "no suggestion for a popular domain",
+			domain:   "gmail.com",
+			expected: "",
+		},
+		{
+			name:     "no suggestion for an unrelated domain with a known TLD",
+			domain:   "fender.com",
+			expected: "",
+		},
+		{
+			name:     "transposed letters",
+			domain:   "gmial.com",
+			expected: "gmail.com",
+		},
+		{
+			name:     "mistyped TLD of a popular domain",
+			domain:   "hotmail.con",
+			expected: "hotmail.com",
+		},
+		{
+			name:     "missing letter",
+			domain:   "yaho.com",
+			expected: "yahoo.com",
+		},
+		{
+			name:     "two typos in a long domain",
+			domain:   "hotmial.co",
+			expected: "hotmail.com",
+		},
+		{
+			name:     "mistyped TLD of an unknown domain",
+			domain:   "fender.cmo",
+			expected: "fender.com",
+		},
+		{
+			name:     "mistyped second-level TLD",
+			domain:   "fender.co.ik",
+			expected: "fender.co.uk",
+		},
+		{
+			name:     "no suggestion when nothing is close",
+			domain:   "fender.zzzz",
+			expected: "",
+		},
+		{
+			name:     "matches case-insensitively",
+			domain:   "GMIAL.com",
+			expected: "gmail.com",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			res := suggester.Suggest(c.domain)
+
+			if diff := cmp.Diff(c.expected, res); diff != "" {
+				t.Errorf("\nUnexpected suggestion (-want, +got)\n%s", diff)
+			}
+		})
+	}
+}
+
+func Test_DomainSuggester_configured(t *testing.T) {
+	suggester := NewDomainSuggester([]string{"fender.com"}, []string{".com"})
+
+	cases := []struct {
+		name     string
+		domain   string
+		expected string
+	}{
+		{
+			name:     "uses configured domains",
+			domain:   "fendr.com",
+			expected: "fender.com",
+		},
+		{
+			name:     "ignores domains not configured",
+			domain:   "gmial.com",
+			expected: "",
+		},
+		{
+			name:     "uses configured TLDs",
+			domain:   "tcell.cmo",
+			expected: "tcell.com",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			res := suggester.Suggest(c.domain)
+
+			if diff := cmp.Diff(c.expected, res); diff != "" {
+				t.Errorf("\nUnexpected suggestion (-want, +got)\n%s", diff)
+			}
+		})
+	}
+}
+
+func Test_editDistance(t *testing.T) {
+	cases := []struct {
+		a, b     string
+		expected int
+	}{
+		{"gmail.com", "gmail.com", 0},
+		{"gmial.com", "gmail.com", 1},
+		{"gmal.com", "gmail.com", 1},
+		{"hotmail.con", "hotmail.com", 1},
+		{"", "com", 3},
+	}
+
+	for _, c := range cases {
+		t.Run(c.a+" "+c.b, func(t *testing.T) {
+			if diff := cmp.Diff(c.expected, editDistance(c.a, c.b)); diff != "" {
+				t.Errorf("\nUnexpected distance (-want, +got)\n%s", diff)
+			}
+		})
+	}
+}
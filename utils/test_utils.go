@@ -1,7 +1,14 @@
 package utils
 
 import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"math"
+	"net"
 	"os"
+	"sort"
+	"sync"
 	"testing"
 	"time"
 
@@ -27,6 +34,7 @@ func CreateTestToken(userID string, email string) string {
 	unsignedToken := jwt.NewWithClaims(jwt.GetSigningMethod("HS512"), jwt.MapClaims{
 		"Id":        userID,
 		"ExpiresAt": expiry,
+		"AuthTime":  time.Now().In(time.UTC),
 		"Subject":   email,
 	})
 
@@ -34,6 +42,22 @@ func CreateTestToken(userID string, email string) string {
 	return signedToken
 }
 
+// CreateTestTokenWithPermissions creates a token like Login does for a user
+// holding the given permissions through their roles.
+func CreateTestTokenWithPermissions(userID string, email string, permissions []string) string {
+	expiry := time.Now().In(time.UTC).Add(time.Hour * 12)
+	unsignedToken := jwt.NewWithClaims(jwt.GetSigningMethod("HS512"), jwt.MapClaims{
+		"Id":          userID,
+		"ExpiresAt":   expiry,
+		"AuthTime":    time.Now().In(time.UTC),
+		"Subject":     email,
+		"Permissions": permissions,
+	})
+
+	signedToken, _ := unsignedToken.SignedString([]byte(os.Getenv("SigningSecret")))
+	return signedToken
+}
+
 func CreateTestAuthHeader(token string, contentType string) map[string]string {
 	tokenValue := "bearer " + token
 
@@ -42,3 +66,183 @@ func CreateTestAuthHeader(token string, contentType string) map[string]string {
 		"Authorization": tokenValue,
 	}
 }
+
+type InMemoryResolver struct {
+	MX       map[string][]*net.MX
+	Hosts    map[string][]string
+	Failures map[string]error
+	Lookups  int
+	mu       sync.Mutex
+}
+
+func (r *InMemoryResolver) countLookup() {
+	r.mu.Lock()
+	r.Lookups++
+	r.mu.Unlock()
+}
+
+func (r *InMemoryResolver) LookupMX(ctx context.Context, name string) ([]*net.MX, error) {
+	r.countLookup()
+	if err, ok := r.Failures[name]; ok {
+		return nil, err
+	}
+
+	if mxs, ok := r.MX[name]; ok {
+		return mxs, nil
+	}
+
+	return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+}
+
+func (r *InMemoryResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	r.countLookup()
+	if err, ok := r.Failures[host]; ok {
+		return nil, err
+	}
+
+	if hosts, ok := r.Hosts[host]; ok {
+		return hosts, nil
+	}
+
+	return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+}
+
+// InMemoryMailer records mail instead of sending it.
+type InMemoryMailer struct {
+	mu   sync.Mutex
+	Sent []Mail
+}
+
+func (m *InMemoryMailer) Send(mail Mail) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.Sent = append(m.Sent, mail)
+	return nil
+}
+
+func (m *InMemoryMailer) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.Sent = nil
+}
+
+// BuildTestGeoDB writes a MaxMind DB with 24-bit records that places each
+// CIDR network at the given location. IPv4 networks in an IPv6 database are
+// stored under ::/96, as in the published databases.
+func BuildTestGeoDB(ipVersion int, networks map[string]GeoLocation) []byte {
+	const empty, dataRecord = -1, -2
+
+	nodes := [][2]int{{empty, empty}}
+	var data bytes.Buffer
+
+	cidrs := make([]string, 0, len(networks))
+	for cidr := range networks {
+		cidrs = append(cidrs, cidr)
+	}
+	sort.Strings(cidrs)
+
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		ones, _ := network.Mask.Size()
+
+		var bits []int
+		address := network.IP
+		if ip4 := address.To4(); ip4 != nil {
+			address = ip4
+			if ipVersion == 6 {
+				bits = make([]int, 96)
+			}
+		}
+		for i := 0; i < ones; i++ {
+			bits = append(bits, int(address[i/8]>>(7-uint(i%8)))&1)
+		}
+
+		node := 0
+		for _, bit := range bits[:len(bits)-1] {
+			if nodes[node][bit] == empty {
+				nodes = append(nodes, [2]int{empty, empty})
+				nodes[node][bit] = len(nodes) - 1
+			}
+			node = nodes[node][bit]
+		}
+		nodes[node][bits[len(bits)-1]] = dataRecord - data.Len()
+
+		location := networks[cidr]
+		fields := map[string][]byte{
+			"country": geoTestMap(map[string][]byte{"iso_code": geoTestString(location.Country)}),
+		}
+		if location.HasCoordinates {
+			fields["location"] = geoTestMap(map[string][]byte{
+				"latitude":  geoTestDouble(location.Latitude),
+				"longitude": geoTestDouble(location.Longitude),
+			})
+		}
+		data.Write(geoTestMap(fields))
+	}
+
+	var buf bytes.Buffer
+	for _, node := range nodes {
+		for _, record := range node {
+			value := record
+			switch {
+			case record == empty:
+				value = len(nodes)
+			case record <= dataRecord:
+				value = len(nodes) + 16 + dataRecord - record
+			}
+			buf.Write([]byte{byte(value >> 16), byte(value >> 8), byte(value)})
+		}
+	}
+
+	buf.Write(make([]byte, 16))
+	buf.Write(data.Bytes())
+	buf.Write(geoMetadataMarker)
+	buf.Write(geoTestMap(map[string][]byte{
+		"node_count":                  geoTestUint(geoTypeUint32, uint64(len(nodes))),
+		"record_size":                 geoTestUint(geoTypeUint16, 24),
+		"ip_version":                  geoTestUint(geoTypeUint16, uint64(ipVersion)),
+		"database_type":               geoTestString("Test-City"),
+		"binary_format_major_version": geoTestUint(geoTypeUint16, 2),
+		"binary_format_minor_version": geoTestUint(geoTypeUint16, 0),
+	}))
+
+	return buf.Bytes()
+}
+
+func geoTestString(s string) []byte {
+	return append([]byte{byte(geoTypeString<<5 | len(s))}, s...)
+}
+
+func geoTestDouble(f float64) []byte {
+	b := []byte{byte(geoTypeDouble<<5 | 8), 0, 0, 0, 0, 0, 0, 0, 0}
+	binary.BigEndian.PutUint64(b[1:], math.Float64bits(f))
+	return b
+}
+
+func geoTestUint(kind int, n uint64) []byte {
+	var b []byte
+	for ; n > 0; n >>= 8 {
+		b = append([]byte{byte(n)}, b...)
+	}
+	return append([]byte{byte(kind<<5 | len(b))}, b...)
+}
+
+func geoTestMap(fields map[string][]byte) []byte {
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	b := []byte{byte(geoTypeMap<<5 | len(fields))}
+	for _, key := range keys {
+		b = append(b, geoTestString(key)...)
+		b = append(b, fields[key]...)
+	}
+	return b
+}
@@ -2,7 +2,6 @@ package utils
 
 import (
 	"regexp"
-	"strings"
 
 	"github.com/trustelem/zxcvbn"
 	"golang.org/x/crypto/bcrypt"
@@ -11,7 +10,6 @@ import (
 var (
 	bcryptGenerationCost   = 14
 	aliasRegexp            = regexp.MustCompile(`\+`)
-	emailRegexp            = regexp.MustCompile("(?i)([A-Z0-9._%+-]+@[A-Z0-9.-]+\\.[A-Z]{2,24})")
 	prohibitedEmailDomains = []string{
 		"0box.eu",
 		"10minutemail.com",
@@ -54,13 +52,9 @@ var (
 		"wegwerfmail.org",
 		"yopmail.com",
 	}
+	DisposableDomains = NewDomainBlocklist(prohibitedEmailDomains, nil)
 )
 
-type Email struct {
-	LocalPart string
-	Domain    string
-}
-
 func IsAliasedEmail(email string) bool {
 	return aliasRegexp.Match([]byte(email))
 }
@@ -70,26 +64,11 @@ func PasswordStrength(password string) int {
 }
 
 func IsKnownSpamEmail(email Email) bool {
-	for _, domain := range prohibitedEmailDomains {
-		if email.Domain == domain {
-			return true
-		}
-	}
-
-	return false
+	return DisposableDomains.IsBlocked(email.Domain)
 }
 
-func ParseEmail(email string) (Email, error) {
-	if !emailRegexp.MatchString(email) {
-		return Email{}, InvalidEmailError(email)
-	}
-
-	if IsAliasedEmail(email) {
-		return Email{}, AliasedEmailError(email)
-	}
-
-	i := strings.LastIndex(email, "@")
-	return Email{LocalPart: email[:i], Domain: email[i+1:]}, nil
+func DefaultProhibitedEmailDomains() []string {
+	return append([]string(nil), prohibitedEmailDomains...)
 }
 
 type Credential struct {
@@ -111,6 +111,11 @@ func Test_isKnownSpamEmail(t *testing.T) {
 			input: Email{LocalPart: "leo", Domain: "mailforspam.com"},
 			want:  true,
 		},
+		{
+			name:  "detects subdomain of known bad domain as spam",
+			input: Email{LocalPart: "leo", Domain: "x.mailinator.com"},
+			want:  true,
+		},
 	}
 
 	for _, c := range cases {
@@ -165,9 +170,10 @@ func Test_parseEmail(t *testing.T) {
 			err:   InvalidEmailError("leo@fender"),
 		},
 		{
-			name:  "contains alias",
-			input: "leo+fender@fender.com",
-			err:   AliasedEmailError("leo+fender@fender.com"),
+			name:     "contains alias",
+			input:    "leo+fender@fender.com",
+			err:      nil,
+			expected: Email{LocalPart: "leo+fender", Domain: "fender.com"},
 		},
 	}
 
@@ -183,3 +189,16 @@ func Test_parseEmail(t *testing.T) {
 		})
 	}
 }
+
+func Test_ReusedPasswordError(t *testing.T) {
+	cases := map[int]string{
+		0: "new password matches the current password, choose a different password",
+		3: "new password matches the current password or one of the 3 before it, choose a different password",
+	}
+
+	for depth, expected := range cases {
+		if diff := cmp.Diff(expected, ReusedPasswordError(depth).(APIError).Message); diff != "" {
+			t.Errorf("\nUnexpected message for depth %d (-want, +got)\n%s", depth, diff)
+		}
+	}
+}